@@ -0,0 +1,268 @@
+package cache
+
+import (
+	"context"
+	"crypto/sha1"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// SCRIPT_COMPARE_AND_SWAP sets KEYS[1] to ARGV[2] if the version of its value is still ARGV[1], empty for a missing key.
+// The version of a value is the first 8 bytes of its sha1. It returns 1 if stored, 0 on conflict and -1 if the key is gone
+const SCRIPT_COMPARE_AND_SWAP = `local current = redis.call('get', KEYS[1])
+if not current then
+	if ARGV[1] ~= '' then
+		return -1
+	end
+elseif ARGV[1] == '' or string.sub(redis.sha1hex(current), 1, 16) ~= ARGV[1] then
+	return 0
+end
+
+if tonumber(ARGV[3]) > 0 then
+	redis.call('set', KEYS[1], ARGV[2], 'PX', ARGV[3])
+else
+	redis.call('set', KEYS[1], ARGV[2])
+end
+return 1`
+
+var compareAndSwapScript = redis.NewScript(SCRIPT_COMPARE_AND_SWAP)
+
+// casBytesGetter is implemented by the drivers whose typed optimistic updates decode the stored bytes with their codec
+type casBytesGetter interface {
+	GetBytesWithVersion(key string) ([]byte, uint64, error)
+	GetCodec() Codec
+}
+
+// Update reads key as T, applies fn to it and writes the result back with CompareAndSwap.
+// When another writer changed key meanwhile, it starts over up to retries times before returning ErrCASConflict
+func Update[T any](c CASCacheInterface, key string, ttl time.Duration, retries int, fn func(current T, found bool) (T, error)) (value T, err error) {
+	for attempt := 0; ; attempt++ {
+		current, version, err := getWithVersion[T](c, key)
+		if err != nil && err != ErrCacheMiss {
+			return value, err
+		}
+
+		value, err = fn(current, err == nil)
+		if err != nil {
+			return value, err
+		}
+
+		err = c.CompareAndSwap(key, value, version, ttl)
+		if (err != ErrCASConflict && err != ErrNotStored) || attempt >= retries {
+			return value, err
+		}
+	}
+}
+
+func getWithVersion[T any](c CASCacheInterface, key string) (value T, version uint64, err error) {
+	if getter, ok := c.(casBytesGetter); ok {
+		b, version, err := getter.GetBytesWithVersion(key)
+		if err != nil {
+			return value, 0, err
+		}
+		err = getter.GetCodec().Unmarshal(b, &value)
+		return value, version, err
+	}
+
+	obj, version, err := c.GetWithVersion(key, nil)
+	if err != nil {
+		return value, 0, err
+	}
+	if typed, ok := obj.(T); ok {
+		return typed, version, nil
+	}
+
+	b, err := json.Marshal(obj)
+	if err != nil {
+		return value, 0, err
+	}
+	err = json.Unmarshal(b, &value)
+	return value, version, err
+}
+
+// versionOf returns the version of a value stored in redis, the same as SCRIPT_COMPARE_AND_SWAP computes
+func versionOf(b []byte) uint64 {
+	sum := sha1.Sum(b)
+	return binary.BigEndian.Uint64(sum[:8])
+}
+
+func (gr *GRedis) GetWithVersion(key string, defaultValue interface{}) (ptrValue interface{}, version uint64, err error) {
+	return gr.GetWithVersionCtx(CTXRedis, key, defaultValue)
+}
+
+// GetWithVersionCtx returns the value of key, its version is derived from the stored bytes
+// so that any write, even by a client unaware of the versions, changes it
+func (gr *GRedis) GetWithVersionCtx(ctx context.Context, key string, defaultValue interface{}) (ptrValue interface{}, version uint64, err error) {
+	b, version, err := gr.GetBytesWithVersionCtx(ctx, key)
+	if err == ErrCacheMiss {
+		return defaultValue, 0, err
+	}
+	if err != nil {
+		return nil, 0, err
+	}
+
+	err = gr.GetCodec().Unmarshal(b, &ptrValue)
+	return ptrValue, version, err
+}
+
+func (gr *GRedis) GetBytesWithVersion(key string) ([]byte, uint64, error) {
+	return gr.GetBytesWithVersionCtx(CTXRedis, key)
+}
+
+func (gr *GRedis) GetBytesWithVersionCtx(ctx context.Context, key string) (b []byte, version uint64, err error) {
+	ctx, finish := gr.hooks.start(ctx, DriverRedis, OperationGetWithVersion, key)
+	defer func() { finish(err) }()
+
+	b, err = gr.Pool.Get(ctx, gr.itemKey(key)).Bytes()
+	if err == redis.Nil {
+		return nil, 0, ErrCacheMiss
+	}
+	if err != nil {
+		return nil, 0, err
+	}
+	return b, versionOf(b), nil
+}
+
+func (gr *GRedis) CompareAndSwap(key string, value interface{}, version uint64, ttl time.Duration) error {
+	return gr.CompareAndSwapCtx(CTXRedis, key, value, version, ttl)
+}
+
+// CompareAndSwapCtx checks the version and stores value atomically with a Lua script.
+// A zero ttl means the default expiration and a negative one never expires, the same as Set
+func (gr *GRedis) CompareAndSwapCtx(ctx context.Context, key string, value interface{}, version uint64, ttl time.Duration) (err error) {
+	ctx, finish := gr.hooks.start(ctx, DriverRedis, OperationCompareAndSwap, key)
+	defer func() { finish(err) }()
+
+	mValue, err := gr.GetCodec().Marshal(value)
+	if err != nil {
+		return err
+	}
+
+	expected := ""
+	if version != 0 {
+		expected = fmt.Sprintf("%016x", version)
+	}
+	stored, err := gr.RunScript(ctx, compareAndSwapScript, []string{key}, expected, mValue, gr.expiration(ttl).Milliseconds()).Int64()
+	if err != nil {
+		return err
+	}
+
+	switch stored {
+	case 0:
+		return ErrCASConflict
+	case -1:
+		return ErrNotStored
+	}
+	return gr.invalidated(ctx, nil, key)
+}
+
+func (cache *MemCache) GetWithVersion(key string, defaultValue interface{}) (ptrValue interface{}, version uint64, err error) {
+	return cache.GetWithVersionCtx(CTXRedis, key, defaultValue)
+}
+
+func (cache *MemCache) GetWithVersionCtx(ctx context.Context, key string, defaultValue interface{}) (ptrValue interface{}, version uint64, err error) {
+	b, version, err := cache.GetBytesWithVersionCtx(ctx, key)
+	if err == ErrCacheMiss {
+		return defaultValue, 0, err
+	}
+	if err != nil {
+		return nil, 0, err
+	}
+
+	err = cache.GetCodec().Unmarshal(b, &ptrValue)
+	return ptrValue, version, err
+}
+
+func (cache *MemCache) GetBytesWithVersion(key string) ([]byte, uint64, error) {
+	return cache.GetBytesWithVersionCtx(CTXRedis, key)
+}
+
+func (cache *MemCache) GetBytesWithVersionCtx(ctx context.Context, key string) (b []byte, version uint64, err error) {
+	ctx, finish := cache.hooks.start(ctx, DriverMemory, OperationGetWithVersion, key)
+	defer func() { finish(err) }()
+
+	if err = ctx.Err(); err != nil {
+		return nil, 0, err
+	}
+
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+
+	item := cache.getItem(key)
+	if item == nil {
+		return nil, 0, ErrCacheMiss
+	}
+	return item.Value, item.Version, nil
+}
+
+func (cache *MemCache) CompareAndSwap(key string, value interface{}, version uint64, ttl time.Duration) error {
+	return cache.CompareAndSwapCtx(CTXRedis, key, value, version, ttl)
+}
+
+// CompareAndSwapCtx stores value if the version of key is unchanged, the ttl follows the rules of Set
+func (cache *MemCache) CompareAndSwapCtx(ctx context.Context, key string, value interface{}, version uint64, ttl time.Duration) (err error) {
+	ctx, finish := cache.hooks.start(ctx, DriverMemory, OperationCompareAndSwap, key)
+	defer func() { finish(err) }()
+
+	if err = ctx.Err(); err != nil {
+		return err
+	}
+
+	mValue, err := cache.GetCodec().Marshal(value)
+	if err != nil {
+		return err
+	}
+
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+
+	item := cache.getItem(key)
+	if item == nil && version != 0 {
+		return ErrNotStored
+	}
+	if item != nil && item.Version != version {
+		return ErrCASConflict
+	}
+
+	return cache.setItem(key, mValue, cache.expiration(ttl))
+}
+
+func (lc *LayeredCache) GetWithVersion(key string, defaultValue interface{}) (ptrValue interface{}, version uint64, err error) {
+	return lc.GetWithVersionCtx(CTXRedis, key, defaultValue)
+}
+
+// GetWithVersionCtx reads redis directly, the near tier may hold an outdated value
+func (lc *LayeredCache) GetWithVersionCtx(ctx context.Context, key string, defaultValue interface{}) (ptrValue interface{}, version uint64, err error) {
+	ctx, finish := lc.hooks.start(ctx, DriverLayered, OperationGetWithVersion, key)
+	defer func() { finish(err) }()
+
+	return lc.far.GetWithVersionCtx(ctx, key, defaultValue)
+}
+
+func (lc *LayeredCache) GetBytesWithVersion(key string) ([]byte, uint64, error) {
+	return lc.GetBytesWithVersionCtx(CTXRedis, key)
+}
+
+// GetBytesWithVersionCtx reads redis directly, the near tier may hold an outdated value
+func (lc *LayeredCache) GetBytesWithVersionCtx(ctx context.Context, key string) (b []byte, version uint64, err error) {
+	ctx, finish := lc.hooks.start(ctx, DriverLayered, OperationGetWithVersion, key)
+	defer func() { finish(err) }()
+
+	return lc.far.GetBytesWithVersionCtx(ctx, key)
+}
+
+func (lc *LayeredCache) CompareAndSwap(key string, value interface{}, version uint64, ttl time.Duration) error {
+	return lc.CompareAndSwapCtx(CTXRedis, key, value, version, ttl)
+}
+
+func (lc *LayeredCache) CompareAndSwapCtx(ctx context.Context, key string, value interface{}, version uint64, ttl time.Duration) (err error) {
+	ctx, finish := lc.hooks.start(ctx, DriverLayered, OperationCompareAndSwap, key)
+	defer func() { finish(err) }()
+
+	defer lc.near.Delete(key)
+	return lc.far.CompareAndSwapCtx(ctx, key, value, version, ttl)
+}
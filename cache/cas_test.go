@@ -0,0 +1,124 @@
+package cache
+
+import (
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+	"sync"
+	"testing"
+	"time"
+)
+
+func Test_CompareAndSwap(t *testing.T) {
+	drivers := map[string]func(t *testing.T) CASCacheInterface{
+		"memory": func(t *testing.T) CASCacheInterface { return newTestMemCache(t) },
+		"redis":  func(t *testing.T) CASCacheInterface { return getTestGRedis(t) },
+	}
+
+	for name, newCache := range drivers {
+		t.Run(name, func(t *testing.T) {
+			t.Run("Create", func(t *testing.T) {
+				c := newCache(t)
+
+				value, version, err := c.GetWithVersion("key", "default")
+				assert.Equal(t, ErrCacheMiss, err)
+				assert.Equal(t, "default", value)
+				assert.Equal(t, uint64(0), version)
+
+				assert.NoError(t, c.CompareAndSwap("key", "first", 0, time.Minute))
+				// the key exists now, creating it again conflicts
+				assert.Equal(t, ErrCASConflict, c.CompareAndSwap("key", "second", 0, time.Minute))
+
+				value, version, err = c.GetWithVersion("key", nil)
+				assert.NoError(t, err)
+				assert.Equal(t, "first", value)
+				assert.NotEqual(t, uint64(0), version)
+			})
+
+			t.Run("Conflict", func(t *testing.T) {
+				c := newCache(t)
+
+				assert.NoError(t, c.Set("key", "first", time.Minute))
+				_, version, err := c.GetWithVersion("key", nil)
+				assert.NoError(t, err)
+
+				assert.NoError(t, c.Set("key", "changed", time.Minute))
+				assert.Equal(t, ErrCASConflict, c.CompareAndSwap("key", "second", version, time.Minute))
+
+				_, version, err = c.GetWithVersion("key", nil)
+				assert.NoError(t, err)
+				assert.NoError(t, c.CompareAndSwap("key", "second", version, time.Minute))
+
+				value, err := c.Get("key", nil)
+				assert.NoError(t, err)
+				assert.Equal(t, "second", value)
+			})
+
+			t.Run("NotStored", func(t *testing.T) {
+				c := newCache(t)
+
+				assert.NoError(t, c.Set("key", "first", time.Minute))
+				_, version, err := c.GetWithVersion("key", nil)
+				assert.NoError(t, err)
+
+				assert.NoError(t, c.Delete("key"))
+				assert.Equal(t, ErrNotStored, c.CompareAndSwap("key", "second", version, time.Minute))
+				assert.False(t, c.Has("key"))
+			})
+
+			t.Run("Update", func(t *testing.T) {
+				c := newCache(t)
+
+				wg := sync.WaitGroup{}
+				for i := 0; i < 20; i++ {
+					wg.Add(1)
+					go func() {
+						defer wg.Done()
+						_, err := Update[int](c, "counter", time.Minute, 100, func(current int, found bool) (int, error) {
+							return current + 1, nil
+						})
+						assert.NoError(t, err)
+					}()
+				}
+				wg.Wait()
+
+				counter, err := Get[int](c, "counter")
+				assert.NoError(t, err)
+				assert.Equal(t, 20, counter)
+			})
+		})
+	}
+}
+
+func Test_CompareAndSwapTTL(t *testing.T) {
+	server := miniredis.RunT(t)
+	gr := NewGRedis(&redis.UniversalOptions{Addrs: []string{server.Addr()}})
+
+	// the ttl follows the rules of Set
+	assert.NoError(t, gr.CompareAndSwap("unset", "value", 0, 0))
+	assert.Equal(t, time.Duration(0), server.TTL("unset"))
+	gr.SetDefaultExpiration(time.Minute)
+	assert.NoError(t, gr.CompareAndSwap("zero", "value", 0, 0))
+	assert.Equal(t, time.Minute, server.TTL("zero"))
+	assert.NoError(t, gr.CompareAndSwap("negative", "value", 0, -1))
+	assert.Equal(t, time.Duration(0), server.TTL("negative"))
+
+	memCache := newTestMemCache(t)
+	assert.NoError(t, memCache.CompareAndSwap("negative", "value", 0, -1))
+	assert.Equal(t, int64(0), memCache.items["negative"].Value.(*memItem).Expiration)
+}
+
+func Test_LayeredCacheUpdateHooks(t *testing.T) {
+	lc := newTestLayeredCache(t, miniredis.RunT(t))
+
+	_, err := Update[int](lc, "counter", time.Minute, 0, func(current int, found bool) (int, error) {
+		return current + 1, nil
+	})
+	assert.NoError(t, err)
+
+	// the versioned read of Update goes through the hooks of the layered cache
+	stats, err := lc.Stats()
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(1), stats.Latencies[OperationGetWithVersion].Count)
+	assert.Equal(t, uint64(1), stats.Latencies[OperationCompareAndSwap].Count)
+}
@@ -0,0 +1,81 @@
+package cache
+
+import (
+	"bytes"
+	"encoding/gob"
+	"encoding/json"
+
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+// Codec encodes the values a driver writes to its storage and decodes them back
+type Codec interface {
+	Marshal(value interface{}) ([]byte, error)
+	Unmarshal(data []byte, ptrValue interface{}) error
+}
+
+// CodecCacheInterface is implemented by the drivers whose values are encoded by a pluggable Codec,
+// the typed Get and Remember use it to decode the stored bytes straight into the caller's type
+type CodecCacheInterface interface {
+	CacheInterface
+
+	SetCodec(codec Codec)
+	GetCodec() Codec
+
+	// GetBytes returns the encoded value of key, or ErrCacheMiss
+	GetBytes(key string) ([]byte, error)
+}
+
+var (
+	// JsonCodec is the default codec of all drivers
+	JsonCodec Codec = jsonCodec{}
+
+	// GobCodec keeps the concrete go types, the values must be read back with the typed Get or Remember,
+	// because gob cannot decode a concrete type into interface{}
+	GobCodec Codec = gobCodec{}
+
+	// MsgpackCodec is a compact binary codec, its values can be decoded into interface{} as well as typed values
+	MsgpackCodec Codec = msgpackCodec{}
+)
+
+type jsonCodec struct{}
+
+func (jsonCodec) Marshal(value interface{}) ([]byte, error) {
+	return json.Marshal(value)
+}
+
+func (jsonCodec) Unmarshal(data []byte, ptrValue interface{}) error {
+	return json.Unmarshal(data, ptrValue)
+}
+
+type gobCodec struct{}
+
+func (gobCodec) Marshal(value interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	err := gob.NewEncoder(&buf).Encode(value)
+	if err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (gobCodec) Unmarshal(data []byte, ptrValue interface{}) error {
+	return gob.NewDecoder(bytes.NewReader(data)).Decode(ptrValue)
+}
+
+type msgpackCodec struct{}
+
+func (msgpackCodec) Marshal(value interface{}) ([]byte, error) {
+	return msgpack.Marshal(value)
+}
+
+func (msgpackCodec) Unmarshal(data []byte, ptrValue interface{}) error {
+	return msgpack.Unmarshal(data, ptrValue)
+}
+
+func getCodec(codec Codec) Codec {
+	if codec == nil {
+		return JsonCodec
+	}
+	return codec
+}
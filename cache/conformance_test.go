@@ -0,0 +1,228 @@
+package cache
+
+import (
+	"errors"
+	"github.com/dadiYazZ/xin-da-libs/object"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+// testCacheConformance is the shared suite every CacheInterface driver must pass,
+// newCache should return an empty cache for each call
+func testCacheConformance(t *testing.T, newCache func(t *testing.T) CacheInterface) {
+
+	data := map[string]interface{}{
+		"string": "value",
+		"bool":   false,
+	}
+
+	t.Run("GetMiss", func(t *testing.T) {
+		c := newCache(t)
+
+		value, err := c.Get("missing", "default")
+		assert.Equal(t, ErrCacheMiss, err)
+		assert.Equal(t, "default", value)
+		assert.False(t, c.Has("missing"))
+	})
+
+	t.Run("SetGet", func(t *testing.T) {
+		c := newCache(t)
+
+		assert.NoError(t, c.Set("key", data, time.Minute))
+		value, err := c.Get("key", nil)
+		assert.NoError(t, err)
+		assert.Equal(t, data, value)
+		assert.True(t, c.Has("key"))
+	})
+
+	t.Run("Delete", func(t *testing.T) {
+		c := newCache(t)
+
+		assert.NoError(t, c.Set("key", "value", time.Minute))
+		assert.NoError(t, c.Delete("key"))
+		_, err := c.Get("key", nil)
+		assert.Equal(t, ErrCacheMiss, err)
+
+		// deleting a missing key is not an error
+		assert.NoError(t, c.Delete("key"))
+	})
+
+	t.Run("Forget", func(t *testing.T) {
+		c := newCache(t)
+
+		assert.NoError(t, c.Set("key", "value", time.Minute))
+		assert.NoError(t, c.Forget("key"))
+		assert.False(t, c.Has("key"))
+	})
+
+	t.Run("Clear", func(t *testing.T) {
+		c := newCache(t)
+
+		assert.NoError(t, c.Set("a", "1", time.Minute))
+		assert.NoError(t, c.Set("b", "2", time.Minute))
+		assert.NoError(t, c.Clear())
+		assert.False(t, c.Has("a"))
+		assert.False(t, c.Has("b"))
+	})
+
+	t.Run("Multiple", func(t *testing.T) {
+		c := newCache(t)
+
+		assert.NoError(t, c.SetMultiple(object.HashMap{
+			"a": "1",
+			"b": float64(2),
+		}, time.Minute))
+
+		values, err := c.GetMultiple([]string{"a", "b", "c"}, "default")
+		assert.NoError(t, err)
+		assert.Equal(t, object.HashMap{
+			"a": "1",
+			"b": float64(2),
+			"c": "default",
+		}, values)
+
+		assert.NoError(t, c.DeleteMultiple([]string{"a", "b"}))
+		values, err = c.GetMultiple([]string{"a", "b"}, nil)
+		assert.NoError(t, err)
+		assert.Equal(t, object.HashMap{"a": nil, "b": nil}, values)
+	})
+
+	t.Run("Pull", func(t *testing.T) {
+		c := newCache(t)
+
+		assert.NoError(t, c.Set("key", "value", time.Minute))
+		value, err := c.Pull("key", nil)
+		assert.NoError(t, err)
+		assert.Equal(t, "value", value)
+		assert.False(t, c.Has("key"))
+
+		value, err = c.Pull("key", "default")
+		assert.Equal(t, ErrCacheMiss, err)
+		assert.Equal(t, "default", value)
+	})
+
+	t.Run("IncrementDecrement", func(t *testing.T) {
+		c := newCache(t)
+
+		// a missing key starts from 0
+		count, err := c.Increment("counter", 5)
+		assert.NoError(t, err)
+		assert.Equal(t, int64(5), count)
+
+		count, err = c.Decrement("counter", 2)
+		assert.NoError(t, err)
+		assert.Equal(t, int64(3), count)
+
+		// the counter is readable as a regular cache value
+		value, err := c.Get("counter", nil)
+		assert.NoError(t, err)
+		assert.Equal(t, float64(3), value)
+
+		assert.NoError(t, c.Set("string", "value", time.Minute))
+		_, err = c.Increment("string", 1)
+		assert.True(t, errors.Is(err, ErrInvalidValue))
+	})
+
+	t.Run("AddNX", func(t *testing.T) {
+		c := newCache(t)
+
+		assert.True(t, c.AddNX("key", "first", time.Minute))
+		assert.False(t, c.AddNX("key", "second", time.Minute))
+		value, err := c.Get("key", nil)
+		assert.NoError(t, err)
+		assert.Equal(t, "first", value)
+	})
+
+	t.Run("Add", func(t *testing.T) {
+		c := newCache(t)
+
+		assert.NoError(t, c.Add("key", data, time.Minute))
+		assert.Equal(t, ErrNotStored, c.Add("key", "second", time.Minute))
+		value, err := c.Get("key", nil)
+		assert.NoError(t, err)
+		assert.Equal(t, data, value)
+	})
+
+	t.Run("Remember", func(t *testing.T) {
+		c := newCache(t)
+
+		calls := 0
+		callback := func() (interface{}, error) {
+			calls++
+			return data, nil
+		}
+
+		value, err := c.Remember("key", time.Minute, callback)
+		assert.NoError(t, err)
+		assert.Equal(t, data, value)
+
+		value, err = c.Remember("key", time.Minute, callback)
+		assert.NoError(t, err)
+		assert.Equal(t, data, value)
+		assert.Equal(t, 1, calls)
+
+		_, err = c.Remember("failed", time.Minute, func() (interface{}, error) {
+			return nil, ErrServerError
+		})
+		assert.Equal(t, ErrServerError, err)
+		assert.False(t, c.Has("failed"))
+	})
+
+	t.Run("Forever", func(t *testing.T) {
+		c := newCache(t)
+
+		assert.NoError(t, c.Forever("key", data))
+		value, err := c.Get("key", nil)
+		assert.NoError(t, err)
+		assert.Equal(t, data, value)
+	})
+
+	t.Run("RememberForever", func(t *testing.T) {
+		c := newCache(t)
+
+		calls := 0
+		callback := func() (interface{}, error) {
+			calls++
+			return data, nil
+		}
+
+		value, err := c.RememberForever("key", callback)
+		assert.NoError(t, err)
+		assert.Equal(t, data, value)
+
+		value, err = c.RememberForever("key", callback)
+		assert.NoError(t, err)
+		assert.Equal(t, data, value)
+		assert.Equal(t, 1, calls)
+
+		_, err = c.RememberForever("failed", func() (interface{}, error) {
+			return nil, ErrServerError
+		})
+		assert.Equal(t, ErrServerError, err)
+		assert.False(t, c.Has("failed"))
+	})
+
+	// a zero ttl means the default life time of the driver and a negative one never expires
+	t.Run("ZeroAndNegativeTTL", func(t *testing.T) {
+		for _, ttl := range []time.Duration{0, -1, -time.Minute} {
+			c := newCache(t)
+
+			assert.NoError(t, c.Set("set", "value", ttl))
+			assert.NoError(t, c.Add("add", "value", ttl))
+			assert.True(t, c.AddNX("addnx", "value", ttl))
+			assert.NoError(t, c.SetMultiple(object.HashMap{"multiple": "value"}, ttl))
+			value, err := c.Remember("remember", ttl, func() (interface{}, error) {
+				return "value", nil
+			})
+			assert.NoError(t, err, ttl)
+			assert.Equal(t, "value", value)
+
+			for _, key := range []string{"set", "add", "addnx", "multiple", "remember"} {
+				value, err = c.Get(key, nil)
+				assert.NoError(t, err, key, ttl)
+				assert.Equal(t, "value", value, key, ttl)
+			}
+		}
+	})
+}
@@ -0,0 +1,132 @@
+package cache
+
+import (
+	"context"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	OperationGet             = "get"
+	OperationSet             = "set"
+	OperationHas             = "has"
+	OperationAdd             = "add"
+	OperationRemember        = "remember"
+	OperationDelete          = "delete"
+	OperationClear           = "clear"
+	OperationGetMultiple     = "get_multiple"
+	OperationSetMultiple     = "set_multiple"
+	OperationDeleteMultiple  = "delete_multiple"
+	OperationPull            = "pull"
+	OperationIncrement       = "increment"
+	OperationForever         = "forever"
+	OperationRememberForever = "remember_forever"
+	OperationGetWithVersion  = "get_with_version"
+	OperationCompareAndSwap  = "compare_and_swap"
+	OperationInvalidate      = "invalidate"
+	// OperationInvalidation is an invalidation message received by the near tier of a LayeredCache
+	OperationInvalidation = "invalidation"
+)
+
+const (
+	DriverMemory  = "memory"
+	DriverRedis   = "redis"
+	DriverLayered = "layered"
+)
+
+// Operation describes a cache operation to the hooks
+type Operation struct {
+	Driver string
+	Name   string
+	Keys   []string
+	Start  time.Time
+	// Err is the result of the operation, ErrCacheMiss for a miss
+	Err error
+	// Misses is the number of keys a GetMultiple did not find, the other keys are hits
+	Misses int
+}
+
+// Hook observes every operation of a driver, the operations without a context run with CTXRedis
+type Hook interface {
+	// BeforeOperation may return a derived context, the operation and AfterOperation run with it
+	BeforeOperation(ctx context.Context, operation *Operation) context.Context
+	AfterOperation(ctx context.Context, operation *Operation)
+}
+
+// hooks is the chain of the hooks of a driver, they must be added before the driver is used
+type hooks []Hook
+
+// start runs the BeforeOperation hooks, the returned finish runs the AfterOperation ones with the result
+func (h hooks) start(ctx context.Context, driver string, name string, keys ...string) (context.Context, func(err error)) {
+	ctx, finish := h.startMultiple(ctx, driver, name, keys...)
+	return ctx, func(err error) { finish(0, err) }
+}
+
+// startMultiple is start for the operations on several keys, their finish also reports how many keys were missing
+func (h hooks) startMultiple(ctx context.Context, driver string, name string, keys ...string) (context.Context, func(misses int, err error)) {
+	if len(h) == 0 {
+		return ctx, func(misses int, err error) {}
+	}
+
+	operation := &Operation{
+		Driver: driver,
+		Name:   name,
+		Keys:   keys,
+		Start:  time.Now(),
+	}
+	hookCtxs := make([]context.Context, len(h))
+	for i, hook := range h {
+		ctx = hook.BeforeOperation(ctx, operation)
+		hookCtxs[i] = ctx
+	}
+
+	return ctx, func(misses int, err error) {
+		operation.Misses = misses
+		operation.Err = err
+		for i := len(h) - 1; i >= 0; i-- {
+			h[i].AfterOperation(hookCtxs[i], operation)
+		}
+	}
+}
+
+// TracingHook emits an OpenTelemetry client span per cache operation
+type TracingHook struct {
+	tracer trace.Tracer
+}
+
+// NewTracingHook returns a TracingHook on tracer, or on the tracer of the global provider if it is nil
+func NewTracingHook(tracer trace.Tracer) *TracingHook {
+	if tracer == nil {
+		tracer = otel.Tracer("github.com/dadiYazZ/xin-da-libs/cache")
+	}
+	return &TracingHook{tracer: tracer}
+}
+
+func (h *TracingHook) BeforeOperation(ctx context.Context, operation *Operation) context.Context {
+	ctx, _ = h.tracer.Start(ctx, "cache."+operation.Name,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithTimestamp(operation.Start),
+		trace.WithAttributes(
+			attribute.String("cache.driver", operation.Driver),
+			attribute.String("cache.operation", operation.Name),
+			attribute.StringSlice("cache.keys", operation.Keys),
+		),
+	)
+	return ctx
+}
+
+func (h *TracingHook) AfterOperation(ctx context.Context, operation *Operation) {
+	span := trace.SpanFromContext(ctx)
+	if operation.Name == OperationGet || operation.Name == OperationPull {
+		span.SetAttributes(attribute.Bool("cache.hit", operation.Err != ErrCacheMiss))
+	}
+	if operation.Err != nil && operation.Err != ErrCacheMiss {
+		span.RecordError(operation.Err)
+		span.SetStatus(codes.Error, operation.Err.Error())
+	}
+	span.End()
+}
@@ -0,0 +1,92 @@
+package cache
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"testing"
+	"time"
+)
+
+func Test_ContextCanceled(t *testing.T) {
+	drivers := map[string]func(t *testing.T) ContextCacheInterface{
+		"MemCache": func(t *testing.T) ContextCacheInterface { return newTestMemCache(t) },
+		"GRedis":   func(t *testing.T) ContextCacheInterface { return getTestGRedis(t) },
+	}
+
+	for name, newCache := range drivers {
+		t.Run(name, func(t *testing.T) {
+			c := newCache(t)
+			ctx, cancel := context.WithCancel(context.Background())
+			cancel()
+
+			err := c.SetCtx(ctx, "key", "value", time.Minute)
+			assert.ErrorIs(t, err, context.Canceled)
+			_, err = c.GetCtx(ctx, "key", nil)
+			assert.ErrorIs(t, err, context.Canceled)
+			_, err = c.IncrementCtx(ctx, "counter", 1)
+			assert.ErrorIs(t, err, context.Canceled)
+			assert.False(t, c.Has("key"))
+		})
+	}
+}
+
+func Test_RememberCtxWaitCanceled(t *testing.T) {
+	c := newTestMemCache(t)
+
+	started := make(chan struct{})
+	release := make(chan struct{})
+	go c.Remember("key", time.Minute, func() (interface{}, error) {
+		close(started)
+		<-release
+		return "value", nil
+	})
+	<-started
+	defer close(release)
+
+	// a caller waiting for the callback of another one gives up with its ctx
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	_, err := c.RememberCtx(ctx, "key", time.Minute, func() (interface{}, error) {
+		return "other", nil
+	})
+	assert.Equal(t, context.DeadlineExceeded, err)
+}
+
+func Test_TracingHook(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+
+	gr := getTestGRedis(t)
+	gr.AddHook(NewTracingHook(provider.Tracer("test")))
+
+	ctx, parent := provider.Tracer("test").Start(context.Background(), "parent")
+	assert.NoError(t, gr.SetCtx(ctx, "key", "value", time.Minute))
+	_, err := gr.GetCtx(ctx, "key", nil)
+	assert.NoError(t, err)
+	_, err = gr.GetCtx(ctx, "missing", nil)
+	assert.Equal(t, ErrCacheMiss, err)
+	_, err = gr.IncrementCtx(ctx, "key", 1)
+	assert.Equal(t, ErrInvalidValue, err)
+	parent.End()
+
+	spans := recorder.Ended()
+	assert.Len(t, spans, 5)
+
+	names := []string{}
+	for _, span := range spans[:4] {
+		names = append(names, span.Name())
+		assert.Equal(t, parent.SpanContext().SpanID(), span.Parent().SpanID())
+		assert.Contains(t, span.Attributes(), attribute.String("cache.driver", DriverRedis))
+	}
+	assert.Equal(t, []string{"cache.set", "cache.get", "cache.get", "cache.increment"}, names)
+
+	assert.Contains(t, spans[1].Attributes(), attribute.Bool("cache.hit", true))
+	assert.Contains(t, spans[2].Attributes(), attribute.Bool("cache.hit", false))
+	assert.Contains(t, spans[2].Attributes(), attribute.StringSlice("cache.keys", []string{"missing"}))
+	assert.Equal(t, codes.Unset, spans[2].Status().Code)
+	assert.Equal(t, codes.Error, spans[3].Status().Code)
+}
@@ -1,11 +1,18 @@
 package cache
 
 import (
+	"context"
 	"time"
+
+	"github.com/dadiYazZ/xin-da-libs/object"
 )
 
 var ACCache CacheInterface
 
+// CacheInterface is implemented by every driver with the same semantics.
+// A positive ttl expires the item after ttl, a zero ttl uses the default life time of the driver
+// and a negative ttl never expires. The default life time of MemCache is DEFAULT_EXPIRES_IN minutes unless configured,
+// GRedis keeps the items stored with a zero ttl forever unless SetDefaultExpiration is set
 type CacheInterface interface {
 
 	//SetOptions(opts interface{}) error
@@ -49,14 +56,14 @@ type CacheInterface interface {
 	//* @panic InvalidArgumentException
 	//*   MUST be thrown if the key string,is not a legal value.
 	//*/
-	//Delete(key string) bool
+	Delete(key string) error
 	//
 	///**
 	//* Wipes clean the entire cache's keys.
 	//*
 	//* @return bool True on success and false on failure.
 	//*/
-	//Clear() bool
+	Clear() error
 	//
 	///**
 	//* Obtains multiple cache items by their unique keys.
@@ -70,7 +77,7 @@ type CacheInterface interface {
 	//*   MUST be thrown if keys is neither an array nor a Traversable,
 	//*   or if any of the keys are not a legal value.
 	//*/
-	//GetMultiple(keys string, defaultValue object.HashMap) interface{}
+	GetMultiple(keys []string, defaultValue interface{}) (values object.HashMap, err error)
 	//
 	///**
 	//* Persists a set of key => value pairs in the cache, with an optional TTL.
@@ -86,7 +93,7 @@ type CacheInterface interface {
 	//*   MUST be thrown if values is neither an array nor a Traversable,
 	//*   or if any of the values are not a legal value.
 	//*/
-	//SetMultiple(values string, ttl int) bool
+	SetMultiple(values object.HashMap, ttl time.Duration) error
 	//
 	///**
 	//* Deletes multiple cache items in a single operation.
@@ -99,7 +106,7 @@ type CacheInterface interface {
 	//*   MUST be thrown if keys is neither an array nor a Traversable,
 	//*   or if any of the keys are not a legal value.
 	//*/
-	//DeleteMultiple(keys []string) bool
+	DeleteMultiple(keys []string) error
 	//
 	///**
 	//* Determines whether an item is present in the cache.
@@ -125,7 +132,7 @@ type CacheInterface interface {
 	//* @param  mixed  default
 	//* @return mixed
 	//*/
-	//Pull(key string, defaultValue object.HashMap) interface{}
+	Pull(key string, defaultValue interface{}) (ptrValue interface{}, err error)
 	//
 	///**
 	//* Store an item in the cache.
@@ -157,7 +164,7 @@ type CacheInterface interface {
 	//* @param  mixed  value
 	//* @return int|bool
 	//*/
-	//Increment(key string, value object.HashMap)(int, bool)
+	Increment(key string, value int64) (int64, error)
 	//
 	///**
 	//* Decrement the value of an item in the cache.
@@ -166,7 +173,7 @@ type CacheInterface interface {
 	//* @param  mixed  value
 	//* @return int|bool
 	//*/
-	//Decrement(key string, value object.HashMap) (int, bool)
+	Decrement(key string, value int64) (int64, error)
 	//
 	///**
 	//* Store an item in the cache indefinitely.
@@ -175,7 +182,7 @@ type CacheInterface interface {
 	//* @param  mixed  value
 	//* @return bool
 	//*/
-	//Forever(key string, value object.HashMap) bool
+	Forever(key string, value interface{}) error
 	//
 	///**
 	//* Get an item from the cache, or execute the given Closure and store the result.
@@ -203,7 +210,7 @@ type CacheInterface interface {
 	//* @param  \Closure  callback
 	//* @return mixed
 	//*/
-	//RememberForever(key string, callback func()) interface{}
+	RememberForever(key string, callback func() (interface{}, error)) (obj interface{}, err error)
 	//
 	///**
 	//* Remove an item from the cache.
@@ -211,7 +218,10 @@ type CacheInterface interface {
 	//* @param  string  key
 	//* @return bool
 	//*/
-	//Forget(key string) bool
+	Forget(key string) error
+
+	// Stats returns the statistics of the driver, or ErrNoStats if it does not collect them
+	Stats() (*Stats, error)
 	//
 	///**
 	//* Get the cache store implementation.
@@ -221,3 +231,43 @@ type CacheInterface interface {
 	//GetStore()
 
 }
+
+// ContextCacheInterface is implemented by the drivers whose operations take a context,
+// the deadline and cancellation of ctx are honoured and it is handed to the hooks, such as the TracingHook.
+// Each method behaves like its CacheInterface counterpart, which runs with CTXRedis
+type ContextCacheInterface interface {
+	CacheInterface
+
+	GetCtx(ctx context.Context, key string, defaultValue interface{}) (ptrValue interface{}, err error)
+	SetCtx(ctx context.Context, key string, value interface{}, expires time.Duration) error
+	DeleteCtx(ctx context.Context, key string) error
+	ClearCtx(ctx context.Context) error
+	GetMultipleCtx(ctx context.Context, keys []string, defaultValue interface{}) (values object.HashMap, err error)
+	SetMultipleCtx(ctx context.Context, values object.HashMap, ttl time.Duration) error
+	DeleteMultipleCtx(ctx context.Context, keys []string) error
+	HasCtx(ctx context.Context, key string) bool
+	PullCtx(ctx context.Context, key string, defaultValue interface{}) (ptrValue interface{}, err error)
+	AddNXCtx(ctx context.Context, key string, value interface{}, ttl time.Duration) bool
+	AddCtx(ctx context.Context, key string, value interface{}, ttl time.Duration) (err error)
+	IncrementCtx(ctx context.Context, key string, value int64) (int64, error)
+	DecrementCtx(ctx context.Context, key string, value int64) (int64, error)
+	ForeverCtx(ctx context.Context, key string, value interface{}) error
+	RememberCtx(ctx context.Context, key string, ttl time.Duration, callback func() (interface{}, error)) (obj interface{}, err error)
+	RememberForeverCtx(ctx context.Context, key string, callback func() (interface{}, error)) (obj interface{}, err error)
+	ForgetCtx(ctx context.Context, key string) error
+
+	// AddHook appends hooks observing every operation, it must be called before the cache is used
+	AddHook(hooks ...Hook)
+}
+
+// CASCacheInterface is implemented by the drivers supporting optimistic updates: a value is read with its version,
+// and written back with CompareAndSwap only if no one changed it meanwhile
+type CASCacheInterface interface {
+	CacheInterface
+
+	// GetWithVersion returns the value of key and its version, or defaultValue, 0 and ErrCacheMiss
+	GetWithVersion(key string, defaultValue interface{}) (ptrValue interface{}, version uint64, err error)
+	// CompareAndSwap stores value if key is still at version, version 0 meaning that key must not exist.
+	// It returns ErrCASConflict if key was changed or created meanwhile, and ErrNotStored if it was deleted or expired
+	CompareAndSwap(key string, value interface{}, version uint64, ttl time.Duration) error
+}
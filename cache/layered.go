@@ -0,0 +1,472 @@
+package cache
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/dadiYazZ/xin-da-libs/object"
+	"github.com/google/uuid"
+	"github.com/redis/go-redis/v9"
+)
+
+const DEFAULT_NEAR_TTL = 5 * time.Second
+const DEFAULT_INVALIDATION_CHANNEL = "cache:invalidation"
+
+type invalidationMessage struct {
+	Origin string   `json:"origin"`
+	Keys   []string `json:"keys,omitempty"`
+	All    bool     `json:"all,omitempty"`
+}
+
+type invalidationPublisher struct {
+	origin  string
+	channel string
+}
+
+// EnableInvalidation makes the instance publish the keys it changes on channel,
+// so that the near tier of every LayeredCache subscribed to the channel drops them
+func (gr *GRedis) EnableInvalidation(channel string) {
+	gr.invalidation = &invalidationPublisher{
+		origin:  uuid.New().String(),
+		channel: channel,
+	}
+}
+
+// invalidated announces keys once the operation changing them succeeded
+func (gr *GRedis) invalidated(ctx context.Context, err error, keys ...string) error {
+	if err != nil || gr.invalidation == nil || len(keys) == 0 {
+		return err
+	}
+	return gr.publishInvalidation(ctx, invalidationMessage{Keys: keys})
+}
+
+// invalidatedAll announces that the whole cache has been flushed
+func (gr *GRedis) invalidatedAll(ctx context.Context, err error) error {
+	if err != nil || gr.invalidation == nil {
+		return err
+	}
+	return gr.publishInvalidation(ctx, invalidationMessage{All: true})
+}
+
+func (gr *GRedis) publishInvalidation(ctx context.Context, message invalidationMessage) error {
+	message.Origin = gr.invalidation.origin
+	payload, err := json.Marshal(message)
+	if err != nil {
+		return err
+	}
+	return gr.Pool.Publish(ctx, gr.itemKey(gr.invalidation.channel), payload).Err()
+}
+
+type LayeredCacheOptions struct {
+	// NearTTL bounds how long a value is served by the local tier without asking redis, DEFAULT_NEAR_TTL if not set
+	NearTTL time.Duration
+	// Near configures the local MemCache, such as its MaxEntries
+	Near *MemCacheOptions
+	// Channel is the pub/sub channel the instances announce their changes on, DEFAULT_INVALIDATION_CHANNEL if not set.
+	// It is ignored if invalidation is already enabled on the GRedis
+	Channel string
+}
+
+// LayeredCache reads through a short lived in-process MemCache (the near tier) in front of GRedis (the far tier).
+// Every write goes to redis, which announces the changed keys through pub/sub so that the near tier of all the
+// instances drops them. A value may still be served stale by the near tier for at most NearTTL.
+type LayeredCache struct {
+	near    *MemCache
+	far     *GRedis
+	nearTTL time.Duration
+	pubSub  *redis.PubSub
+	done    chan struct{}
+	flight  flightGroup
+	hooks   hooks
+	stats   *statsRecorder
+}
+
+func NewLayeredCache(far *GRedis, options *LayeredCacheOptions) (*LayeredCache, error) {
+	if options == nil {
+		options = &LayeredCacheOptions{}
+	}
+	nearTTL := options.NearTTL
+	if nearTTL <= 0 {
+		nearTTL = DEFAULT_NEAR_TTL
+	}
+
+	near, err := NewMemCacheWithOptions(options.Near)
+	if err != nil {
+		return nil, err
+	}
+	near.SetCodec(far.GetCodec())
+
+	if far.invalidation == nil {
+		channel := options.Channel
+		if channel == "" {
+			channel = DEFAULT_INVALIDATION_CHANNEL
+		}
+		far.EnableInvalidation(channel)
+	}
+
+	// wait for the subscription to be confirmed, so that no invalidation is missed after returning
+	pubSub := far.Pool.Subscribe(CTXRedis, far.itemKey(far.invalidation.channel))
+	_, err = pubSub.Receive(CTXRedis)
+	if err != nil {
+		pubSub.Close()
+		near.Close()
+		return nil, err
+	}
+
+	stats := newStatsRecorder(DriverLayered)
+	lc := &LayeredCache{
+		near:    near,
+		far:     far,
+		nearTTL: nearTTL,
+		pubSub:  pubSub,
+		done:    make(chan struct{}),
+		stats:   stats,
+		hooks:   hooks{stats},
+	}
+	go lc.listen()
+
+	return lc, nil
+}
+
+func (lc *LayeredCache) listen() {
+	defer close(lc.done)
+
+	for msg := range lc.pubSub.Channel() {
+		message := invalidationMessage{}
+		err := json.Unmarshal([]byte(msg.Payload), &message)
+		_, finish := lc.hooks.start(CTXRedis, DriverLayered, OperationInvalidation, message.Keys...)
+		if err != nil {
+			// the hooks receive the malformed messages, which are dropped
+			finish(fmt.Errorf("invalid cache invalidation message: %w", err))
+			continue
+		}
+
+		if message.All {
+			err = lc.near.Clear()
+		} else {
+			err = lc.near.DeleteMultiple(message.Keys)
+		}
+		finish(err)
+	}
+}
+
+// Close stops listening to the invalidations and releases the near tier, the far tier is left open
+func (lc *LayeredCache) Close() error {
+	err := lc.pubSub.Close()
+	<-lc.done
+	if closeErr := lc.near.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// Near returns the in-process tier
+func (lc *LayeredCache) Near() *MemCache {
+	return lc.near
+}
+
+// Far returns the redis tier
+func (lc *LayeredCache) Far() *GRedis {
+	return lc.far
+}
+
+func (lc *LayeredCache) SetCodec(codec Codec) {
+	lc.near.Clear()
+	lc.near.SetCodec(codec)
+	lc.far.SetCodec(codec)
+}
+
+func (lc *LayeredCache) GetCodec() Codec {
+	return lc.far.GetCodec()
+}
+
+// AddHook appends hooks observing every operation of the layered cache, the tiers keep their own hooks
+func (lc *LayeredCache) AddHook(hooks ...Hook) {
+	lc.hooks = append(lc.hooks, hooks...)
+}
+
+func (lc *LayeredCache) GetBytes(key string) ([]byte, error) {
+	return lc.GetBytesCtx(CTXRedis, key)
+}
+
+func (lc *LayeredCache) GetBytesCtx(ctx context.Context, key string) (b []byte, err error) {
+	ctx, finish := lc.hooks.start(ctx, DriverLayered, OperationGet, key)
+	defer func() { finish(err) }()
+
+	return lc.getBytes(ctx, key)
+}
+
+func (lc *LayeredCache) getBytes(ctx context.Context, key string) ([]byte, error) {
+	b, err := lc.near.GetBytesCtx(ctx, key)
+	if err == nil {
+		return b, nil
+	}
+
+	b, err = lc.far.GetBytesCtx(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	lc.near.setBytes(key, b, lc.nearTTL)
+
+	return b, nil
+}
+
+func (lc *LayeredCache) Get(key string, defaultValue interface{}) (ptrValue interface{}, err error) {
+	return lc.GetCtx(CTXRedis, key, defaultValue)
+}
+
+func (lc *LayeredCache) GetCtx(ctx context.Context, key string, defaultValue interface{}) (ptrValue interface{}, err error) {
+	ctx, finish := lc.hooks.start(ctx, DriverLayered, OperationGet, key)
+	defer func() { finish(err) }()
+
+	b, err := lc.getBytes(ctx, key)
+	if err == ErrCacheMiss {
+		return defaultValue, err
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	err = lc.GetCodec().Unmarshal(b, &ptrValue)
+	return ptrValue, err
+}
+
+func (lc *LayeredCache) Set(key string, value interface{}, expires time.Duration) error {
+	return lc.SetCtx(CTXRedis, key, value, expires)
+}
+
+func (lc *LayeredCache) SetCtx(ctx context.Context, key string, value interface{}, expires time.Duration) (err error) {
+	ctx, finish := lc.hooks.start(ctx, DriverLayered, OperationSet, key)
+	defer func() { finish(err) }()
+
+	// the near tier of this instance is dropped right away, the others follow the published invalidation
+	defer lc.near.Delete(key)
+	return lc.far.SetCtx(ctx, key, value, expires)
+}
+
+func (lc *LayeredCache) Has(key string) bool {
+	return lc.HasCtx(CTXRedis, key)
+}
+
+func (lc *LayeredCache) HasCtx(ctx context.Context, key string) bool {
+	ctx, finish := lc.hooks.start(ctx, DriverLayered, OperationHas, key)
+	defer func() { finish(nil) }()
+
+	return lc.near.HasCtx(ctx, key) || lc.far.HasCtx(ctx, key)
+}
+
+func (lc *LayeredCache) AddNX(key string, value interface{}, ttl time.Duration) bool {
+	return lc.AddCtx(CTXRedis, key, value, ttl) == nil
+}
+
+func (lc *LayeredCache) AddNXCtx(ctx context.Context, key string, value interface{}, ttl time.Duration) bool {
+	return lc.AddCtx(ctx, key, value, ttl) == nil
+}
+
+func (lc *LayeredCache) Add(key string, value interface{}, ttl time.Duration) (err error) {
+	return lc.AddCtx(CTXRedis, key, value, ttl)
+}
+
+func (lc *LayeredCache) AddCtx(ctx context.Context, key string, value interface{}, ttl time.Duration) (err error) {
+	ctx, finish := lc.hooks.start(ctx, DriverLayered, OperationAdd, key)
+	defer func() { finish(err) }()
+
+	defer lc.near.Delete(key)
+	return lc.far.AddCtx(ctx, key, value, ttl)
+}
+
+func (lc *LayeredCache) Remember(key string, ttl time.Duration, callback func() (interface{}, error)) (obj interface{}, err error) {
+	return lc.RememberCtx(CTXRedis, key, ttl, callback)
+}
+
+func (lc *LayeredCache) RememberCtx(ctx context.Context, key string, ttl time.Duration, callback func() (interface{}, error)) (obj interface{}, err error) {
+	ctx, finish := lc.hooks.start(ctx, DriverLayered, OperationRemember, key)
+	defer func() { finish(err) }()
+
+	obj, err = lc.GetCtx(ctx, key, nil)
+	if err != ErrCacheMiss {
+		return obj, err
+	}
+
+	return lc.flight.Do(ctx, key, func() (interface{}, error) {
+		obj, err := callback()
+		if err != nil {
+			return nil, err
+		}
+
+		return obj, lc.SetCtx(ctx, key, obj, ttl)
+	})
+}
+
+func (lc *LayeredCache) Delete(key string) error {
+	return lc.DeleteCtx(CTXRedis, key)
+}
+
+func (lc *LayeredCache) DeleteCtx(ctx context.Context, key string) (err error) {
+	ctx, finish := lc.hooks.start(ctx, DriverLayered, OperationDelete, key)
+	defer func() { finish(err) }()
+
+	defer lc.near.Delete(key)
+	return lc.far.DeleteCtx(ctx, key)
+}
+
+func (lc *LayeredCache) Clear() error {
+	return lc.ClearCtx(CTXRedis)
+}
+
+func (lc *LayeredCache) ClearCtx(ctx context.Context) (err error) {
+	ctx, finish := lc.hooks.start(ctx, DriverLayered, OperationClear)
+	defer func() { finish(err) }()
+
+	defer lc.near.Clear()
+	return lc.far.ClearCtx(ctx)
+}
+
+func (lc *LayeredCache) GetMultiple(keys []string, defaultValue interface{}) (values object.HashMap, err error) {
+	return lc.GetMultipleCtx(CTXRedis, keys, defaultValue)
+}
+
+func (lc *LayeredCache) GetMultipleCtx(ctx context.Context, keys []string, defaultValue interface{}) (values object.HashMap, err error) {
+	misses := 0
+	ctx, finish := lc.hooks.startMultiple(ctx, DriverLayered, OperationGetMultiple, keys...)
+	defer func() { finish(misses, err) }()
+
+	values = object.HashMap{}
+	for _, key := range keys {
+		b, err := lc.getBytes(ctx, key)
+		if err == ErrCacheMiss {
+			values[key] = defaultValue
+			misses++
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		var value interface{}
+		err = lc.GetCodec().Unmarshal(b, &value)
+		if err != nil {
+			return nil, err
+		}
+		values[key] = value
+	}
+
+	return values, nil
+}
+
+func (lc *LayeredCache) SetMultiple(values object.HashMap, ttl time.Duration) error {
+	return lc.SetMultipleCtx(CTXRedis, values, ttl)
+}
+
+func (lc *LayeredCache) SetMultipleCtx(ctx context.Context, values object.HashMap, ttl time.Duration) (err error) {
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	ctx, finish := lc.hooks.start(ctx, DriverLayered, OperationSetMultiple, keys...)
+	defer func() { finish(err) }()
+
+	defer lc.near.DeleteMultiple(keys)
+	return lc.far.SetMultipleCtx(ctx, values, ttl)
+}
+
+func (lc *LayeredCache) DeleteMultiple(keys []string) error {
+	return lc.DeleteMultipleCtx(CTXRedis, keys)
+}
+
+func (lc *LayeredCache) DeleteMultipleCtx(ctx context.Context, keys []string) (err error) {
+	ctx, finish := lc.hooks.start(ctx, DriverLayered, OperationDeleteMultiple, keys...)
+	defer func() { finish(err) }()
+
+	defer lc.near.DeleteMultiple(keys)
+	return lc.far.DeleteMultipleCtx(ctx, keys)
+}
+
+func (lc *LayeredCache) Pull(key string, defaultValue interface{}) (ptrValue interface{}, err error) {
+	return lc.PullCtx(CTXRedis, key, defaultValue)
+}
+
+func (lc *LayeredCache) PullCtx(ctx context.Context, key string, defaultValue interface{}) (ptrValue interface{}, err error) {
+	ctx, finish := lc.hooks.start(ctx, DriverLayered, OperationPull, key)
+	defer func() { finish(err) }()
+
+	defer lc.near.Delete(key)
+	return lc.far.PullCtx(ctx, key, defaultValue)
+}
+
+func (lc *LayeredCache) Increment(key string, value int64) (int64, error) {
+	return lc.IncrementCtx(CTXRedis, key, value)
+}
+
+func (lc *LayeredCache) IncrementCtx(ctx context.Context, key string, value int64) (result int64, err error) {
+	ctx, finish := lc.hooks.start(ctx, DriverLayered, OperationIncrement, key)
+	defer func() { finish(err) }()
+
+	defer lc.near.Delete(key)
+	return lc.far.IncrementCtx(ctx, key, value)
+}
+
+func (lc *LayeredCache) Decrement(key string, value int64) (int64, error) {
+	return lc.IncrementCtx(CTXRedis, key, -value)
+}
+
+func (lc *LayeredCache) DecrementCtx(ctx context.Context, key string, value int64) (int64, error) {
+	return lc.IncrementCtx(ctx, key, -value)
+}
+
+func (lc *LayeredCache) Forever(key string, value interface{}) error {
+	return lc.ForeverCtx(CTXRedis, key, value)
+}
+
+func (lc *LayeredCache) ForeverCtx(ctx context.Context, key string, value interface{}) (err error) {
+	ctx, finish := lc.hooks.start(ctx, DriverLayered, OperationForever, key)
+	defer func() { finish(err) }()
+
+	defer lc.near.Delete(key)
+	return lc.far.ForeverCtx(ctx, key, value)
+}
+
+func (lc *LayeredCache) RememberForever(key string, callback func() (interface{}, error)) (obj interface{}, err error) {
+	return lc.RememberForeverCtx(CTXRedis, key, callback)
+}
+
+func (lc *LayeredCache) RememberForeverCtx(ctx context.Context, key string, callback func() (interface{}, error)) (obj interface{}, err error) {
+	ctx, finish := lc.hooks.start(ctx, DriverLayered, OperationRememberForever, key)
+	defer func() { finish(err) }()
+
+	obj, err = lc.GetCtx(ctx, key, nil)
+	if err != ErrCacheMiss {
+		return obj, err
+	}
+
+	return lc.flight.Do(ctx, key, func() (interface{}, error) {
+		obj, err := callback()
+		if err != nil {
+			return nil, err
+		}
+
+		return obj, lc.ForeverCtx(ctx, key, obj)
+	})
+}
+
+func (lc *LayeredCache) Forget(key string) error {
+	return lc.DeleteCtx(CTXRedis, key)
+}
+
+func (lc *LayeredCache) ForgetCtx(ctx context.Context, key string) error {
+	return lc.DeleteCtx(ctx, key)
+}
+
+// Invalidate deletes the tags and their keys from redis, the near tiers follow the published invalidation.
+// Deprecated: use Tags(tags...).Flush
+func (lc *LayeredCache) Invalidate(tags []string) {
+	_ = lc.InvalidateCtx(CTXRedis, tags)
+}
+
+// InvalidateCtx is Invalidate returning the errors.
+// Deprecated: use Tags(tags...).Flush
+func (lc *LayeredCache) InvalidateCtx(ctx context.Context, tags []string) error {
+	return lc.far.InvalidateCtx(ctx, tags)
+}
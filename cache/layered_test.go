@@ -0,0 +1,150 @@
+package cache
+
+import (
+	"context"
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+	"sync"
+	"testing"
+	"time"
+)
+
+// errorHook collects the errors of the operations named name
+type errorHook struct {
+	name string
+	mu   sync.Mutex
+	errs []error
+}
+
+func (h *errorHook) BeforeOperation(ctx context.Context, operation *Operation) context.Context {
+	return ctx
+}
+
+func (h *errorHook) AfterOperation(ctx context.Context, operation *Operation) {
+	if operation.Name != h.name || operation.Err == nil {
+		return
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.errs = append(h.errs, operation.Err)
+}
+
+func (h *errorHook) count() int {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return len(h.errs)
+}
+
+func Test_LayeredCacheConformance(t *testing.T) {
+	testCacheConformance(t, func(t *testing.T) CacheInterface {
+		server := miniredis.RunT(t)
+		return newTestLayeredCache(t, server)
+	})
+}
+
+func Test_LayeredCacheInvalidation(t *testing.T) {
+	server := miniredis.RunT(t)
+	first := newTestLayeredCache(t, server)
+	second := newTestLayeredCache(t, server)
+
+	assert.NoError(t, first.Set("key", "v1", time.Minute))
+	value, err := second.Get("key", nil)
+	assert.NoError(t, err)
+	assert.Equal(t, "v1", value)
+	assert.True(t, second.Near().Has("key"))
+
+	// a change made by another instance drops the near value
+	assert.NoError(t, first.Set("key", "v2", time.Minute))
+	assert.Eventually(t, func() bool {
+		value, err := second.Get("key", nil)
+		return err == nil && value == "v2"
+	}, time.Second, 10*time.Millisecond)
+
+	assert.NoError(t, first.Delete("key"))
+	assert.Eventually(t, func() bool {
+		return !second.Has("key")
+	}, time.Second, 10*time.Millisecond)
+
+	// tags invalidated straight through GRedis
+	assert.NoError(t, first.Set("tagged", "value", time.Minute))
+	assert.NoError(t, first.Far().Pool.SAdd(CTXRedis, "tag", "tagged").Err())
+	_, err = second.Get("tagged", nil)
+	assert.NoError(t, err)
+	first.Far().Invalidate([]string{"tag"})
+	assert.Eventually(t, func() bool {
+		return !second.Near().Has("tagged")
+	}, time.Second, 10*time.Millisecond)
+
+	// flushing clears the near tier of everyone
+	assert.NoError(t, first.Set("key", "value", time.Minute))
+	_, err = second.Get("key", nil)
+	assert.NoError(t, err)
+	assert.NoError(t, first.Clear())
+	assert.Eventually(t, func() bool {
+		return second.Near().Len() == 0
+	}, time.Second, 10*time.Millisecond)
+}
+
+func Test_LayeredCacheNearTTL(t *testing.T) {
+	server := miniredis.RunT(t)
+	lc := newTestLayeredCache(t, server)
+
+	assert.NoError(t, lc.Set("key", "value", time.Minute))
+	_, err := lc.Get("key", nil)
+	assert.NoError(t, err)
+
+	// a change made behind the back of the cache is served stale until the near ttl elapses
+	assert.NoError(t, server.Set("key", `"changed"`))
+	value, err := lc.Get("key", nil)
+	assert.NoError(t, err)
+	assert.Equal(t, "value", value)
+
+	time.Sleep(60 * time.Millisecond)
+	value, err = lc.Get("key", nil)
+	assert.NoError(t, err)
+	assert.Equal(t, "changed", value)
+}
+
+func newTestLayeredCache(t *testing.T, server *miniredis.Miniredis) *LayeredCache {
+	far := NewGRedis(&redis.UniversalOptions{
+		Addrs: []string{server.Addr()},
+	})
+	lc, err := NewLayeredCache(far, &LayeredCacheOptions{
+		NearTTL: 50 * time.Millisecond,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { lc.Close() })
+	return lc
+}
+
+func Test_LayeredCacheInvalidationErrors(t *testing.T) {
+	server := miniredis.RunT(t)
+	lc := newTestLayeredCache(t, server)
+	hook := &errorHook{name: OperationInvalidation}
+	lc.AddHook(hook)
+
+	// a malformed message is reported to the hooks and the next ones are still applied
+	assert.NoError(t, lc.Set("key", "value", time.Minute))
+	_, err := lc.Get("key", nil)
+	assert.NoError(t, err)
+	server.Publish(DEFAULT_INVALIDATION_CHANNEL, "not json")
+	assert.Eventually(t, func() bool {
+		return hook.count() == 1
+	}, time.Second, 10*time.Millisecond)
+	assert.NoError(t, lc.Far().Pool.Publish(CTXRedis, DEFAULT_INVALIDATION_CHANNEL, `{"keys":["key"]}`).Err())
+	assert.Eventually(t, func() bool {
+		return !lc.Near().Has("key")
+	}, time.Second, 10*time.Millisecond)
+
+	// the errors of the tag invalidation are returned and reported to the hooks of the far tier
+	invalidateHook := &errorHook{name: OperationInvalidate}
+	lc.Far().AddHook(invalidateHook)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	assert.ErrorIs(t, lc.InvalidateCtx(ctx, []string{"a", "b"}), context.Canceled)
+	lc.Invalidate([]string{"a"})
+	assert.Equal(t, 1, invalidateHook.count())
+}
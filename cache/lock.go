@@ -0,0 +1,306 @@
+package cache
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	mathRand "math/rand"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const DEFAULT_LOCK_TTL = 30 * time.Second
+const DEFAULT_LOCK_RETRY_MIN = 10 * time.Millisecond
+const DEFAULT_LOCK_RETRY_MAX = 500 * time.Millisecond
+
+// LOCK_KEY_PREFIX prefixes the keys of the locks and their fencing counters, the cache keys must not start with "lock:{".
+// Scan, Keys, Clear and Flush skip them, so that clearing the cache never releases a lock or resets its fencing token
+const LOCK_KEY_PREFIX = "lock:"
+
+var (
+	ErrLockNotAcquired = errors.New("cache: lock not acquired")
+	ErrLockNotHeld     = errors.New("cache: lock not held")
+	ErrLockTTL         = errors.New("cache: lock ttl must be at least 1ms")
+)
+
+// SCRIPT_LOCK sets the lock if it is free, and returns a new fencing token from the counter of the lock
+const SCRIPT_LOCK = `if redis.call('set', KEYS[1], ARGV[1], 'NX', 'PX', ARGV[2]) then
+	return redis.call('incr', KEYS[2])
+end
+return 0`
+
+// SCRIPT_UNLOCK deletes the lock only if it is still held by the owner token
+const SCRIPT_UNLOCK = `if redis.call('get', KEYS[1]) == ARGV[1] then
+	return redis.call('del', KEYS[1])
+end
+return 0`
+
+// SCRIPT_EXTEND resets the ttl of the lock only if it is still held by the owner token
+const SCRIPT_EXTEND = `if redis.call('get', KEYS[1]) == ARGV[1] then
+	return redis.call('pexpire', KEYS[1], ARGV[2])
+end
+return 0`
+
+var (
+	lockScript   = redis.NewScript(SCRIPT_LOCK)
+	unlockScript = redis.NewScript(SCRIPT_UNLOCK)
+	extendScript = redis.NewScript(SCRIPT_EXTEND)
+)
+
+type LockOptions struct {
+	// TTL releases the lock if its owner dies, DEFAULT_LOCK_TTL if not set
+	TTL time.Duration
+	// AutoRenew starts a watchdog extending the lock every TTL/3 until it is unlocked
+	AutoRenew bool
+	// RetryMin and RetryMax bound the exponential backoff of Lock between two attempts,
+	// DEFAULT_LOCK_RETRY_MIN and DEFAULT_LOCK_RETRY_MAX if not set
+	RetryMin time.Duration
+	RetryMax time.Duration
+}
+
+// Lock is a distributed lock held on a GRedis
+type Lock struct {
+	gr    *GRedis
+	key   string
+	token string
+	fence int64
+	ttl   time.Duration
+
+	stopOnce  sync.Once
+	stopRenew chan struct{}
+	lost      chan struct{}
+}
+
+// Key returns the key of the lock, without the prefix of the GRedis
+func (l *Lock) Key() string {
+	return l.key
+}
+
+// Token returns the random owner token of the lock
+func (l *Lock) Token() string {
+	return l.token
+}
+
+// Fence returns the fencing token, it increases each time the lock is acquired,
+// so that a resource can reject the writes coming from an owner whose lock has expired
+func (l *Lock) Fence() int64 {
+	return l.fence
+}
+
+// Lost is closed when the watchdog fails to extend the lock, the owner should stop working on the resource
+func (l *Lock) Lost() <-chan struct{} {
+	return l.lost
+}
+
+// Unlock releases the lock, ErrLockNotHeld is returned if it expired or is held by someone else
+func (l *Lock) Unlock(ctx context.Context) error {
+	return l.gr.Unlock(ctx, l)
+}
+
+// Extend resets the ttl of the lock
+func (l *Lock) Extend(ctx context.Context, ttl time.Duration) error {
+	return l.gr.Extend(ctx, l, ttl)
+}
+
+// TryLock acquires the lock of key once, it returns ErrLockNotAcquired if the lock is held by someone else
+func (gr *GRedis) TryLock(ctx context.Context, key string, options *LockOptions) (*Lock, error) {
+	opts := lockOptions(options)
+	if opts.TTL < time.Millisecond {
+		return nil, ErrLockTTL
+	}
+
+	token, err := newLockToken()
+	if err != nil {
+		return nil, err
+	}
+
+	lockKey := lockKey(key)
+	fence, err := gr.RunScript(ctx, lockScript, []string{lockKey, lockKey + ":fence"}, token, opts.TTL.Milliseconds()).Int64()
+	if err != nil {
+		return nil, err
+	}
+	if fence == 0 {
+		return nil, ErrLockNotAcquired
+	}
+
+	lock := &Lock{
+		gr:        gr,
+		key:       key,
+		token:     token,
+		fence:     fence,
+		ttl:       opts.TTL,
+		stopRenew: make(chan struct{}),
+		lost:      make(chan struct{}),
+	}
+	if opts.AutoRenew {
+		go lock.watchdog()
+	}
+
+	return lock, nil
+}
+
+// Lock waits until the lock of key is acquired, retrying with an exponential backoff until ctx is done
+func (gr *GRedis) Lock(ctx context.Context, key string, options *LockOptions) (*Lock, error) {
+	opts := lockOptions(options)
+
+	backoff := opts.RetryMin
+	for {
+		lock, err := gr.TryLock(ctx, key, &opts)
+		if err != ErrLockNotAcquired {
+			return lock, err
+		}
+
+		// full jitter, so that the waiting owners do not retry all at once
+		timer := time.NewTimer(time.Duration(mathRand.Int63n(int64(backoff)) + 1))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, ctx.Err()
+		case <-timer.C:
+		}
+
+		backoff *= 2
+		if backoff > opts.RetryMax {
+			backoff = opts.RetryMax
+		}
+	}
+}
+
+// Unlock releases the lock safely, only if it is still held by its owner token
+func (gr *GRedis) Unlock(ctx context.Context, lock *Lock) error {
+	lock.stopWatchdog()
+
+	released, err := gr.RunScript(ctx, unlockScript, []string{lockKey(lock.key)}, lock.token).Int64()
+	if err != nil {
+		return err
+	}
+	if released == 0 {
+		return ErrLockNotHeld
+	}
+	return nil
+}
+
+// Extend resets the ttl of the lock, only if it is still held by its owner token
+func (gr *GRedis) Extend(ctx context.Context, lock *Lock, ttl time.Duration) error {
+	if ttl < time.Millisecond {
+		return ErrLockTTL
+	}
+	extended, err := gr.RunScript(ctx, extendScript, []string{lockKey(lock.key)}, lock.token, ttl.Milliseconds()).Int64()
+	if err != nil {
+		return err
+	}
+	if extended == 0 {
+		return ErrLockNotHeld
+	}
+	return nil
+}
+
+// releaseLock deletes key only if it still holds the encoded token, for the lock of StampedeGuard
+func (gr *GRedis) releaseLock(ctx context.Context, key string, token string) error {
+	mToken, err := gr.GetCodec().Marshal(token)
+	if err != nil {
+		return err
+	}
+	released, err := gr.RunScript(ctx, unlockScript, []string{key}, mToken).Int64()
+	if err != nil {
+		return err
+	}
+	if released == 0 {
+		return ErrLockNotHeld
+	}
+	return nil
+}
+
+// releaseLock deletes key only if it still holds the encoded token, for the lock of StampedeGuard
+func (cache *MemCache) releaseLock(ctx context.Context, key string, token string) error {
+	mToken, err := cache.GetCodec().Marshal(token)
+	if err != nil {
+		return err
+	}
+
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+
+	item := cache.getItem(key)
+	if item == nil || !bytes.Equal(item.Value, mToken) {
+		return ErrLockNotHeld
+	}
+	cache.deleteItem(key)
+	return nil
+}
+
+// releaseLock releases the lock held on the far tier, the near tier never keeps the locks
+func (lc *LayeredCache) releaseLock(ctx context.Context, key string, token string) error {
+	return lc.far.releaseLock(ctx, key, token)
+}
+
+func (l *Lock) watchdog() {
+	ticker := time.NewTicker(l.ttl / 3)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-l.stopRenew:
+			return
+		case <-ticker.C:
+			ctx, cancel := context.WithTimeout(context.Background(), l.ttl/3)
+			err := l.gr.Extend(ctx, l, l.ttl)
+			cancel()
+			if err == ErrLockNotHeld {
+				close(l.lost)
+				return
+			}
+		}
+	}
+}
+
+func (l *Lock) stopWatchdog() {
+	l.stopOnce.Do(func() {
+		close(l.stopRenew)
+	})
+}
+
+// lockKey returns the redis key of the lock of key, without the prefix. The lock and its fencing counter
+// share the hash tag {key}, so that the lock script touches a single slot on a redis cluster
+func lockKey(key string) string {
+	return LOCK_KEY_PREFIX + "{" + key + "}"
+}
+
+// isLockKey reports whether key, without the prefix, belongs to a lock
+func isLockKey(key string) bool {
+	return strings.HasPrefix(key, LOCK_KEY_PREFIX+"{")
+}
+
+func lockOptions(options *LockOptions) LockOptions {
+	opts := LockOptions{}
+	if options != nil {
+		opts = *options
+	}
+	if opts.TTL <= 0 {
+		opts.TTL = DEFAULT_LOCK_TTL
+	}
+	if opts.RetryMin <= 0 {
+		opts.RetryMin = DEFAULT_LOCK_RETRY_MIN
+	}
+	if opts.RetryMax < opts.RetryMin {
+		opts.RetryMax = DEFAULT_LOCK_RETRY_MAX
+		if opts.RetryMax < opts.RetryMin {
+			opts.RetryMax = opts.RetryMin
+		}
+	}
+	return opts
+}
+
+func newLockToken() (string, error) {
+	b := make([]byte, 16)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
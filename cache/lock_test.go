@@ -0,0 +1,131 @@
+package cache
+
+import (
+	"context"
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func Test_TryLockUnlock(t *testing.T) {
+	gr := getTestGRedis(t)
+	ctx := context.Background()
+
+	lock, err := gr.TryLock(ctx, "lock:order", nil)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), lock.Fence())
+	assert.Len(t, lock.Token(), 32)
+
+	_, err = gr.TryLock(ctx, "lock:order", nil)
+	assert.Equal(t, ErrLockNotAcquired, err)
+
+	assert.NoError(t, lock.Unlock(ctx))
+	// releasing twice must not delete a lock taken by someone else in the meantime
+	assert.Equal(t, ErrLockNotHeld, lock.Unlock(ctx))
+
+	next, err := gr.TryLock(ctx, "lock:order", nil)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(2), next.Fence())
+	assert.Equal(t, ErrLockNotHeld, lock.Unlock(ctx))
+	assert.NoError(t, next.Unlock(ctx))
+
+	_, err = gr.TryLock(ctx, "lock:order", &LockOptions{TTL: time.Microsecond})
+	assert.Equal(t, ErrLockTTL, err)
+}
+
+func Test_LockWaits(t *testing.T) {
+	gr := getTestGRedis(t)
+	ctx := context.Background()
+
+	held, err := gr.TryLock(ctx, "lock:order", nil)
+	assert.NoError(t, err)
+
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		held.Unlock(ctx)
+	}()
+
+	lock, err := gr.Lock(ctx, "lock:order", &LockOptions{RetryMax: 20 * time.Millisecond})
+	assert.NoError(t, err)
+	assert.Greater(t, lock.Fence(), held.Fence())
+	assert.NoError(t, lock.Unlock(ctx))
+}
+
+func Test_LockContextCanceled(t *testing.T) {
+	gr := getTestGRedis(t)
+
+	_, err := gr.TryLock(context.Background(), "lock:order", nil)
+	assert.NoError(t, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	_, err = gr.Lock(ctx, "lock:order", nil)
+	assert.Equal(t, context.DeadlineExceeded, err)
+}
+
+func Test_LockExtendAndWatchdog(t *testing.T) {
+	server := miniredis.RunT(t)
+	gr := NewGRedis(&redis.UniversalOptions{Addrs: []string{server.Addr()}})
+	ctx := context.Background()
+
+	lock, err := gr.TryLock(ctx, "lock:order", &LockOptions{TTL: time.Second})
+	assert.NoError(t, err)
+	assert.NoError(t, lock.Extend(ctx, time.Minute))
+	assert.Equal(t, time.Minute, server.TTL("lock:{lock:order}"))
+
+	assert.Equal(t, ErrLockTTL, lock.Extend(ctx, time.Microsecond))
+	assert.True(t, server.Exists("lock:{lock:order}:fence"))
+
+	// an expired lock cannot be extended
+	server.FastForward(2 * time.Minute)
+	assert.Equal(t, ErrLockNotHeld, lock.Extend(ctx, time.Minute))
+
+	renewed, err := gr.TryLock(ctx, "lock:renewed", &LockOptions{TTL: 150 * time.Millisecond, AutoRenew: true})
+	assert.NoError(t, err)
+	server.FastForward(100 * time.Millisecond)
+	assert.Eventually(t, func() bool {
+		return server.TTL("lock:{lock:renewed}") > 100*time.Millisecond
+	}, time.Second, 10*time.Millisecond)
+
+	// the watchdog reports a lock taken over by someone else
+	server.Set("lock:{lock:renewed}", "another owner")
+	select {
+	case <-renewed.Lost():
+	case <-time.After(time.Second):
+		t.Error("lost lock not reported")
+	}
+}
+
+func Test_LockSurvivesFlush(t *testing.T) {
+	server := miniredis.RunT(t)
+	ctx := context.Background()
+
+	for _, prefix := range []string{"", "app:"} {
+		gr := NewGRedis(&redis.UniversalOptions{Addrs: []string{server.Addr()}})
+		gr.SetPrefix(prefix)
+
+		// a cache key looking like the lock does not collide with it
+		assert.NoError(t, gr.Set("{order}", "value", time.Minute))
+		lock, err := gr.TryLock(ctx, "order", nil)
+		assert.NoError(t, err, prefix)
+
+		keys, err := gr.Keys()
+		assert.NoError(t, err)
+		assert.Equal(t, []string{"{order}"}, keys)
+
+		// clearing the cache neither releases the lock nor resets its fencing counter
+		assert.NoError(t, gr.Clear())
+		assert.NoError(t, gr.Flush())
+		assert.False(t, gr.Has("{order}"))
+		_, err = gr.TryLock(ctx, "order", nil)
+		assert.Equal(t, ErrLockNotAcquired, err, prefix)
+		assert.NoError(t, lock.Unlock(ctx))
+
+		next, err := gr.TryLock(ctx, "order", nil)
+		assert.NoError(t, err)
+		assert.Equal(t, lock.Fence()+1, next.Fence(), prefix)
+		assert.NoError(t, next.Unlock(ctx))
+	}
+}
@@ -1,60 +1,149 @@
 package cache
 
 import (
+	"container/list"
+	"context"
+	"encoding/gob"
 	"encoding/json"
 	"errors"
-	"fmt"
-	"github.com/patrickmn/go-cache"
+	"github.com/dadiYazZ/xin-da-libs/object"
+	"io"
 	"os"
 	"path"
+	"strings"
+	"sync"
 	"time"
 )
 
 const DEFAULT_EXPIRES_IN = 5
 const DEFAULT_PURGE_EXPIRES_IN_PERIOD = 10
+const DEFAULT_PERSIST_DELAY = time.Second
 
+// noExpiration keeps an item until it is deleted or evicted
+const noExpiration time.Duration = -1
+
+type MemCacheOptions struct {
+	// Namespace names the persistence file, so that several instances can share one directory
+	Namespace string
+	// DefaultLifeTime is used for the items stored with a zero ttl, DEFAULT_EXPIRES_IN minutes if not set
+	DefaultLifeTime time.Duration
+	// PurgePeriod is how often the expired items are purged, DEFAULT_PURGE_EXPIRES_IN_PERIOD minutes if not set
+	PurgePeriod time.Duration
+
+	// Directory enables persistence, the items are saved under Directory/.ArtisanCloud and loaded back on start
+	Directory string
+	// PersistDelay debounces the saves, all the changes made within the delay are written at once
+	PersistDelay time.Duration
+
+	// MaxEntries and MaxBytes bound the cache, the least recently used items are evicted beyond them.
+	// Zero means unbounded, the size of an item is the length of its key and encoded value
+	MaxEntries int
+	MaxBytes   int64
+}
+
+type memItem struct {
+	Key        string
+	Value      []byte
+	Expiration int64
+	// Version changes each time the item is stored, for CompareAndSwap
+	Version uint64
+}
+
+func (item *memItem) expired(now int64) bool {
+	return item.Expiration > 0 && now > item.Expiration
+}
+
+func (item *memItem) size() int64 {
+	return int64(len(item.Key) + len(item.Value))
+}
+
+// MemCache is an in-process driver with ttl, LRU eviction and optional persistence
 type MemCache struct {
-	*cache.Cache
+	options   MemCacheOptions
 	cacheFile string
+	codec     Codec
+
+	// mu guards the items, it also serializes read-modify-write operations such as Increment and Pull
+	mu           sync.Mutex
+	items        map[string]*list.Element
+	lru          *list.List
+	bytes        int64
+	version      uint64
+	persistTimer *time.Timer
+	persistErr   error
+
+	flight flightGroup
+	hooks  hooks
+	stats  *statsRecorder
+
+	// fileMu serializes writing the cache file
+	fileMu    sync.Mutex
+	stop      chan struct{}
+	closeOnce sync.Once
 }
 
+// NewMemCache returns a new MemCache, the items are persisted under directory unless it is empty.
+// It panics if the cache file can not be created under directory, use NewMemCacheWithOptions to handle the error
 func NewMemCache(namespace string, defaultLifeTime time.Duration, directory string) CacheInterface {
-
-	if ACCache != nil {
-		return ACCache
+	memCache, err := NewMemCacheWithOptions(&MemCacheOptions{
+		Namespace:       namespace,
+		DefaultLifeTime: defaultLifeTime,
+		Directory:       directory,
+	})
+	if err != nil {
+		panic(err)
 	}
 
-	if defaultLifeTime <= 0 {
-		defaultLifeTime = time.Duration(DEFAULT_EXPIRES_IN) * time.Minute
-	}
-	defaultPurgePeriod := time.Duration(DEFAULT_PURGE_EXPIRES_IN_PERIOD) * time.Minute
+	return memCache
+}
 
-	path, err := createCacheFile(directory)
-	if err != nil {
-		fmt.Println(err.Error())
-		return nil
+func NewMemCacheWithOptions(options *MemCacheOptions) (*MemCache, error) {
+	if options == nil {
+		options = &MemCacheOptions{}
+	}
+	opts := *options
+	if opts.DefaultLifeTime <= 0 {
+		opts.DefaultLifeTime = time.Duration(DEFAULT_EXPIRES_IN) * time.Minute
+	}
+	if opts.PurgePeriod <= 0 {
+		opts.PurgePeriod = time.Duration(DEFAULT_PURGE_EXPIRES_IN_PERIOD) * time.Minute
+	}
+	if opts.PersistDelay <= 0 {
+		opts.PersistDelay = DEFAULT_PERSIST_DELAY
 	}
 
+	stats := newStatsRecorder(DriverMemory)
 	memCache := &MemCache{
-		cache.NewFrom(
-			defaultLifeTime,
-			defaultPurgePeriod,
-			map[string]cache.Item{},
-		),
-		path,
+		options: opts,
+		items:   map[string]*list.Element{},
+		lru:     list.New(),
+		stop:    make(chan struct{}),
+		stats:   stats,
+		hooks:   hooks{stats},
 	}
 
-	//err = memCache.Cache.LoadFile(path)
-	//if err != nil {
-	//	xin-da-fmt.Println(err.Error())
-	//}
+	if opts.Directory != "" {
+		cacheFile, err := createNamespaceCacheFile(opts.Directory, opts.Namespace)
+		if err != nil {
+			return nil, err
+		}
+		err = memCache.load(cacheFile)
+		if err != nil {
+			return nil, err
+		}
+		memCache.cacheFile = cacheFile
+	}
 
-	ACCache = memCache
+	go memCache.janitor()
 
-	return ACCache
+	return memCache, nil
 }
 
 func createCacheFile(directory string) (cachePath string, err error) {
+	return createNamespaceCacheFile(directory, "")
+}
+
+func createNamespaceCacheFile(directory string, namespace string) (cachePath string, err error) {
 
 	_, err = os.Stat(directory)
 	if err != nil && os.IsExist(err) {
@@ -63,13 +152,20 @@ func createCacheFile(directory string) (cachePath string, err error) {
 		directory, err = os.UserHomeDir()
 	}
 
+	fileName := "cache"
+	if namespace != "" {
+		fileName += "-" + strings.NewReplacer("/", "_", "\\", "_").Replace(namespace)
+	}
+
 	directory = path.Join(directory, ".ArtisanCloud")
 	err = os.Mkdir(directory, os.ModePerm)
 	if err == nil || os.IsExist(err) {
-		cachePath = path.Join(directory, "cache")
-		_, err = os.Create(cachePath)
-		if err == nil || os.IsExist(err) {
-			return cachePath, nil
+		cachePath = path.Join(directory, fileName)
+		// the existing file must not be truncated, it is loaded on start
+		var file *os.File
+		file, err = os.OpenFile(cachePath, os.O_RDWR|os.O_CREATE, 0644)
+		if err == nil {
+			return cachePath, file.Close()
 		}
 	}
 
@@ -82,43 +178,638 @@ func createCacheFile(directory string) (cachePath string, err error) {
 }
 
 func (cache *MemCache) Get(key string, defaultValue interface{}) (returnValue interface{}, err error) {
-	ptrValue, found := cache.Cache.Get(key)
-	if !found {
-		return nil, errors.New(fmt.Sprintf("Cannot find value with key: %s", key))
+	return cache.GetCtx(CTXRedis, key, defaultValue)
+}
+
+func (cache *MemCache) GetCtx(ctx context.Context, key string, defaultValue interface{}) (returnValue interface{}, err error) {
+	ctx, finish := cache.hooks.start(ctx, DriverMemory, OperationGet, key)
+	defer func() { finish(err) }()
+
+	b, err := cache.getBytes(ctx, key)
+	if err != nil {
+		if err == ErrCacheMiss {
+			return defaultValue, err
+		}
+		return nil, err
 	}
 
-	err = json.Unmarshal(ptrValue.([]byte), &returnValue)
+	err = cache.GetCodec().Unmarshal(b, &returnValue)
 	return returnValue, err
 }
 
+func (cache *MemCache) GetBytes(key string) ([]byte, error) {
+	return cache.GetBytesCtx(CTXRedis, key)
+}
+
+func (cache *MemCache) GetBytesCtx(ctx context.Context, key string) (b []byte, err error) {
+	ctx, finish := cache.hooks.start(ctx, DriverMemory, OperationGet, key)
+	defer func() { finish(err) }()
+
+	return cache.getBytes(ctx, key)
+}
+
+func (cache *MemCache) getBytes(ctx context.Context, key string) ([]byte, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+
+	item := cache.getItem(key)
+	if item == nil {
+		return nil, ErrCacheMiss
+	}
+
+	return item.Value, nil
+}
+
+func (cache *MemCache) SetCodec(codec Codec) {
+	cache.codec = codec
+}
+
+func (cache *MemCache) GetCodec() Codec {
+	return getCodec(cache.codec)
+}
+
+// AddHook appends hooks observing every operation, it must be called before the cache is used
+func (cache *MemCache) AddHook(hooks ...Hook) {
+	cache.hooks = append(cache.hooks, hooks...)
+}
+
+// Set stores value for expires, a zero expires means the default life time and a negative one never expires.
+// A value larger than MaxBytes on its own is not stored and Set returns ErrNotStored
 func (cache *MemCache) Set(key string, value interface{}, expires time.Duration) error {
+	return cache.SetCtx(CTXRedis, key, value, expires)
+}
+
+func (cache *MemCache) SetCtx(ctx context.Context, key string, value interface{}, expires time.Duration) (err error) {
+	ctx, finish := cache.hooks.start(ctx, DriverMemory, OperationSet, key)
+	defer func() { finish(err) }()
+
+	if err = ctx.Err(); err != nil {
+		return err
+	}
 
-	mValue, err := json.Marshal(value)
-	//mExpire, err := json.Marshal(expires)
+	mValue, err := cache.GetCodec().Marshal(value)
 	if err != nil {
 		return err
 	}
 
-	cache.Cache.Set(key, mValue, expires)
-	err = cache.Cache.SaveFile(cache.cacheFile)
-	return err
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+
+	return cache.setItem(key, mValue, cache.expiration(expires))
+}
+
+// setBytes stores a value already encoded with the codec of the cache
+func (cache *MemCache) setBytes(key string, value []byte, expires time.Duration) {
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+
+	// a value which does not fit is simply not kept in the near tier
+	_ = cache.setItem(key, value, cache.expiration(expires))
 }
 
 func (cache *MemCache) Has(key string) bool {
+	return cache.HasCtx(CTXRedis, key)
+}
+
+func (cache *MemCache) HasCtx(ctx context.Context, key string) (found bool) {
+	ctx, finish := cache.hooks.start(ctx, DriverMemory, OperationHas, key)
+	defer func() { finish(ctx.Err()) }()
+
+	if ctx.Err() != nil {
+		return false
+	}
 
-	_, found := cache.Cache.Get(key)
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
 
-	return found
+	return cache.getItem(key) != nil
 }
 
 func (cache *MemCache) AddNX(key string, value interface{}, ttl time.Duration) bool {
-	return false
+	return cache.AddCtx(CTXRedis, key, value, ttl) == nil
+}
+
+func (cache *MemCache) AddNXCtx(ctx context.Context, key string, value interface{}, ttl time.Duration) bool {
+	return cache.AddCtx(ctx, key, value, ttl) == nil
 }
 
+// Add stores value only if key does not exist yet, otherwise it returns ErrNotStored
 func (cache *MemCache) Add(key string, value interface{}, ttl time.Duration) (err error) {
-	return nil
+	return cache.AddCtx(CTXRedis, key, value, ttl)
+}
+
+func (cache *MemCache) AddCtx(ctx context.Context, key string, value interface{}, ttl time.Duration) (err error) {
+	ctx, finish := cache.hooks.start(ctx, DriverMemory, OperationAdd, key)
+	defer func() { finish(err) }()
+
+	if err = ctx.Err(); err != nil {
+		return err
+	}
+
+	mValue, err := cache.GetCodec().Marshal(value)
+	if err != nil {
+		return err
+	}
+
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+
+	if cache.getItem(key) != nil {
+		return ErrNotStored
+	}
+	return cache.setItem(key, mValue, cache.expiration(ttl))
 }
 
 func (cache *MemCache) Remember(key string, ttl time.Duration, callback func() (interface{}, error)) (obj interface{}, err error) {
-	return nil, nil
+	return cache.RememberCtx(CTXRedis, key, ttl, callback)
+}
+
+func (cache *MemCache) RememberCtx(ctx context.Context, key string, ttl time.Duration, callback func() (interface{}, error)) (obj interface{}, err error) {
+	ctx, finish := cache.hooks.start(ctx, DriverMemory, OperationRemember, key)
+	defer func() { finish(err) }()
+
+	obj, err = cache.GetCtx(ctx, key, nil)
+	if err != ErrCacheMiss {
+		return obj, err
+	}
+
+	return cache.flight.Do(ctx, key, func() (interface{}, error) {
+		obj, err := callback()
+		if err != nil {
+			return nil, err
+		}
+
+		return obj, cache.SetCtx(ctx, key, obj, ttl)
+	})
+}
+
+func (cache *MemCache) Delete(key string) error {
+	return cache.DeleteCtx(CTXRedis, key)
+}
+
+func (cache *MemCache) DeleteCtx(ctx context.Context, key string) (err error) {
+	ctx, finish := cache.hooks.start(ctx, DriverMemory, OperationDelete, key)
+	defer func() { finish(err) }()
+
+	if err = ctx.Err(); err != nil {
+		return err
+	}
+
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+
+	cache.deleteItem(key)
+	return nil
+}
+
+func (cache *MemCache) Clear() error {
+	return cache.ClearCtx(CTXRedis)
+}
+
+func (cache *MemCache) ClearCtx(ctx context.Context) (err error) {
+	ctx, finish := cache.hooks.start(ctx, DriverMemory, OperationClear)
+	defer func() { finish(err) }()
+
+	if err = ctx.Err(); err != nil {
+		return err
+	}
+
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+
+	cache.items = map[string]*list.Element{}
+	cache.lru.Init()
+	cache.bytes = 0
+	cache.changed()
+	return nil
+}
+
+func (cache *MemCache) GetMultiple(keys []string, defaultValue interface{}) (values object.HashMap, err error) {
+	return cache.GetMultipleCtx(CTXRedis, keys, defaultValue)
+}
+
+func (cache *MemCache) GetMultipleCtx(ctx context.Context, keys []string, defaultValue interface{}) (values object.HashMap, err error) {
+	misses := 0
+	ctx, finish := cache.hooks.startMultiple(ctx, DriverMemory, OperationGetMultiple, keys...)
+	defer func() { finish(misses, err) }()
+
+	values = object.HashMap{}
+	for _, key := range keys {
+		b, err := cache.getBytes(ctx, key)
+		if err == ErrCacheMiss {
+			values[key] = defaultValue
+			misses++
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		var value interface{}
+		err = cache.GetCodec().Unmarshal(b, &value)
+		if err != nil {
+			return nil, err
+		}
+		values[key] = value
+	}
+
+	return values, nil
+}
+
+func (cache *MemCache) SetMultiple(values object.HashMap, ttl time.Duration) error {
+	return cache.SetMultipleCtx(CTXRedis, values, ttl)
+}
+
+func (cache *MemCache) SetMultipleCtx(ctx context.Context, values object.HashMap, ttl time.Duration) (err error) {
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	ctx, finish := cache.hooks.start(ctx, DriverMemory, OperationSetMultiple, keys...)
+	defer func() { finish(err) }()
+
+	if err = ctx.Err(); err != nil {
+		return err
+	}
+
+	mValues := map[string][]byte{}
+	for key, value := range values {
+		mValue, err := cache.GetCodec().Marshal(value)
+		if err != nil {
+			return err
+		}
+		mValues[key] = mValue
+	}
+
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+
+	expiration := cache.expiration(ttl)
+	for key, mValue := range mValues {
+		if setErr := cache.setItem(key, mValue, expiration); setErr != nil {
+			err = setErr
+		}
+	}
+	return err
+}
+
+func (cache *MemCache) DeleteMultiple(keys []string) error {
+	return cache.DeleteMultipleCtx(CTXRedis, keys)
+}
+
+func (cache *MemCache) DeleteMultipleCtx(ctx context.Context, keys []string) (err error) {
+	ctx, finish := cache.hooks.start(ctx, DriverMemory, OperationDeleteMultiple, keys...)
+	defer func() { finish(err) }()
+
+	if err = ctx.Err(); err != nil {
+		return err
+	}
+
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+
+	for _, key := range keys {
+		cache.deleteItem(key)
+	}
+	return nil
+}
+
+func (cache *MemCache) Pull(key string, defaultValue interface{}) (ptrValue interface{}, err error) {
+	return cache.PullCtx(CTXRedis, key, defaultValue)
+}
+
+func (cache *MemCache) PullCtx(ctx context.Context, key string, defaultValue interface{}) (ptrValue interface{}, err error) {
+	ctx, finish := cache.hooks.start(ctx, DriverMemory, OperationPull, key)
+	defer func() { finish(err) }()
+
+	if err = ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	cache.mu.Lock()
+	item := cache.getItem(key)
+	if item != nil {
+		cache.deleteItem(key)
+	}
+	cache.mu.Unlock()
+
+	if item == nil {
+		return defaultValue, ErrCacheMiss
+	}
+
+	err = cache.GetCodec().Unmarshal(item.Value, &ptrValue)
+	return ptrValue, err
+}
+
+func (cache *MemCache) Increment(key string, value int64) (int64, error) {
+	return cache.IncrementCtx(CTXRedis, key, value)
+}
+
+func (cache *MemCache) IncrementCtx(ctx context.Context, key string, value int64) (current int64, err error) {
+	ctx, finish := cache.hooks.start(ctx, DriverMemory, OperationIncrement, key)
+	defer func() { finish(err) }()
+
+	if err = ctx.Err(); err != nil {
+		return 0, err
+	}
+
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+
+	// the same as redis INCRBY, a missing key counts as 0 and the ttl of an existing key is kept.
+	// counters are always stored as plain numbers, whatever the codec is
+	var expiration int64
+	item := cache.getItem(key)
+	if item != nil {
+		if err := json.Unmarshal(item.Value, &current); err != nil {
+			return 0, ErrInvalidValue
+		}
+		expiration = item.Expiration
+	}
+
+	current += value
+	mValue, err := json.Marshal(current)
+	if err != nil {
+		return 0, err
+	}
+	if err = cache.setItem(key, mValue, expiration); err != nil {
+		return 0, err
+	}
+
+	return current, nil
+}
+
+func (cache *MemCache) Decrement(key string, value int64) (int64, error) {
+	return cache.IncrementCtx(CTXRedis, key, -value)
+}
+
+func (cache *MemCache) DecrementCtx(ctx context.Context, key string, value int64) (int64, error) {
+	return cache.IncrementCtx(ctx, key, -value)
+}
+
+func (cache *MemCache) Forever(key string, value interface{}) error {
+	return cache.ForeverCtx(CTXRedis, key, value)
+}
+
+func (cache *MemCache) ForeverCtx(ctx context.Context, key string, value interface{}) (err error) {
+	ctx, finish := cache.hooks.start(ctx, DriverMemory, OperationForever, key)
+	defer func() { finish(err) }()
+
+	return cache.SetCtx(ctx, key, value, noExpiration)
+}
+
+func (cache *MemCache) RememberForever(key string, callback func() (interface{}, error)) (obj interface{}, err error) {
+	return cache.RememberForeverCtx(CTXRedis, key, callback)
+}
+
+func (cache *MemCache) RememberForeverCtx(ctx context.Context, key string, callback func() (interface{}, error)) (obj interface{}, err error) {
+	ctx, finish := cache.hooks.start(ctx, DriverMemory, OperationRememberForever, key)
+	defer func() { finish(err) }()
+
+	return cache.RememberCtx(ctx, key, noExpiration, callback)
+}
+
+func (cache *MemCache) Forget(key string) error {
+	return cache.DeleteCtx(CTXRedis, key)
+}
+
+func (cache *MemCache) ForgetCtx(ctx context.Context, key string) error {
+	return cache.DeleteCtx(ctx, key)
+}
+
+// Len returns the number of items, including the expired ones which are not purged yet
+func (cache *MemCache) Len() int {
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+
+	return cache.lru.Len()
+}
+
+// Save writes the items to the cache file right now, instead of waiting for the debounced save
+func (cache *MemCache) Save() error {
+	if cache.cacheFile == "" {
+		return nil
+	}
+
+	cache.mu.Lock()
+	if cache.persistTimer != nil {
+		cache.persistTimer.Stop()
+		cache.persistTimer = nil
+	}
+	items := cache.snapshot()
+	cache.mu.Unlock()
+
+	return cache.writeFile(items)
+}
+
+// Close stops the purging janitor and saves the pending changes, the cache must not be used afterwards
+func (cache *MemCache) Close() (err error) {
+	cache.closeOnce.Do(func() {
+		close(cache.stop)
+		err = cache.Save()
+		if err == nil {
+			cache.mu.Lock()
+			err = cache.persistErr
+			cache.mu.Unlock()
+		}
+	})
+	return err
+}
+
+// expiration converts a ttl into the unix nano time the item expires at, 0 for never
+func (cache *MemCache) expiration(ttl time.Duration) int64 {
+	if ttl < 0 {
+		return 0
+	}
+	if ttl == 0 {
+		ttl = cache.options.DefaultLifeTime
+	}
+	return time.Now().Add(ttl).UnixNano()
+}
+
+// getItem returns the live item of key and marks it as the most recently used, the caller holds mu
+func (cache *MemCache) getItem(key string) *memItem {
+	element, found := cache.items[key]
+	if !found {
+		return nil
+	}
+
+	item := element.Value.(*memItem)
+	if item.expired(time.Now().UnixNano()) {
+		cache.removeElement(element)
+		cache.changed()
+		return nil
+	}
+	cache.lru.MoveToFront(element)
+
+	return item
+}
+
+// setItem stores the item as the most recently used and evicts beyond the bounds, the caller holds mu.
+// An item larger than MaxBytes on its own is not stored, it drops the previous value of key and returns ErrNotStored
+func (cache *MemCache) setItem(key string, value []byte, expiration int64) error {
+	// the versions come from a counter of the instance, so that a deleted then stored again key gets a new one
+	cache.version++
+	item := &memItem{Key: key, Value: value, Expiration: expiration, Version: cache.version}
+
+	if cache.options.MaxBytes > 0 && item.size() > cache.options.MaxBytes {
+		cache.deleteItem(key)
+		return ErrNotStored
+	}
+
+	if element, found := cache.items[key]; found {
+		cache.bytes += item.size() - element.Value.(*memItem).size()
+		element.Value = item
+		cache.lru.MoveToFront(element)
+	} else {
+		cache.items[key] = cache.lru.PushFront(item)
+		cache.bytes += item.size()
+	}
+
+	cache.evict()
+	cache.changed()
+	return nil
+}
+
+// deleteItem removes key, the caller holds mu
+func (cache *MemCache) deleteItem(key string) {
+	if element, found := cache.items[key]; found {
+		cache.removeElement(element)
+		cache.changed()
+	}
+}
+
+func (cache *MemCache) removeElement(element *list.Element) {
+	item := cache.lru.Remove(element).(*memItem)
+	delete(cache.items, item.Key)
+	cache.bytes -= item.size()
+}
+
+// evict drops the least recently used items until the cache fits its bounds, the caller holds mu
+func (cache *MemCache) evict() {
+	for cache.lru.Len() > 0 {
+		overEntries := cache.options.MaxEntries > 0 && cache.lru.Len() > cache.options.MaxEntries
+		overBytes := cache.options.MaxBytes > 0 && cache.bytes > cache.options.MaxBytes
+		if !overEntries && !overBytes {
+			return
+		}
+		cache.removeElement(cache.lru.Back())
+		cache.stats.evicted()
+	}
+}
+
+func (cache *MemCache) purgeExpired() {
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+
+	now := time.Now().UnixNano()
+	for element := cache.lru.Back(); element != nil; {
+		prev := element.Prev()
+		if element.Value.(*memItem).expired(now) {
+			cache.removeElement(element)
+			cache.changed()
+		}
+		element = prev
+	}
+}
+
+func (cache *MemCache) janitor() {
+	ticker := time.NewTicker(cache.options.PurgePeriod)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			cache.purgeExpired()
+		case <-cache.stop:
+			return
+		}
+	}
+}
+
+// changed schedules a debounced save of the cache file, the caller holds mu
+func (cache *MemCache) changed() {
+	if cache.cacheFile == "" || cache.persistTimer != nil {
+		return
+	}
+
+	cache.persistTimer = time.AfterFunc(cache.options.PersistDelay, func() {
+		cache.mu.Lock()
+		cache.persistTimer = nil
+		items := cache.snapshot()
+		cache.mu.Unlock()
+
+		err := cache.writeFile(items)
+
+		cache.mu.Lock()
+		cache.persistErr = err
+		cache.mu.Unlock()
+	})
+}
+
+// snapshot copies the live items from the least to the most recently used, the caller holds mu
+func (cache *MemCache) snapshot() []memItem {
+	now := time.Now().UnixNano()
+	items := make([]memItem, 0, cache.lru.Len())
+	for element := cache.lru.Back(); element != nil; element = element.Prev() {
+		item := element.Value.(*memItem)
+		if !item.expired(now) {
+			items = append(items, *item)
+		}
+	}
+	return items
+}
+
+func (cache *MemCache) writeFile(items []memItem) error {
+	cache.fileMu.Lock()
+	defer cache.fileMu.Unlock()
+
+	// write a temporary file first, so that a crash never leaves a truncated cache file behind
+	tmpFile := cache.cacheFile + ".tmp"
+	file, err := os.Create(tmpFile)
+	if err != nil {
+		return err
+	}
+	err = gob.NewEncoder(file).Encode(items)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(tmpFile, cache.cacheFile)
+}
+
+func (cache *MemCache) load(cacheFile string) error {
+	file, err := os.Open(cacheFile)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	var items []memItem
+	err = gob.NewDecoder(file).Decode(&items)
+	if errors.Is(err, io.EOF) {
+		// a new cache file
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+
+	now := time.Now().UnixNano()
+	for _, item := range items {
+		if !item.expired(now) {
+			_ = cache.setItem(item.Key, item.Value, item.Expiration)
+		}
+	}
+	return nil
 }
@@ -3,7 +3,11 @@ package cache
 import (
 	"github.com/stretchr/testify/assert"
 	"os"
+	"path"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 )
 
 func Test_createCacheFile(t *testing.T) {
@@ -31,3 +35,153 @@ func Test_createCacheFile(t *testing.T) {
 	}
 
 }
+
+func Test_MemCacheConformance(t *testing.T) {
+	testCacheConformance(t, func(t *testing.T) CacheInterface {
+		return newTestMemCache(t)
+	})
+}
+
+func newTestMemCache(t *testing.T) *MemCache {
+	memCache, err := NewMemCacheWithOptions(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { memCache.Close() })
+	return memCache
+}
+
+func Test_MemCacheInstances(t *testing.T) {
+	first := NewMemCache("first", time.Minute, "")
+	second := NewMemCache("second", time.Minute, "")
+
+	assert.NoError(t, first.Set("key", "first", time.Minute))
+	assert.False(t, second.Has("key"))
+}
+
+func Test_MemCacheAddConcurrently(t *testing.T) {
+	memCache := newTestMemCache(t)
+
+	var stored int32
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			if memCache.AddNX("key", i, time.Minute) {
+				atomic.AddInt32(&stored, 1)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	assert.Equal(t, int32(1), stored)
+}
+
+func Test_MemCacheEviction(t *testing.T) {
+	memCache, err := NewMemCacheWithOptions(&MemCacheOptions{MaxEntries: 2})
+	assert.NoError(t, err)
+	defer memCache.Close()
+
+	assert.NoError(t, memCache.Set("a", "1", time.Minute))
+	assert.NoError(t, memCache.Set("b", "2", time.Minute))
+	// touch a, so that b becomes the least recently used
+	assert.True(t, memCache.Has("a"))
+	assert.NoError(t, memCache.Set("c", "3", time.Minute))
+
+	assert.Equal(t, 2, memCache.Len())
+	assert.True(t, memCache.Has("a"))
+	assert.False(t, memCache.Has("b"))
+	assert.True(t, memCache.Has("c"))
+
+	// each item takes 1 byte of key and 3 bytes of json string
+	memCache, err = NewMemCacheWithOptions(&MemCacheOptions{MaxBytes: 8})
+	assert.NoError(t, err)
+	defer memCache.Close()
+
+	assert.NoError(t, memCache.Set("a", "1", time.Minute))
+	assert.NoError(t, memCache.Set("b", "2", time.Minute))
+	assert.NoError(t, memCache.Set("c", "3", time.Minute))
+	assert.Equal(t, 2, memCache.Len())
+	assert.False(t, memCache.Has("a"))
+
+	// an item larger than MaxBytes is not stored and does not evict the others
+	assert.Equal(t, ErrNotStored, memCache.Set("b", "too large", time.Minute))
+	assert.Equal(t, ErrNotStored, memCache.Add("d", "too large", time.Minute))
+	assert.Equal(t, 1, memCache.Len())
+	assert.False(t, memCache.Has("b"))
+	assert.True(t, memCache.Has("c"))
+}
+
+func Test_NewMemCachePanics(t *testing.T) {
+	file, err := os.CreateTemp(t.TempDir(), "file")
+	assert.NoError(t, err)
+	_ = file.Close()
+
+	// the cache file can not be created under a regular file
+	assert.Panics(t, func() {
+		NewMemCache("panic", time.Minute, file.Name()+"/")
+	})
+}
+
+func Test_MemCacheExpiration(t *testing.T) {
+	memCache := newTestMemCache(t)
+
+	assert.NoError(t, memCache.Set("key", "value", time.Millisecond))
+	time.Sleep(5 * time.Millisecond)
+	assert.False(t, memCache.Has("key"))
+
+	memCache.purgeExpired()
+	assert.Equal(t, 0, memCache.Len())
+}
+
+func Test_MemCacheTTL(t *testing.T) {
+	memCache := newTestMemCache(t)
+
+	// a zero ttl means the default life time and a negative one never expires
+	assert.NoError(t, memCache.Set("zero", "value", 0))
+	assert.WithinDuration(t, time.Now().Add(time.Duration(DEFAULT_EXPIRES_IN)*time.Minute), time.Unix(0, memCache.items["zero"].Value.(*memItem).Expiration), time.Second)
+
+	assert.NoError(t, memCache.Set("negative", "value", time.Minute))
+	assert.NoError(t, memCache.Set("negative", "value", -1))
+	assert.Equal(t, int64(0), memCache.items["negative"].Value.(*memItem).Expiration)
+}
+
+func Test_MemCachePersistence(t *testing.T) {
+	directory := t.TempDir()
+	options := &MemCacheOptions{
+		Namespace:    "persist",
+		Directory:    directory,
+		PersistDelay: 10 * time.Millisecond,
+	}
+
+	memCache, err := NewMemCacheWithOptions(options)
+	assert.NoError(t, err)
+	assert.Equal(t, path.Join(directory, ".ArtisanCloud", "cache-persist"), memCache.cacheFile)
+
+	assert.NoError(t, memCache.Set("key", "value", time.Minute))
+	assert.NoError(t, memCache.Forever("forever", float64(1)))
+	assert.NoError(t, memCache.Set("expired", "value", time.Millisecond))
+
+	// wait for the debounced save
+	time.Sleep(100 * time.Millisecond)
+	loaded, err := NewMemCacheWithOptions(options)
+	assert.NoError(t, err)
+	value, err := loaded.Get("key", nil)
+	assert.NoError(t, err)
+	assert.Equal(t, "value", value)
+	value, err = loaded.Get("forever", nil)
+	assert.NoError(t, err)
+	assert.Equal(t, float64(1), value)
+	assert.False(t, loaded.Has("expired"))
+	assert.NoError(t, loaded.Close())
+
+	// Close saves the pending changes
+	assert.NoError(t, memCache.Delete("key"))
+	assert.NoError(t, memCache.Close())
+	loaded, err = NewMemCacheWithOptions(options)
+	assert.NoError(t, err)
+	defer loaded.Close()
+	assert.False(t, loaded.Has("key"))
+	assert.True(t, loaded.Has("forever"))
+}
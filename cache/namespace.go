@@ -0,0 +1,167 @@
+package cache
+
+import (
+	"context"
+	"strings"
+	"sync"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// DEFAULT_SCAN_COUNT is the number of keys SCAN is hinted to return per call
+const DEFAULT_SCAN_COUNT = 500
+
+var globEscaper = strings.NewReplacer(`\`, `\\`, `*`, `\*`, `?`, `\?`, `[`, `\[`, `]`, `\]`)
+
+// SetPrefix namespaces all the keys of the instance with prefix, such as "app:".
+// The prefix is applied transparently, the keys given to and returned by the methods never include it.
+// It must be set before the cache is used
+func (gr *GRedis) SetPrefix(prefix string) {
+	gr.prefix = prefix
+}
+
+func (gr *GRedis) GetPrefix() string {
+	return gr.prefix
+}
+
+// itemKey returns the redis key of key
+func (gr *GRedis) itemKey(key string) string {
+	return gr.prefix + key
+}
+
+func (gr *GRedis) itemKeys(keys []string) []string {
+	if gr.prefix == "" {
+		return keys
+	}
+
+	itemKeys := make([]string, 0, len(keys))
+	for _, key := range keys {
+		itemKeys = append(itemKeys, gr.itemKey(key))
+	}
+	return itemKeys
+}
+
+// KeyIterator iterates over the keys of a GRedis namespace with SCAN, on every master of a cluster
+type KeyIterator struct {
+	gr    *GRedis
+	match string
+	count int64
+
+	nodes []redis.Cmdable
+	iter  *redis.ScanIterator
+	err   error
+}
+
+// Scan returns an iterator over the keys of the namespace matching the glob pattern, "*" for all of them.
+// The keys of the locks are skipped.
+// count hints how many keys are fetched per call, DEFAULT_SCAN_COUNT if not set.
+// Like SCAN, a key may be returned more than once, and the keys changed during the iteration may be missed
+func (gr *GRedis) Scan(ctx context.Context, pattern string, count int64) *KeyIterator {
+	if pattern == "" {
+		pattern = "*"
+	}
+	if count <= 0 {
+		count = DEFAULT_SCAN_COUNT
+	}
+
+	it := &KeyIterator{
+		gr:    gr,
+		match: globEscaper.Replace(gr.prefix) + pattern,
+		count: count,
+	}
+	it.nodes, it.err = gr.scanNodes(ctx)
+
+	return it
+}
+
+// Next advances to the next key, it returns false at the end of the iteration or on error
+func (it *KeyIterator) Next(ctx context.Context) bool {
+	for it.err == nil {
+		if it.iter == nil {
+			if len(it.nodes) == 0 {
+				return false
+			}
+			it.iter = it.nodes[0].Scan(ctx, 0, it.match, it.count).Iterator()
+			it.nodes = it.nodes[1:]
+		}
+
+		for it.iter.Next(ctx) {
+			if !isLockKey(it.Key()) {
+				return true
+			}
+		}
+		it.err = it.iter.Err()
+		it.iter = nil
+	}
+
+	return false
+}
+
+// Key returns the current key, without the prefix
+func (it *KeyIterator) Key() string {
+	return strings.TrimPrefix(it.iter.Val(), it.gr.prefix)
+}
+
+func (it *KeyIterator) Err() error {
+	return it.err
+}
+
+// scanNodes returns the clients to scan, the masters of a cluster hold a part of the keys each
+func (gr *GRedis) scanNodes(ctx context.Context) ([]redis.Cmdable, error) {
+	cluster, ok := gr.Pool.(*redis.ClusterClient)
+	if !ok {
+		return []redis.Cmdable{gr.Pool}, nil
+	}
+
+	mu := sync.Mutex{}
+	nodes := []redis.Cmdable{}
+	err := cluster.ForEachMaster(ctx, func(ctx context.Context, client *redis.Client) error {
+		mu.Lock()
+		defer mu.Unlock()
+		nodes = append(nodes, client)
+		return nil
+	})
+
+	return nodes, err
+}
+
+// flush removes the keys of the namespace by batches, all the keys of the database if there is no prefix.
+// It does not use FLUSHDB, which would also release the locks and reset their fencing counters
+func (gr *GRedis) flush(ctx context.Context) error {
+	keys := make([]string, 0, DEFAULT_SCAN_COUNT)
+	iter := gr.Scan(ctx, "*", DEFAULT_SCAN_COUNT)
+	for iter.Next(ctx) {
+		keys = append(keys, iter.Key())
+		if len(keys) == DEFAULT_SCAN_COUNT {
+			err := gr.deleteKeys(ctx, keys)
+			if err != nil {
+				return err
+			}
+			keys = keys[:0]
+		}
+	}
+	if err := iter.Err(); err != nil {
+		return err
+	}
+
+	return gr.deleteKeys(ctx, keys)
+}
+
+// deleteKeys deletes the keys one command each, so that they may live in different slots of a cluster
+func (gr *GRedis) deleteKeys(ctx context.Context, keys []string) error {
+	if len(keys) == 0 {
+		return nil
+	}
+
+	pipe := gr.Pool.Pipeline()
+	for _, key := range keys {
+		pipe.Del(ctx, gr.itemKey(key))
+	}
+	_, err := pipe.Exec(ctx)
+	return err
+}
+
+// RunScript runs a Lua script with the keys prefixed, for the packages building on GRedis such as ratelimit
+func (gr *GRedis) RunScript(ctx context.Context, script *redis.Script, keys []string, args ...interface{}) *redis.Cmd {
+	return script.Run(ctx, gr.Pool, gr.itemKeys(keys), args...)
+}
@@ -0,0 +1,83 @@
+package cache
+
+import (
+	"context"
+	"fmt"
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+	"sort"
+	"testing"
+	"time"
+)
+
+func Test_GRedisPrefixConformance(t *testing.T) {
+	testCacheConformance(t, func(t *testing.T) CacheInterface {
+		gr := getTestGRedis(t)
+		gr.SetPrefix("app:")
+		return gr
+	})
+}
+
+func Test_GRedisPrefix(t *testing.T) {
+	server := miniredis.RunT(t)
+	newGRedis := func(prefix string) *GRedis {
+		gr := NewGRedis(&redis.UniversalOptions{Addrs: []string{server.Addr()}})
+		gr.SetPrefix(prefix)
+		return gr
+	}
+	app := newGRedis("app:")
+	other := newGRedis("other:")
+
+	assert.NoError(t, app.Set("key", "app", time.Minute))
+	assert.NoError(t, other.Set("key", "other", time.Minute))
+	assert.True(t, server.Exists("app:key"))
+
+	value, err := app.Get("key", nil)
+	assert.NoError(t, err)
+	assert.Equal(t, "app", value)
+
+	lock, err := app.TryLock(context.Background(), "lock", nil)
+	assert.NoError(t, err)
+	assert.True(t, server.Exists("app:lock:{lock}"))
+	assert.NoError(t, lock.Unlock(context.Background()))
+
+	// Flush only removes the keys of its own namespace
+	assert.NoError(t, app.Flush())
+	assert.False(t, app.Has("key"))
+	value, err = other.Get("key", nil)
+	assert.NoError(t, err)
+	assert.Equal(t, "other", value)
+}
+
+func Test_GRedisScan(t *testing.T) {
+	server := miniredis.RunT(t)
+	gr := NewGRedis(&redis.UniversalOptions{Addrs: []string{server.Addr()}})
+	gr.SetPrefix("app[1]:")
+	ctx := context.Background()
+
+	expected := []string{}
+	for i := 0; i < 25; i++ {
+		key := fmt.Sprintf("user:%02d", i)
+		expected = append(expected, key)
+		assert.NoError(t, gr.Set(key, i, time.Minute))
+	}
+	assert.NoError(t, gr.Set("post:1", 1, time.Minute))
+	assert.NoError(t, server.Set("app1:user:99", "not in the namespace"))
+
+	keys := []string{}
+	iter := gr.Scan(ctx, "user:*", 10)
+	for iter.Next(ctx) {
+		keys = append(keys, iter.Key())
+	}
+	assert.NoError(t, iter.Err())
+	sort.Strings(keys)
+	assert.Equal(t, expected, keys)
+
+	all, err := gr.Keys()
+	assert.NoError(t, err)
+	assert.Len(t, all, 26)
+
+	assert.NoError(t, gr.Flush())
+	assert.Equal(t, []string{"app1:user:99"}, server.Keys())
+}
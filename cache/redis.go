@@ -2,9 +2,9 @@ package cache
 
 import (
 	"context"
-	"encoding/json"
 	"errors"
 	"fmt"
+	"strings"
 	"time"
 
 	"github.com/dadiYazZ/xin-da-libs/object"
@@ -16,6 +16,17 @@ type GRedis struct {
 	Pool              redis.UniversalClient
 	defaultExpiration time.Duration
 	lockRetries       int
+	codec             Codec
+
+	flight flightGroup
+	hooks  hooks
+	stats  *statsRecorder
+
+	// invalidation announces the changed keys to the near tier of LayeredCache instances
+	invalidation *invalidationPublisher
+
+	// prefix namespaces all the keys of the instance, so that several applications can share a redis server
+	prefix string
 }
 
 const SYSTEM_CACHE_TIMEOUT = 60 * 60
@@ -26,6 +37,8 @@ const SYSTEM_CACHE_TIMEOUT_MONTH = 60 * 60 * 24 * 30
 const SYSTEM_CACHE_TIMEOUT_SEASON = 60 * 60 * 24 * 30 * 3
 const SYSTEM_CACHE_TIMEOUT_YEAR = 60 * 60 * 24 * 30 * 3 * 12
 
+const DEFAULT_INVALIDATE_BATCH = 500
+
 const (
 	defaultMaxIdle            = 5
 	defaultMaxActive          = 0
@@ -48,9 +61,12 @@ const lockRetries = 5
 func NewGRedis(opts *redis.UniversalOptions) (gr *GRedis) {
 
 	c := redis.NewUniversalClient(opts)
+	stats := newStatsRecorder(DriverRedis)
 	gr = &GRedis{
 		Pool:        c,
 		lockRetries: lockRetries,
+		stats:       stats,
+		hooks:       hooks{stats},
 	}
 
 	return gr
@@ -58,41 +74,67 @@ func NewGRedis(opts *redis.UniversalOptions) (gr *GRedis) {
 }
 
 func (gr *GRedis) AddNX(key string, value interface{}, ttl time.Duration) bool {
-	cmd := gr.Pool.SetNX(CTXRedis, key, value, ttl)
-	r, err := cmd.Result()
-	//xin-da-fmt.Printf("r:%b \r\n", r)
-	if err != nil {
-		fmt.Errorf("SetNX error: %+v \r\n", err)
-	}
-	return r
+	return gr.AddNXCtx(CTXRedis, key, value, ttl)
+}
+
+// AddNXCtx reports whether value is stored, use AddCtx to tell an existing key from an error
+func (gr *GRedis) AddNXCtx(ctx context.Context, key string, value interface{}, ttl time.Duration) bool {
+	return gr.AddCtx(ctx, key, value, ttl) == nil
 }
+
+// Add stores value only if key does not exist yet, otherwise it returns ErrNotStored
 func (gr *GRedis) Add(key string, value interface{}, ttl time.Duration) (err error) {
-	// If the store has an "add" method we will call the method on the store so it
-	// has a chance to override this logic. Some drivers better support the way
-	// this operation should work with a total "atomic" implementation of it.
-	var obj interface{}
-	obj, err = gr.Get(key, obj)
-	if err == ErrCacheMiss {
-		return gr.SetEx(key, value, ttl)
-	} else {
-		return errors.New("this value has been actually added to the cache")
+	return gr.AddCtx(CTXRedis, key, value, ttl)
+}
+
+func (gr *GRedis) AddCtx(ctx context.Context, key string, value interface{}, ttl time.Duration) (err error) {
+	ctx, finish := gr.hooks.start(ctx, DriverRedis, OperationAdd, key)
+	defer func() { finish(err) }()
+
+	// SETNX makes the operation atomic, the value is encoded like every other cache entry
+	mValue, err := gr.GetCodec().Marshal(value)
+	if err != nil {
+		return err
 	}
 
+	stored, err := gr.Pool.SetNX(ctx, gr.itemKey(key), mValue, gr.expiration(ttl)).Result()
+	if err != nil {
+		return err
+	}
+	if !stored {
+		return ErrNotStored
+	}
+	return gr.invalidated(ctx, nil, key)
 }
 
+// Set stores value for expires, a zero expires means the default expiration and a negative one never expires
 func (gr *GRedis) Set(key string, value interface{}, expires time.Duration) error {
-	mValue, err := json.Marshal(value)
+	return gr.SetCtx(CTXRedis, key, value, expires)
+}
+
+func (gr *GRedis) SetCtx(ctx context.Context, key string, value interface{}, expires time.Duration) (err error) {
+	ctx, finish := gr.hooks.start(ctx, DriverRedis, OperationSet, key)
+	defer func() { finish(err) }()
+
+	mValue, err := gr.GetCodec().Marshal(value)
 	//mExpire, err := json.Marshal(expires)
 	if err != nil {
 		return err
 	}
 
-	result := gr.Pool.Set(CTXRedis, key, mValue, expires)
-	return result.Err()
+	result := gr.Pool.Set(ctx, gr.itemKey(key), mValue, gr.expiration(expires))
+	return gr.invalidated(ctx, result.Err(), key)
 }
 
 func (gr *GRedis) SetEx(key string, value interface{}, expires time.Duration) error {
-	mValue, err := json.Marshal(value)
+	return gr.SetExCtx(CTXRedis, key, value, expires)
+}
+
+func (gr *GRedis) SetExCtx(ctx context.Context, key string, value interface{}, expires time.Duration) (err error) {
+	ctx, finish := gr.hooks.start(ctx, DriverRedis, OperationSet, key)
+	defer func() { finish(err) }()
+
+	mValue, err := gr.GetCodec().Marshal(value)
 	//mExpire, err := json.Marshal(expires)
 	if err != nil {
 		return err
@@ -104,38 +146,77 @@ func (gr *GRedis) SetEx(key string, value interface{}, expires time.Duration) er
 	//xin-da-fmt.Printf("err:%s \r\n", cmd.Err())
 
 	// connPool := gr.Pool.Conn()
-	cmd := gr.Pool.SetEx(CTXRedis, key, mValue, expires)
+	// SET with an expiration, SETEX rejects the ttl of the items which never expire
+	cmd := gr.Pool.Set(ctx, gr.itemKey(key), mValue, gr.expiration(expires))
 	//fmt2.Dump(connPool.Pipeline())
 	//xin-da-fmt.Printf("result:", cmd.String())
 
-	return cmd.Err()
+	return gr.invalidated(ctx, cmd.Err(), key)
 }
 
 func (gr *GRedis) Get(key string, defaultValue interface{}) (ptrValue interface{}, err error) {
-	b, err := gr.Pool.Get(CTXRedis, key).Bytes()
+	return gr.GetCtx(CTXRedis, key, defaultValue)
+}
+
+func (gr *GRedis) GetCtx(ctx context.Context, key string, defaultValue interface{}) (ptrValue interface{}, err error) {
+	ctx, finish := gr.hooks.start(ctx, DriverRedis, OperationGet, key)
+	defer func() { finish(err) }()
+
+	b, err := gr.Pool.Get(ctx, gr.itemKey(key)).Bytes()
 	if err == redis.Nil {
-		return nil, ErrCacheMiss
+		return defaultValue, ErrCacheMiss
 	}
 	if err != nil {
 		return nil, err
 	}
-	err = json.Unmarshal(b, &ptrValue)
+	err = gr.GetCodec().Unmarshal(b, &ptrValue)
 	defaultValue = ptrValue
 	return defaultValue, err
 }
 
-func (gr *GRedis) Has(key string) bool {
+func (gr *GRedis) GetBytes(key string) ([]byte, error) {
+	return gr.GetBytesCtx(CTXRedis, key)
+}
 
-	value, err := gr.Get(key, nil)
-	if value != nil && err == nil {
-		return true
+func (gr *GRedis) GetBytesCtx(ctx context.Context, key string) (b []byte, err error) {
+	ctx, finish := gr.hooks.start(ctx, DriverRedis, OperationGet, key)
+	defer func() { finish(err) }()
+
+	b, err = gr.Pool.Get(ctx, gr.itemKey(key)).Bytes()
+	if err == redis.Nil {
+		return nil, ErrCacheMiss
 	}
+	return b, err
+}
 
-	return false
+func (gr *GRedis) SetCodec(codec Codec) {
+	gr.codec = codec
+}
+
+func (gr *GRedis) GetCodec() Codec {
+	return getCodec(gr.codec)
+}
+
+// AddHook appends hooks observing every operation, it must be called before the cache is used
+func (gr *GRedis) AddHook(hooks ...Hook) {
+	gr.hooks = append(gr.hooks, hooks...)
+}
+
+func (gr *GRedis) Has(key string) bool {
+	return gr.HasCtx(CTXRedis, key)
+}
+
+func (gr *GRedis) HasCtx(ctx context.Context, key string) bool {
+	ctx, finish := gr.hooks.start(ctx, DriverRedis, OperationHas, key)
+
+	count, err := gr.Pool.Exists(ctx, gr.itemKey(key)).Result()
+	finish(err)
+
+	return err == nil && count > 0
 }
 
 func (gr *GRedis) GetMulti(keys ...string) (object.HashMap, error) {
-	res, err := gr.Pool.MGet(CTXRedis, keys...).Result()
+	res, err := gr.Pool.MGet(CTXRedis, gr.itemKeys(keys)...).Result()
 	if err != nil {
 		return nil, err
 	}
@@ -152,15 +233,219 @@ func (gr *GRedis) GetMulti(keys ...string) (object.HashMap, error) {
 }
 
 func (gr *GRedis) Delete(key string) error {
-	return gr.Pool.Del(CTXRedis, key).Err()
+	return gr.DeleteCtx(CTXRedis, key)
+}
+
+func (gr *GRedis) DeleteCtx(ctx context.Context, key string) (err error) {
+	ctx, finish := gr.hooks.start(ctx, DriverRedis, OperationDelete, key)
+	defer func() { finish(err) }()
+
+	return gr.invalidated(ctx, gr.Pool.Del(ctx, gr.itemKey(key)).Err(), key)
+}
+
+func (gr *GRedis) Clear() error {
+	return gr.ClearCtx(CTXRedis)
+}
+
+func (gr *GRedis) ClearCtx(ctx context.Context) (err error) {
+	ctx, finish := gr.hooks.start(ctx, DriverRedis, OperationClear)
+	defer func() { finish(err) }()
+
+	return gr.invalidatedAll(ctx, gr.flush(ctx))
+}
+
+func (gr *GRedis) GetMultiple(keys []string, defaultValue interface{}) (values object.HashMap, err error) {
+	return gr.GetMultipleCtx(CTXRedis, keys, defaultValue)
+}
+
+func (gr *GRedis) GetMultipleCtx(ctx context.Context, keys []string, defaultValue interface{}) (values object.HashMap, err error) {
+	misses := 0
+	ctx, finish := gr.hooks.startMultiple(ctx, DriverRedis, OperationGetMultiple, keys...)
+	defer func() { finish(misses, err) }()
+
+	values = object.HashMap{}
+	if len(keys) == 0 {
+		return values, nil
+	}
+
+	res, err := gr.Pool.MGet(ctx, gr.itemKeys(keys)...).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	for ix, key := range keys {
+		str, ok := res[ix].(string)
+		if !ok {
+			values[key] = defaultValue
+			misses++
+			continue
+		}
+		var value interface{}
+		err = gr.GetCodec().Unmarshal([]byte(str), &value)
+		if err != nil {
+			return nil, err
+		}
+		values[key] = value
+	}
+
+	return values, nil
+}
+
+func (gr *GRedis) SetMultiple(values object.HashMap, ttl time.Duration) error {
+	return gr.SetMultipleCtx(CTXRedis, values, ttl)
 }
 
+func (gr *GRedis) SetMultipleCtx(ctx context.Context, values object.HashMap, ttl time.Duration) (err error) {
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	ctx, finish := gr.hooks.start(ctx, DriverRedis, OperationSetMultiple, keys...)
+	defer func() { finish(err) }()
+
+	pipe := gr.Pool.TxPipeline()
+	for _, key := range keys {
+		mValue, err := gr.GetCodec().Marshal(values[key])
+		if err != nil {
+			return err
+		}
+		pipe.Set(ctx, gr.itemKey(key), mValue, gr.expiration(ttl))
+	}
+
+	_, err = pipe.Exec(ctx)
+	return gr.invalidated(ctx, err, keys...)
+}
+
+func (gr *GRedis) DeleteMultiple(keys []string) error {
+	return gr.DeleteMultipleCtx(CTXRedis, keys)
+}
+
+func (gr *GRedis) DeleteMultipleCtx(ctx context.Context, keys []string) (err error) {
+	ctx, finish := gr.hooks.start(ctx, DriverRedis, OperationDeleteMultiple, keys...)
+	defer func() { finish(err) }()
+
+	if len(keys) == 0 {
+		return nil
+	}
+	return gr.invalidated(ctx, gr.Pool.Del(ctx, gr.itemKeys(keys)...).Err(), keys...)
+}
+
+func (gr *GRedis) Pull(key string, defaultValue interface{}) (ptrValue interface{}, err error) {
+	return gr.PullCtx(CTXRedis, key, defaultValue)
+}
+
+func (gr *GRedis) PullCtx(ctx context.Context, key string, defaultValue interface{}) (ptrValue interface{}, err error) {
+	ctx, finish := gr.hooks.start(ctx, DriverRedis, OperationPull, key)
+	defer func() { finish(err) }()
+
+	pipe := gr.Pool.TxPipeline()
+	getCmd := pipe.Get(ctx, gr.itemKey(key))
+	pipe.Del(ctx, gr.itemKey(key))
+	_, err = pipe.Exec(ctx)
+	if err == redis.Nil {
+		return defaultValue, ErrCacheMiss
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	b, err := getCmd.Bytes()
+	if err != nil {
+		return nil, err
+	}
+	err = gr.GetCodec().Unmarshal(b, &ptrValue)
+	if err != nil {
+		return nil, err
+	}
+	return ptrValue, gr.invalidated(ctx, nil, key)
+}
+
+// Increment stores the counter as a plain number whatever the codec is, so only JsonCodec can Get it back
+func (gr *GRedis) Increment(key string, value int64) (int64, error) {
+	return gr.IncrementCtx(CTXRedis, key, value)
+}
+
+func (gr *GRedis) IncrementCtx(ctx context.Context, key string, value int64) (result int64, err error) {
+	ctx, finish := gr.hooks.start(ctx, DriverRedis, OperationIncrement, key)
+	defer func() { finish(err) }()
+
+	result, err = gr.Pool.IncrBy(ctx, gr.itemKey(key), value).Result()
+	if err != nil && strings.Contains(err.Error(), "not an integer") {
+		return 0, ErrInvalidValue
+	}
+	return result, gr.invalidated(ctx, err, key)
+}
+
+func (gr *GRedis) Decrement(key string, value int64) (int64, error) {
+	return gr.IncrementCtx(CTXRedis, key, -value)
+}
+
+func (gr *GRedis) DecrementCtx(ctx context.Context, key string, value int64) (int64, error) {
+	return gr.IncrementCtx(ctx, key, -value)
+}
+
+func (gr *GRedis) Forever(key string, value interface{}) error {
+	return gr.ForeverCtx(CTXRedis, key, value)
+}
+
+func (gr *GRedis) ForeverCtx(ctx context.Context, key string, value interface{}) (err error) {
+	ctx, finish := gr.hooks.start(ctx, DriverRedis, OperationForever, key)
+	defer func() { finish(err) }()
+
+	return gr.SetCtx(ctx, key, value, noExpiration)
+}
+
+/**
+ * Get an item from the cache, or execute the given Closure and store the result forever.
+ *
+ * @param  string  key
+ * @param  \Closure  callback
+ * @return mixed
+ */
+func (gr *GRedis) RememberForever(key string, callback func() (interface{}, error)) (obj interface{}, err error) {
+	return gr.RememberForeverCtx(CTXRedis, key, callback)
+}
+
+func (gr *GRedis) RememberForeverCtx(ctx context.Context, key string, callback func() (interface{}, error)) (obj interface{}, err error) {
+	ctx, finish := gr.hooks.start(ctx, DriverRedis, OperationRememberForever, key)
+	defer func() { finish(err) }()
+
+	obj, err = gr.GetCtx(ctx, key, nil)
+	if err != ErrCacheMiss {
+		return obj, err
+	}
+
+	return gr.flight.Do(ctx, key, func() (interface{}, error) {
+		obj, err := callback()
+		if err != nil {
+			return nil, err
+		}
+
+		return obj, gr.ForeverCtx(ctx, key, obj)
+	})
+}
+
+func (gr *GRedis) Forget(key string) error {
+	return gr.DeleteCtx(CTXRedis, key)
+}
+
+func (gr *GRedis) ForgetCtx(ctx context.Context, key string) error {
+	return gr.DeleteCtx(ctx, key)
+}
+
+// Keys returns all the keys of the namespace without their prefix, use Scan to iterate over a large namespace
 func (gr *GRedis) Keys() ([]string, error) {
-	return gr.Pool.Keys(CTXRedis, "*").Result()
+	keys := []string{}
+	iter := gr.Scan(CTXRedis, "*", 0)
+	for iter.Next(CTXRedis) {
+		keys = append(keys, iter.Key())
+	}
+	return keys, iter.Err()
 }
 
+// Flush removes the keys of the namespace, all the keys of the database if there is no prefix, except the locks
 func (gr *GRedis) Flush() error {
-	return gr.Pool.FlushAll(CTXRedis).Err()
+	return gr.ClearCtx(CTXRedis)
 }
 
 /**
@@ -172,9 +457,15 @@ func (gr *GRedis) Flush() error {
  * @return mixed
  */
 func (gr *GRedis) Remember(key string, ttl time.Duration, callback func() (interface{}, error)) (obj interface{}, err error) {
+	return gr.RememberCtx(CTXRedis, key, ttl, callback)
+}
+
+func (gr *GRedis) RememberCtx(ctx context.Context, key string, ttl time.Duration, callback func() (interface{}, error)) (obj interface{}, err error) {
+	ctx, finish := gr.hooks.start(ctx, DriverRedis, OperationRemember, key)
+	defer func() { finish(err) }()
 
 	var value interface{}
-	value, err = gr.Get(key, value)
+	value, err = gr.GetCtx(ctx, key, value)
 
 	// If the item exists in the cache we will just return this immediately and if
 	// not we will execute the given Closure and cache the result of that for a
@@ -183,21 +474,24 @@ func (gr *GRedis) Remember(key string, ttl time.Duration, callback func() (inter
 		fmt2.Dump("error:", err.Error())
 		return nil, err
 
-	} else if value != nil {
+	} else if err == nil {
 		return value, err
 	}
 
-	value, err = callback()
-	if err != nil {
-		return nil, err
-	}
-
-	result := gr.Put(key, value, ttl)
-	if !result {
-		err = errors.New(fmt.Sprintf("remember cache put err, ttl:%d", ttl))
-	}
-	// ErrCacheMiss and query value from source
-	return value, err
+	// the concurrent misses of this process share a single call of the callback
+	return gr.flight.Do(ctx, key, func() (interface{}, error) {
+		value, err := callback()
+		if err != nil {
+			return nil, err
+		}
+
+		err = gr.SetExCtx(ctx, key, value, ttl)
+		if err != nil {
+			err = fmt.Errorf("remember cache put err, ttl:%s: %w", ttl, err)
+		}
+		// ErrCacheMiss and query value from source
+		return value, err
+	})
 }
 
 /**
@@ -299,25 +593,87 @@ func (gr *GRedis) GetSeconds(ttl time.Duration) int {
 	return 0
 }
 
+// SetDefaultExpiration sets the expiration of the items stored with a zero ttl.
+// It is opt-in, without it a zero ttl never expires as it always did on redis.
+// It must be set before the cache is used
+func (gr *GRedis) SetDefaultExpiration(ttl time.Duration) {
+	gr.defaultExpiration = ttl
+}
+
+// expiration converts a ttl into the expiration of redis SET, 0 for never:
+// a zero ttl means the default expiration, never unless SetDefaultExpiration is set, and a negative one never expires
+func (gr *GRedis) expiration(ttl time.Duration) time.Duration {
+	if ttl == 0 {
+		ttl = gr.defaultExpiration
+	}
+	if ttl <= 0 {
+		return 0
+	}
+	// redis expires in milliseconds at most
+	if ttl < time.Millisecond {
+		ttl = time.Millisecond
+	}
+	return ttl
+}
+
+// SetByTags stores value under key and adds key to the set of each tag.
+// Deprecated: use Tags, whose flush does not depend on the number of tagged keys
 func (gr *GRedis) SetByTags(key string, val interface{}, tags []string, expiry time.Duration) error {
+	mValue, err := gr.GetCodec().Marshal(val)
+	if err != nil {
+		return err
+	}
+
+	expiration := gr.expiration(expiry)
 	pipe := gr.Pool.TxPipeline()
 	for _, tag := range tags {
-		pipe.SAdd(CTXRedis, tag, key)
-		pipe.Expire(CTXRedis, tag, expiry)
+		pipe.SAdd(CTXRedis, gr.itemKey(tag), gr.itemKey(key))
+		if expiration > 0 {
+			pipe.PExpire(CTXRedis, gr.itemKey(tag), expiration)
+		}
 	}
 
-	pipe.Set(CTXRedis, key, val, expiry)
+	pipe.Set(CTXRedis, gr.itemKey(key), mValue, expiration)
 
 	_, errExec := pipe.Exec(CTXRedis)
-	return errExec
+	return gr.invalidated(CTXRedis, errExec, key)
 }
 
+// Invalidate deletes the tags stored by SetByTags and their keys,
+// the members are scanned and deleted by batches of DEFAULT_INVALIDATE_BATCH keys.
+// The errors are reported to the hooks as the error of an OperationInvalidate.
+// Deprecated: use Tags(tags...).Flush
 func (gr *GRedis) Invalidate(tags []string) {
-	keys := make([]string, 0)
+	_ = gr.InvalidateCtx(CTXRedis, tags)
+}
+
+// InvalidateCtx is Invalidate returning the errors, a failing tag does not stop the other ones.
+// Deprecated: use Tags(tags...).Flush
+func (gr *GRedis) InvalidateCtx(ctx context.Context, tags []string) (err error) {
+	ctx, finish := gr.hooks.start(ctx, DriverRedis, OperationInvalidate, tags...)
+	defer func() { finish(err) }()
+
+	errs := []error{}
 	for _, tag := range tags {
-		k, _ := gr.Pool.SMembers(CTXRedis, tag).Result()
-		keys = append(keys, tag)
-		keys = append(keys, k...)
+		keys := make([]string, 0, DEFAULT_INVALIDATE_BATCH)
+		iter := gr.Pool.SScan(ctx, gr.itemKey(tag), 0, "", DEFAULT_INVALIDATE_BATCH).Iterator()
+		for iter.Next(ctx) {
+			keys = append(keys, strings.TrimPrefix(iter.Val(), gr.prefix))
+			if len(keys) == DEFAULT_INVALIDATE_BATCH {
+				errs = append(errs, gr.invalidateKeys(ctx, keys))
+				keys = keys[:0]
+			}
+		}
+		if err := iter.Err(); err != nil {
+			errs = append(errs, fmt.Errorf("invalidate tag %s: %w", tag, err))
+			continue
+		}
+
+		errs = append(errs, gr.invalidateKeys(ctx, append(keys, tag)))
 	}
-	gr.Pool.Del(CTXRedis, keys...)
+	return errors.Join(errs...)
+}
+
+func (gr *GRedis) invalidateKeys(ctx context.Context, keys []string) error {
+	return gr.invalidated(ctx, gr.deleteKeys(ctx, keys), keys...)
 }
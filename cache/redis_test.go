@@ -1,17 +1,16 @@
 package cache
 
 import (
+	"github.com/alicebob/miniredis/v2"
 	"github.com/redis/go-redis/v9"
 	"github.com/stretchr/testify/assert"
 	"testing"
 	"time"
 )
 
-var CacheConnection *GRedis
-
 func Test_Remember(t *testing.T) {
 
-	redisCache := getTestGRedis()
+	redisCache := getTestGRedis(t)
 
 	data := map[string]interface{}{
 		"string": "value",
@@ -30,19 +29,54 @@ func Test_Remember(t *testing.T) {
 
 }
 
-func getTestGRedis() *GRedis {
+func Test_GRedisConformance(t *testing.T) {
+	testCacheConformance(t, func(t *testing.T) CacheInterface {
+		return getTestGRedis(t)
+	})
+}
+
+// getTestGRedis connects a GRedis to an in-process redis server which lives as long as the test
+func getTestGRedis(t *testing.T) *GRedis {
 
-	if CacheConnection != nil {
-		return CacheConnection
-	}
+	server := miniredis.RunT(t)
 
-	options := redis.Options{
-		Addr:     "127.0.0.1:6379",
+	options := redis.UniversalOptions{
+		Addrs:    []string{server.Addr()},
 		Password: "",
 		DB:       1,
 	}
 
-	CacheConnection = NewGRedis(&options)
+	return NewGRedis(&options)
+}
+
+func Test_GRedisTTL(t *testing.T) {
+	server := miniredis.RunT(t)
+	gr := NewGRedis(&redis.UniversalOptions{Addrs: []string{server.Addr()}})
+
+	// a zero ttl never expires unless a default expiration is set
+	assert.NoError(t, gr.Set("unset", "value", 0))
+	assert.Equal(t, time.Duration(0), server.TTL("unset"))
+
+	gr.SetDefaultExpiration(5 * time.Minute)
+	assert.NoError(t, gr.Set("zero", "value", 0))
+	assert.Equal(t, 5*time.Minute, server.TTL("zero"))
+	_, err := gr.Remember("remember", 0, func() (interface{}, error) {
+		return "value", nil
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, 5*time.Minute, server.TTL("remember"))
+
+	// a negative ttl never expires and drops the ttl of the previous value
+	assert.NoError(t, gr.Set("negative", "value", time.Minute))
+	assert.NoError(t, gr.Set("negative", "value", -1))
+	assert.Equal(t, time.Duration(0), server.TTL("negative"))
+	assert.NoError(t, gr.SetEx("setex", "value", -1))
+	assert.Equal(t, time.Duration(0), server.TTL("setex"))
 
-	return CacheConnection
+	assert.NoError(t, gr.Forever("forever", "value"))
+	assert.Equal(t, time.Duration(0), server.TTL("forever"))
+	server.FastForward(time.Hour)
+	assert.True(t, gr.Has("forever"))
+	assert.True(t, gr.Has("unset"))
+	assert.False(t, gr.Has("zero"))
 }
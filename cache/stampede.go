@@ -0,0 +1,279 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"math"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+const DEFAULT_STAMPEDE_LOCK_TTL = 10 * time.Second
+const DEFAULT_STAMPEDE_LOCK_WAIT = 5 * time.Second
+const DEFAULT_STAMPEDE_LOCK_RETRY = 50 * time.Millisecond
+
+// errRefreshing tells a background refresh that another instance is already refreshing the key
+var errRefreshing = errors.New("cache: refreshing by another instance")
+
+// flightGroup deduplicates the concurrent calls of the same key within the process, the zero value is ready to use
+type flightGroup struct {
+	mu    sync.Mutex
+	calls map[string]*flightCall
+}
+
+type flightCall struct {
+	done chan struct{}
+	obj  interface{}
+	err  error
+}
+
+// Do executes fn once for all the concurrent callers of key, and hands them the same result.
+// A caller waiting for another one's call gives up when its ctx is done
+func (g *flightGroup) Do(ctx context.Context, key string, fn func() (interface{}, error)) (obj interface{}, err error) {
+	g.mu.Lock()
+	if g.calls == nil {
+		g.calls = map[string]*flightCall{}
+	}
+	if call, found := g.calls[key]; found {
+		g.mu.Unlock()
+		select {
+		case <-call.done:
+			return call.obj, call.err
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+	call := &flightCall{done: make(chan struct{})}
+	g.calls[key] = call
+	g.mu.Unlock()
+
+	g.run(key, call, fn)
+	return call.obj, call.err
+}
+
+// Go executes fn in background unless a call of key is already in flight, it reports whether fn was started
+func (g *flightGroup) Go(key string, fn func() (interface{}, error)) bool {
+	g.mu.Lock()
+	if g.calls == nil {
+		g.calls = map[string]*flightCall{}
+	}
+	if _, found := g.calls[key]; found {
+		g.mu.Unlock()
+		return false
+	}
+	call := &flightCall{done: make(chan struct{})}
+	g.calls[key] = call
+	g.mu.Unlock()
+
+	go g.run(key, call, fn)
+	return true
+}
+
+func (g *flightGroup) run(key string, call *flightCall, fn func() (interface{}, error)) {
+	defer func() {
+		g.mu.Lock()
+		delete(g.calls, key)
+		g.mu.Unlock()
+		close(call.done)
+	}()
+
+	call.obj, call.err = fn()
+}
+
+type StampedeOptions struct {
+	// StaleTTL keeps serving a value for this long after its ttl elapsed, while a single refresh runs in background
+	StaleTTL time.Duration
+	// Beta enables the early probabilistic refresh (XFetch), values are refreshed a bit before their ttl
+	// elapses with a probability growing as it comes closer. 1 is the usual value, 0 disables it
+	Beta float64
+
+	// Lock guards the callback across instances, only the instance holding the lock calls it
+	// while the others wait for the value it stores. The lock is taken with Add on the cache itself,
+	// and released only if it still holds the owner token
+	Lock bool
+	// LockTTL bounds how long the lock is held, DEFAULT_STAMPEDE_LOCK_TTL if not set
+	LockTTL time.Duration
+	// LockWait bounds how long the other instances wait before calling the callback themselves,
+	// DEFAULT_STAMPEDE_LOCK_WAIT if not set
+	LockWait time.Duration
+
+	// OnRefreshError receives the errors of the background refreshes, the stale value keeps being served meanwhile
+	OnRefreshError func(key string, err error)
+}
+
+// staleEnvelope wraps the cached value with its soft expiration
+type staleEnvelope struct {
+	Value interface{} `json:"value"`
+	// SoftExpiration is the unix nano time the value becomes stale, 0 for never
+	SoftExpiration int64 `json:"soft_expiration"`
+	// Delta is how long the callback took to compute the value, in nano seconds
+	Delta int64 `json:"delta"`
+}
+
+// StampedeGuard protects Remember against cache stampedes: concurrent misses are deduplicated,
+// expired values are served stale while a single refresh runs, and the callback can be guarded across instances.
+// The values it stores are wrapped with their soft expiration, so they should only be read through the guard.
+type StampedeGuard struct {
+	cache   CacheInterface
+	options StampedeOptions
+	flight  flightGroup
+}
+
+func NewStampedeGuard(cache CacheInterface, options *StampedeOptions) *StampedeGuard {
+	if options == nil {
+		options = &StampedeOptions{}
+	}
+	opts := *options
+	if opts.LockTTL <= 0 {
+		opts.LockTTL = DEFAULT_STAMPEDE_LOCK_TTL
+	}
+	if opts.LockWait <= 0 {
+		opts.LockWait = DEFAULT_STAMPEDE_LOCK_WAIT
+	}
+
+	return &StampedeGuard{
+		cache:   cache,
+		options: opts,
+	}
+}
+
+// Remember gets key, or executes the callback and stores its result. The value is fresh for ttl,
+// and then served stale for StaleTTL while it is refreshed in background
+func (s *StampedeGuard) Remember(key string, ttl time.Duration, callback func() (interface{}, error)) (obj interface{}, err error) {
+	return s.RememberCtx(CTXRedis, key, ttl, callback)
+}
+
+// RememberCtx is Remember giving up with ctx while it waits for the call of another caller or instance,
+// the background refreshes are not bound to ctx
+func (s *StampedeGuard) RememberCtx(ctx context.Context, key string, ttl time.Duration, callback func() (interface{}, error)) (obj interface{}, err error) {
+	envelope, err := Get[staleEnvelope](s.cache, key)
+	if err == nil {
+		if s.shouldRefresh(envelope, time.Now()) {
+			s.flight.Go(key, func() (interface{}, error) {
+				obj, err := s.load(CTXRedis, key, ttl, callback, false)
+				if err != nil && err != errRefreshing && s.options.OnRefreshError != nil {
+					s.options.OnRefreshError(key, err)
+				}
+				return obj, err
+			})
+		}
+		return envelope.Value, nil
+	}
+	if err != ErrCacheMiss {
+		return nil, err
+	}
+
+	return s.flight.Do(ctx, key, func() (interface{}, error) {
+		return s.load(ctx, key, ttl, callback, true)
+	})
+}
+
+// shouldRefresh reports whether the value is stale, or is chosen to be refreshed early
+func (s *StampedeGuard) shouldRefresh(envelope staleEnvelope, now time.Time) bool {
+	if envelope.SoftExpiration == 0 {
+		return false
+	}
+	remaining := float64(envelope.SoftExpiration - now.UnixNano())
+	if remaining <= 0 {
+		return true
+	}
+	if s.options.Beta <= 0 {
+		return false
+	}
+
+	return -float64(envelope.Delta)*s.options.Beta*math.Log(rand.Float64()) >= remaining
+}
+
+// load calls the callback and stores its result, wait tells whether to wait for another instance holding the lock
+func (s *StampedeGuard) load(ctx context.Context, key string, ttl time.Duration, callback func() (interface{}, error), wait bool) (obj interface{}, err error) {
+	if s.options.Lock {
+		lockKey := key + ":lock"
+		token, err := newLockToken()
+		if err != nil {
+			return nil, err
+		}
+		err = s.cache.Add(lockKey, token, s.options.LockTTL)
+		if err != nil && err != ErrNotStored {
+			return nil, err
+		}
+		if err == nil {
+			// the lock may have expired during a slow callback and be held by another instance by now
+			defer func() { _ = s.releaseLock(lockKey, token) }()
+		} else if !wait {
+			return nil, errRefreshing
+		} else {
+			obj, err = s.waitForValue(ctx, key)
+			if err != ErrCacheMiss {
+				return obj, err
+			}
+			// the lock holder is too slow or gone, compute the value ourselves
+		}
+	}
+
+	start := time.Now()
+	obj, err = callback()
+	if err != nil {
+		return nil, err
+	}
+
+	envelope := staleEnvelope{
+		Value: obj,
+		Delta: int64(time.Since(start)),
+	}
+	expires := ttl
+	if ttl > 0 {
+		envelope.SoftExpiration = time.Now().Add(ttl).UnixNano()
+		expires = ttl + s.options.StaleTTL
+	}
+
+	return obj, s.cache.Set(key, envelope, expires)
+}
+
+// lockReleaser is implemented by the drivers deleting a lock atomically, only if it still holds the owner token
+type lockReleaser interface {
+	releaseLock(ctx context.Context, key string, token string) error
+}
+
+// releaseLock deletes the lock of the guard if it still holds token. The drivers which can not compare
+// and delete atomically compare first, which leaves a short window to release a lock taken meanwhile
+func (s *StampedeGuard) releaseLock(lockKey string, token string) error {
+	if releaser, ok := s.cache.(lockReleaser); ok {
+		return releaser.releaseLock(CTXRedis, lockKey, token)
+	}
+
+	current, err := Get[string](s.cache, lockKey)
+	if err != nil {
+		return err
+	}
+	if current != token {
+		return ErrLockNotHeld
+	}
+	return s.cache.Delete(lockKey)
+}
+
+// waitForValue polls key until the lock holder stores it, LockWait elapses or ctx is done
+func (s *StampedeGuard) waitForValue(ctx context.Context, key string) (obj interface{}, err error) {
+	deadline := time.NewTimer(s.options.LockWait)
+	defer deadline.Stop()
+	ticker := time.NewTicker(DEFAULT_STAMPEDE_LOCK_RETRY)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-deadline.C:
+			return nil, ErrCacheMiss
+		case <-ticker.C:
+		}
+
+		envelope, err := Get[staleEnvelope](s.cache, key)
+		if err == nil {
+			return envelope.Value, nil
+		}
+		if err != ErrCacheMiss {
+			return nil, err
+		}
+	}
+}
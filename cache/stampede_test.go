@@ -0,0 +1,202 @@
+package cache
+
+import (
+	"context"
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func Test_RememberDeduplicatesConcurrentMisses(t *testing.T) {
+	drivers := map[string]func(t *testing.T) CacheInterface{
+		"MemCache": func(t *testing.T) CacheInterface { return newTestMemCache(t) },
+		"GRedis":   func(t *testing.T) CacheInterface { return getTestGRedis(t) },
+	}
+
+	for name, newCache := range drivers {
+		t.Run(name, func(t *testing.T) {
+			c := newCache(t)
+
+			var calls int32
+			var wg sync.WaitGroup
+			for i := 0; i < 20; i++ {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					value, err := c.Remember("key", time.Minute, func() (interface{}, error) {
+						atomic.AddInt32(&calls, 1)
+						time.Sleep(50 * time.Millisecond)
+						return "value", nil
+					})
+					assert.NoError(t, err)
+					assert.Equal(t, "value", value)
+				}()
+			}
+			wg.Wait()
+
+			assert.Equal(t, int32(1), calls)
+		})
+	}
+}
+
+func Test_StampedeGuardStaleWhileRevalidate(t *testing.T) {
+	guard := NewStampedeGuard(newTestMemCache(t), &StampedeOptions{
+		StaleTTL: time.Minute,
+	})
+
+	var calls int32
+	callback := func() (interface{}, error) {
+		n := atomic.AddInt32(&calls, 1)
+		time.Sleep(20 * time.Millisecond)
+		return float64(n), nil
+	}
+
+	value, err := guard.Remember("key", 30*time.Millisecond, callback)
+	assert.NoError(t, err)
+	assert.Equal(t, float64(1), value)
+
+	// the stale value is served while a single refresh runs
+	time.Sleep(40 * time.Millisecond)
+	for i := 0; i < 5; i++ {
+		value, err = guard.Remember("key", 30*time.Millisecond, callback)
+		assert.NoError(t, err)
+		assert.Equal(t, float64(1), value)
+	}
+
+	assert.Eventually(t, func() bool {
+		value, err := guard.Remember("key", time.Minute, callback)
+		return err == nil && value == float64(2)
+	}, time.Second, 5*time.Millisecond)
+	assert.Equal(t, int32(2), atomic.LoadInt32(&calls))
+}
+
+func Test_StampedeGuardEarlyRefresh(t *testing.T) {
+	guard := NewStampedeGuard(newTestMemCache(t), &StampedeOptions{Beta: 1})
+
+	now := time.Now()
+	fresh := staleEnvelope{SoftExpiration: now.Add(time.Hour).UnixNano(), Delta: int64(time.Millisecond)}
+	assert.False(t, guard.shouldRefresh(fresh, now))
+
+	// a value close to its expiration, compared to how long it takes to compute, is refreshed early
+	closeToExpire := staleEnvelope{SoftExpiration: now.Add(time.Nanosecond).UnixNano(), Delta: int64(time.Hour)}
+	assert.True(t, guard.shouldRefresh(closeToExpire, now))
+
+	stale := staleEnvelope{SoftExpiration: now.Add(-time.Second).UnixNano()}
+	assert.True(t, guard.shouldRefresh(stale, now))
+
+	forever := staleEnvelope{}
+	assert.False(t, guard.shouldRefresh(forever, now))
+}
+
+func Test_StampedeGuardLockAcrossInstances(t *testing.T) {
+	server := miniredis.RunT(t)
+	newGuard := func() *StampedeGuard {
+		gr := NewGRedis(&redis.UniversalOptions{Addrs: []string{server.Addr()}})
+		return NewStampedeGuard(gr, &StampedeOptions{Lock: true})
+	}
+
+	var calls int32
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		guard := newGuard()
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			value, err := guard.Remember("key", time.Minute, func() (interface{}, error) {
+				atomic.AddInt32(&calls, 1)
+				time.Sleep(100 * time.Millisecond)
+				return "value", nil
+			})
+			assert.NoError(t, err)
+			assert.Equal(t, "value", value)
+		}()
+	}
+	wg.Wait()
+
+	assert.Equal(t, int32(1), calls)
+	assert.False(t, server.Exists("key:lock"))
+}
+
+func Test_StampedeGuardWaitCanceled(t *testing.T) {
+	c := newTestMemCache(t)
+	holder := NewStampedeGuard(c, &StampedeOptions{Lock: true})
+	waiter := NewStampedeGuard(c, &StampedeOptions{Lock: true, LockWait: time.Minute})
+
+	started := make(chan struct{})
+	release := make(chan struct{})
+	go holder.Remember("key", time.Minute, func() (interface{}, error) {
+		close(started)
+		<-release
+		return "value", nil
+	})
+	<-started
+	defer close(release)
+
+	// a caller waiting for the lock holder of another instance gives up with its ctx
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	_, err := waiter.RememberCtx(ctx, "key", time.Minute, func() (interface{}, error) {
+		return "other", nil
+	})
+	assert.Equal(t, context.DeadlineExceeded, err)
+	assert.Less(t, time.Since(start), time.Second)
+}
+
+func Test_StampedeGuardReleasesOwnLockOnly(t *testing.T) {
+	drivers := map[string]func(t *testing.T) CacheInterface{
+		"MemCache": func(t *testing.T) CacheInterface { return newTestMemCache(t) },
+		"GRedis":   func(t *testing.T) CacheInterface { return getTestGRedis(t) },
+	}
+
+	for name, newCache := range drivers {
+		t.Run(name, func(t *testing.T) {
+			c := newCache(t)
+			guard := NewStampedeGuard(c, &StampedeOptions{Lock: true, LockTTL: time.Minute})
+
+			_, err := guard.Remember("key", time.Minute, func() (interface{}, error) {
+				// the lock expired and another instance took it while the callback was running
+				assert.NoError(t, c.Set("key:lock", "other", time.Minute))
+				return "value", nil
+			})
+			assert.NoError(t, err)
+
+			owner, err := Get[string](c, "key:lock")
+			assert.NoError(t, err)
+			assert.Equal(t, "other", owner)
+		})
+	}
+}
+
+func Test_StampedeGuardRefreshError(t *testing.T) {
+	refreshErrors := make(chan error, 1)
+	guard := NewStampedeGuard(newTestMemCache(t), &StampedeOptions{
+		StaleTTL: time.Minute,
+		OnRefreshError: func(key string, err error) {
+			refreshErrors <- err
+		},
+	})
+
+	_, err := guard.Remember("key", time.Millisecond, func() (interface{}, error) {
+		return "value", nil
+	})
+	assert.NoError(t, err)
+	time.Sleep(5 * time.Millisecond)
+
+	// the stale value is served while the failed refresh is reported
+	value, err := guard.Remember("key", time.Millisecond, func() (interface{}, error) {
+		return nil, ErrServerError
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, "value", value)
+	select {
+	case err = <-refreshErrors:
+		assert.Equal(t, ErrServerError, err)
+	case <-time.After(time.Second):
+		t.Fatal("the refresh error is not reported")
+	}
+}
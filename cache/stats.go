@@ -0,0 +1,334 @@
+package cache
+
+import (
+	"bufio"
+	"context"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// DEFAULT_LATENCY_BUCKETS are the upper bounds of the latency histograms
+var DEFAULT_LATENCY_BUCKETS = []time.Duration{
+	100 * time.Microsecond,
+	250 * time.Microsecond,
+	500 * time.Microsecond,
+	time.Millisecond,
+	2500 * time.Microsecond,
+	5 * time.Millisecond,
+	10 * time.Millisecond,
+	25 * time.Millisecond,
+	50 * time.Millisecond,
+	100 * time.Millisecond,
+	250 * time.Millisecond,
+	500 * time.Millisecond,
+	time.Second,
+}
+
+// Stats is a snapshot of the statistics of a driver since it was created
+type Stats struct {
+	Driver string
+	// Hits and Misses count the get, get with version and pull operations
+	Hits   uint64
+	Misses uint64
+	// Sets counts the keys stored by the set, add, compare and swap and set multiple operations
+	Sets uint64
+	// Evictions counts the items dropped to fit the bounds of a MemCache,
+	// for GRedis it is the evicted_keys of the redis servers, shared by all their clients
+	Evictions uint64
+	// Errors counts the failed operations, a miss, a key already added or a CAS conflict is not an error
+	Errors uint64
+	// Latencies holds a histogram per operation name, such as OperationGet
+	Latencies map[string]*LatencyHistogram
+}
+
+// HitRatio returns the share of the reads which were hits, 0 if nothing was read yet
+func (stats *Stats) HitRatio() float64 {
+	reads := stats.Hits + stats.Misses
+	if reads == 0 {
+		return 0
+	}
+	return float64(stats.Hits) / float64(reads)
+}
+
+// Attributes converts the counters into OpenTelemetry attributes, to be recorded on a span.
+// The package does not record OpenTelemetry metric instruments, a StatsExporter can feed them from Stats
+func (stats *Stats) Attributes() []attribute.KeyValue {
+	return []attribute.KeyValue{
+		attribute.String("cache.driver", stats.Driver),
+		attribute.Int64("cache.hits", int64(stats.Hits)),
+		attribute.Int64("cache.misses", int64(stats.Misses)),
+		attribute.Int64("cache.sets", int64(stats.Sets)),
+		attribute.Int64("cache.evictions", int64(stats.Evictions)),
+		attribute.Int64("cache.errors", int64(stats.Errors)),
+		attribute.Float64("cache.hit_ratio", stats.HitRatio()),
+	}
+}
+
+// LatencyHistogram counts the operations per latency bucket
+type LatencyHistogram struct {
+	// Bounds are the inclusive upper bounds of the buckets,
+	// Counts has one more bucket at the end for the operations slower than the last bound
+	Bounds []time.Duration
+	Counts []uint64
+	Count  uint64
+	Sum    time.Duration
+}
+
+func newLatencyHistogram(bounds []time.Duration) *LatencyHistogram {
+	return &LatencyHistogram{
+		Bounds: bounds,
+		Counts: make([]uint64, len(bounds)+1),
+	}
+}
+
+func (h *LatencyHistogram) observe(latency time.Duration) {
+	i := 0
+	for i < len(h.Bounds) && latency > h.Bounds[i] {
+		i++
+	}
+	h.Counts[i]++
+	h.Count++
+	h.Sum += latency
+}
+
+// Mean returns the average latency
+func (h *LatencyHistogram) Mean() time.Duration {
+	if h.Count == 0 {
+		return 0
+	}
+	return h.Sum / time.Duration(h.Count)
+}
+
+// Quantile returns the upper bound of the bucket holding the q quantile, such as 0.99,
+// or -1 if it is slower than the last bound
+func (h *LatencyHistogram) Quantile(q float64) time.Duration {
+	if h.Count == 0 {
+		return 0
+	}
+
+	rank := uint64(q * float64(h.Count))
+	if rank >= h.Count {
+		rank = h.Count - 1
+	}
+	var seen uint64
+	for i, count := range h.Counts {
+		seen += count
+		if seen > rank && i < len(h.Bounds) {
+			return h.Bounds[i]
+		}
+	}
+	return -1
+}
+
+func (h *LatencyHistogram) clone() *LatencyHistogram {
+	clone := *h
+	clone.Counts = append([]uint64{}, h.Counts...)
+	return &clone
+}
+
+// statsRecorder is the hook counting the operations of a driver, it is the first hook of the chain
+type statsRecorder struct {
+	driver    string
+	hits      uint64
+	misses    uint64
+	sets      uint64
+	evictions uint64
+	errors    uint64
+
+	mu        sync.Mutex
+	latencies map[string]*LatencyHistogram
+}
+
+func newStatsRecorder(driver string) *statsRecorder {
+	return &statsRecorder{
+		driver:    driver,
+		latencies: map[string]*LatencyHistogram{},
+	}
+}
+
+func (r *statsRecorder) BeforeOperation(ctx context.Context, operation *Operation) context.Context {
+	return ctx
+}
+
+func (r *statsRecorder) AfterOperation(ctx context.Context, operation *Operation) {
+	latency := time.Since(operation.Start)
+
+	switch operation.Name {
+	case OperationGet, OperationPull, OperationGetWithVersion:
+		if operation.Err == nil {
+			atomic.AddUint64(&r.hits, 1)
+		} else if operation.Err == ErrCacheMiss {
+			atomic.AddUint64(&r.misses, 1)
+		}
+	case OperationSet, OperationAdd, OperationCompareAndSwap:
+		if operation.Err == nil {
+			atomic.AddUint64(&r.sets, 1)
+		}
+	case OperationGetMultiple:
+		if operation.Err == nil {
+			atomic.AddUint64(&r.hits, uint64(len(operation.Keys)-operation.Misses))
+			atomic.AddUint64(&r.misses, uint64(operation.Misses))
+		}
+	case OperationSetMultiple:
+		if operation.Err == nil {
+			atomic.AddUint64(&r.sets, uint64(len(operation.Keys)))
+		}
+	}
+
+	// the composite operations are accounted by the operations they run,
+	// their own errors are mostly the ones of the callbacks
+	composite := operation.Name == OperationRemember || operation.Name == OperationRememberForever || operation.Name == OperationForever
+	if !composite && operation.Err != nil && operation.Err != ErrCacheMiss && operation.Err != ErrNotStored && operation.Err != ErrCASConflict {
+		atomic.AddUint64(&r.errors, 1)
+	}
+
+	r.mu.Lock()
+	histogram, found := r.latencies[operation.Name]
+	if !found {
+		histogram = newLatencyHistogram(DEFAULT_LATENCY_BUCKETS)
+		r.latencies[operation.Name] = histogram
+	}
+	histogram.observe(latency)
+	r.mu.Unlock()
+}
+
+func (r *statsRecorder) evicted() {
+	atomic.AddUint64(&r.evictions, 1)
+}
+
+func (r *statsRecorder) snapshot() (*Stats, error) {
+	if r == nil {
+		return nil, ErrNoStats
+	}
+
+	stats := &Stats{
+		Driver:    r.driver,
+		Hits:      atomic.LoadUint64(&r.hits),
+		Misses:    atomic.LoadUint64(&r.misses),
+		Sets:      atomic.LoadUint64(&r.sets),
+		Evictions: atomic.LoadUint64(&r.evictions),
+		Errors:    atomic.LoadUint64(&r.errors),
+		Latencies: map[string]*LatencyHistogram{},
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for name, histogram := range r.latencies {
+		stats.Latencies[name] = histogram.clone()
+	}
+
+	return stats, nil
+}
+
+// Stats returns the statistics of the instance, the evictions are the evicted_keys reported by INFO
+func (gr *GRedis) Stats() (*Stats, error) {
+	stats, err := gr.stats.snapshot()
+	if err != nil {
+		return nil, err
+	}
+
+	stats.Evictions, err = gr.serverEvictions(CTXRedis)
+	return stats, err
+}
+
+// serverEvictions sums the evicted_keys of the servers, 0 if INFO does not report them
+func (gr *GRedis) serverEvictions(ctx context.Context) (uint64, error) {
+	nodes, err := gr.scanNodes(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	var evictions uint64
+	for _, node := range nodes {
+		info, err := node.Info(ctx).Result()
+		if err != nil {
+			return 0, err
+		}
+
+		scanner := bufio.NewScanner(strings.NewReader(info))
+		for scanner.Scan() {
+			value, found := strings.CutPrefix(strings.TrimSpace(scanner.Text()), "evicted_keys:")
+			if !found {
+				continue
+			}
+			count, err := strconv.ParseUint(value, 10, 64)
+			if err != nil {
+				return 0, err
+			}
+			evictions += count
+		}
+	}
+
+	return evictions, nil
+}
+
+func (cache *MemCache) Stats() (*Stats, error) {
+	return cache.stats.snapshot()
+}
+
+// Stats returns the statistics of the layered operations, the evictions are the ones of the near tier
+func (lc *LayeredCache) Stats() (*Stats, error) {
+	stats, err := lc.stats.snapshot()
+	if err != nil {
+		return nil, err
+	}
+
+	stats.Evictions = atomic.LoadUint64(&lc.near.stats.evictions)
+	return stats, nil
+}
+
+// Stats returns the statistics of the store, the operations of all its tags included
+func (tc *TaggedCache) Stats() (*Stats, error) {
+	return tc.store.Stats()
+}
+
+// StatsExporter receives the statistics of a cache periodically, to feed a metrics pipeline such as Prometheus gauges
+// or OpenTelemetry instruments, which are left to the application
+type StatsExporter interface {
+	ExportStats(ctx context.Context, stats *Stats) error
+}
+
+// StatsExporterFunc adapts a function to StatsExporter
+type StatsExporterFunc func(ctx context.Context, stats *Stats) error
+
+func (f StatsExporterFunc) ExportStats(ctx context.Context, stats *Stats) error {
+	return f(ctx, stats)
+}
+
+// StatsErrorReporter may be implemented by a StatsExporter to be told the errors of ExportStats,
+// the ones of Stats and the ones returned by ExportStats itself
+type StatsErrorReporter interface {
+	ReportStatsError(ctx context.Context, err error)
+}
+
+// ExportStats hands the statistics of c to exporter every interval until ctx is done, it blocks so run it in a goroutine.
+// A failing tick is reported to the exporter if it implements StatsErrorReporter, and the next tick runs as usual.
+// It returns the error of ctx, or ErrNoStats at once if c does not collect statistics
+func ExportStats(ctx context.Context, c CacheInterface, interval time.Duration, exporter StatsExporter) error {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	reporter, _ := exporter.(StatsErrorReporter)
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+			stats, err := c.Stats()
+			if err == ErrNoStats {
+				return err
+			}
+			if err == nil {
+				err = exporter.ExportStats(ctx, stats)
+			}
+			if err != nil && reporter != nil {
+				reporter.ReportStatsError(ctx, err)
+			}
+		}
+	}
+}
@@ -0,0 +1,144 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel/attribute"
+	"testing"
+	"time"
+)
+
+func Test_Stats(t *testing.T) {
+	drivers := map[string]func(t *testing.T) CacheInterface{
+		"MemCache": func(t *testing.T) CacheInterface { return newTestMemCache(t) },
+		"GRedis":   func(t *testing.T) CacheInterface { return getTestGRedis(t) },
+	}
+
+	for name, newCache := range drivers {
+		t.Run(name, func(t *testing.T) {
+			c := newCache(t)
+
+			assert.NoError(t, c.Set("key", "value", time.Minute))
+			assert.NoError(t, c.SetMultiple(map[string]interface{}{"a": 1, "b": 2}, time.Minute))
+			assert.Equal(t, ErrNotStored, c.Add("key", "value", time.Minute))
+			_, err := c.Get("key", nil)
+			assert.NoError(t, err)
+			_, err = c.Get("missing", nil)
+			assert.Equal(t, ErrCacheMiss, err)
+			_, err = c.Pull("a", nil)
+			assert.NoError(t, err)
+			_, err = c.GetMultiple([]string{"b", "a", "missing"}, nil)
+			assert.NoError(t, err)
+			_, err = c.Increment("key", 1)
+			assert.Equal(t, ErrInvalidValue, err)
+			_, err = c.Remember("remember", time.Minute, func() (interface{}, error) {
+				return nil, errors.New("callback error")
+			})
+			assert.Error(t, err)
+
+			stats, err := c.Stats()
+			assert.NoError(t, err)
+			// every key of GetMultiple is counted
+			assert.Equal(t, uint64(3), stats.Hits)
+			// the miss of remember is counted by the get it runs
+			assert.Equal(t, uint64(4), stats.Misses)
+			assert.Equal(t, uint64(3), stats.Sets)
+			assert.Equal(t, uint64(1), stats.Errors)
+			assert.InDelta(t, 3.0/7, stats.HitRatio(), 0.001)
+
+			get := stats.Latencies[OperationGet]
+			assert.Equal(t, uint64(3), get.Count)
+			assert.Len(t, get.Counts, len(DEFAULT_LATENCY_BUCKETS)+1)
+			assert.True(t, get.Mean() > 0)
+			assert.True(t, get.Quantile(0.99) != 0)
+		})
+	}
+}
+
+func Test_StatsEvictions(t *testing.T) {
+	c, err := NewMemCacheWithOptions(&MemCacheOptions{MaxEntries: 2})
+	assert.NoError(t, err)
+	t.Cleanup(func() { c.Close() })
+
+	for _, key := range []string{"a", "b", "c", "d"} {
+		assert.NoError(t, c.Set(key, key, time.Minute))
+	}
+
+	stats, err := c.Stats()
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(2), stats.Evictions)
+}
+
+func Test_StatsNotCollected(t *testing.T) {
+	_, err := (&GRedis{}).Stats()
+	assert.Equal(t, ErrNoStats, err)
+}
+
+func Test_LatencyHistogram(t *testing.T) {
+	h := newLatencyHistogram([]time.Duration{time.Millisecond, 10 * time.Millisecond})
+	for i := 0; i < 9; i++ {
+		h.observe(500 * time.Microsecond)
+	}
+	h.observe(time.Second)
+
+	assert.Equal(t, []uint64{9, 0, 1}, h.Counts)
+	assert.Equal(t, time.Millisecond, h.Quantile(0.5))
+	assert.Equal(t, time.Duration(-1), h.Quantile(0.99))
+}
+
+func Test_ExportStats(t *testing.T) {
+	c := newTestMemCache(t)
+	assert.NoError(t, c.Set("key", "value", time.Minute))
+
+	exported := make(chan *Stats, 1)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() {
+		done <- ExportStats(ctx, c, 10*time.Millisecond, StatsExporterFunc(func(ctx context.Context, stats *Stats) error {
+			select {
+			case exported <- stats:
+			default:
+			}
+			return nil
+		}))
+	}()
+
+	stats := <-exported
+	assert.Equal(t, DriverMemory, stats.Driver)
+	assert.Equal(t, uint64(1), stats.Sets)
+	assert.Contains(t, stats.Attributes(), attribute.Int64("cache.sets", 1))
+
+	cancel()
+	assert.Equal(t, context.Canceled, <-done)
+
+	// the errors are reported to the exporter and the export goes on
+	exporter := &failingExporter{errs: make(chan error, 3)}
+	ctx, cancel = context.WithCancel(context.Background())
+	go func() {
+		done <- ExportStats(ctx, c, time.Millisecond, exporter)
+	}()
+	for i := 0; i < 3; i++ {
+		assert.Equal(t, ErrServerError, <-exporter.errs)
+	}
+	cancel()
+	assert.Equal(t, context.Canceled, <-done)
+
+	assert.Equal(t, ErrNoStats, ExportStats(context.Background(), &GRedis{}, time.Millisecond, exporter))
+}
+
+// failingExporter fails every export and reports the errors on errs
+type failingExporter struct {
+	errs chan error
+}
+
+func (e *failingExporter) ExportStats(ctx context.Context, stats *Stats) error {
+	return ErrServerError
+}
+
+func (e *failingExporter) ReportStatsError(ctx context.Context, err error) {
+	select {
+	case e.errs <- err:
+	default:
+	}
+}
@@ -0,0 +1,279 @@
+package cache
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/dadiYazZ/xin-da-libs/object"
+	"github.com/google/uuid"
+)
+
+// TAG_KEY_PREFIX prefixes the keys holding the current version of each tag
+const TAG_KEY_PREFIX = "tag:"
+
+// TaggedCache scopes the items of a store to a set of tags.
+// Each tag has a version stored under "tag:<name>:key", and the items are stored under a namespace derived from
+// the versions of their tags. Flushing a tag only replaces its version, which is O(1) whatever the number of items:
+// the items of the previous namespace become unreachable and are left to their ttl or to eviction.
+type TaggedCache struct {
+	store CodecCacheInterface
+	names []string
+}
+
+func newTaggedCache(store CodecCacheInterface, names []string) *TaggedCache {
+	// the order of the tags does not matter, Tags("a", "b") and Tags("b", "a") share their items
+	sorted := make([]string, 0, len(names))
+	seen := map[string]bool{}
+	for _, name := range names {
+		if !seen[name] {
+			seen[name] = true
+			sorted = append(sorted, name)
+		}
+	}
+	sort.Strings(sorted)
+
+	return &TaggedCache{
+		store: store,
+		names: sorted,
+	}
+}
+
+// Tags returns a cache whose items are flushed together with any of the tags
+func (gr *GRedis) Tags(names ...string) *TaggedCache {
+	return newTaggedCache(gr, names)
+}
+
+// Tags returns a cache whose items are flushed together with any of the tags
+func (cache *MemCache) Tags(names ...string) *TaggedCache {
+	return newTaggedCache(cache, names)
+}
+
+// Tags returns a cache whose items are flushed together with any of the tags
+func (lc *LayeredCache) Tags(names ...string) *TaggedCache {
+	return newTaggedCache(lc, names)
+}
+
+// Names returns the sorted tags of the cache
+func (tc *TaggedCache) Names() []string {
+	return tc.names
+}
+
+// ItemKey returns the key key is stored under in the current namespace of the tags
+func (tc *TaggedCache) ItemKey(key string) (string, error) {
+	namespace, err := tc.namespace()
+	if err != nil {
+		return "", err
+	}
+	return namespace + ":" + key, nil
+}
+
+// Flush drops all the items of the tags by replacing their versions
+func (tc *TaggedCache) Flush() error {
+	for _, name := range tc.names {
+		err := tc.store.Forever(tagKey(name), newTagVersion())
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// namespace hashes the versions of the tags, the missing versions are created
+func (tc *TaggedCache) namespace() (string, error) {
+	versions := make([]string, 0, len(tc.names))
+	for _, name := range tc.names {
+		version, err := Get[string](tc.store, tagKey(name))
+		if err == ErrCacheMiss {
+			// the first writers of a tag may race, they all use the version stored first
+			version = newTagVersion()
+			err = tc.store.Add(tagKey(name), version, noExpiration)
+			if err == ErrNotStored {
+				version, err = Get[string](tc.store, tagKey(name))
+			}
+		}
+		if err != nil {
+			return "", err
+		}
+		versions = append(versions, version)
+	}
+
+	sum := sha1.Sum([]byte(strings.Join(versions, "|")))
+	return hex.EncodeToString(sum[:]), nil
+}
+
+func (tc *TaggedCache) itemKeys(keys []string) ([]string, error) {
+	namespace, err := tc.namespace()
+	if err != nil {
+		return nil, err
+	}
+
+	itemKeys := make([]string, 0, len(keys))
+	for _, key := range keys {
+		itemKeys = append(itemKeys, namespace+":"+key)
+	}
+	return itemKeys, nil
+}
+
+func (tc *TaggedCache) SetCodec(codec Codec) {
+	tc.store.SetCodec(codec)
+}
+
+func (tc *TaggedCache) GetCodec() Codec {
+	return tc.store.GetCodec()
+}
+
+func (tc *TaggedCache) GetBytes(key string) ([]byte, error) {
+	itemKey, err := tc.ItemKey(key)
+	if err != nil {
+		return nil, err
+	}
+	return tc.store.GetBytes(itemKey)
+}
+
+func (tc *TaggedCache) Get(key string, defaultValue interface{}) (ptrValue interface{}, err error) {
+	itemKey, err := tc.ItemKey(key)
+	if err != nil {
+		return nil, err
+	}
+	return tc.store.Get(itemKey, defaultValue)
+}
+
+func (tc *TaggedCache) Set(key string, value interface{}, expires time.Duration) error {
+	itemKey, err := tc.ItemKey(key)
+	if err != nil {
+		return err
+	}
+	return tc.store.Set(itemKey, value, expires)
+}
+
+func (tc *TaggedCache) Delete(key string) error {
+	itemKey, err := tc.ItemKey(key)
+	if err != nil {
+		return err
+	}
+	return tc.store.Delete(itemKey)
+}
+
+// Clear flushes the tags, the other items of the store are kept
+func (tc *TaggedCache) Clear() error {
+	return tc.Flush()
+}
+
+func (tc *TaggedCache) GetMultiple(keys []string, defaultValue interface{}) (values object.HashMap, err error) {
+	itemKeys, err := tc.itemKeys(keys)
+	if err != nil {
+		return nil, err
+	}
+
+	itemValues, err := tc.store.GetMultiple(itemKeys, defaultValue)
+	if err != nil {
+		return nil, err
+	}
+
+	values = object.HashMap{}
+	for i, key := range keys {
+		values[key] = itemValues[itemKeys[i]]
+	}
+	return values, nil
+}
+
+func (tc *TaggedCache) SetMultiple(values object.HashMap, ttl time.Duration) error {
+	namespace, err := tc.namespace()
+	if err != nil {
+		return err
+	}
+
+	itemValues := object.HashMap{}
+	for key, value := range values {
+		itemValues[namespace+":"+key] = value
+	}
+	return tc.store.SetMultiple(itemValues, ttl)
+}
+
+func (tc *TaggedCache) DeleteMultiple(keys []string) error {
+	itemKeys, err := tc.itemKeys(keys)
+	if err != nil {
+		return err
+	}
+	return tc.store.DeleteMultiple(itemKeys)
+}
+
+func (tc *TaggedCache) Has(key string) bool {
+	itemKey, err := tc.ItemKey(key)
+	if err != nil {
+		return false
+	}
+	return tc.store.Has(itemKey)
+}
+
+func (tc *TaggedCache) Pull(key string, defaultValue interface{}) (ptrValue interface{}, err error) {
+	itemKey, err := tc.ItemKey(key)
+	if err != nil {
+		return nil, err
+	}
+	return tc.store.Pull(itemKey, defaultValue)
+}
+
+func (tc *TaggedCache) AddNX(key string, value interface{}, ttl time.Duration) bool {
+	return tc.Add(key, value, ttl) == nil
+}
+
+func (tc *TaggedCache) Add(key string, value interface{}, ttl time.Duration) (err error) {
+	itemKey, err := tc.ItemKey(key)
+	if err != nil {
+		return err
+	}
+	return tc.store.Add(itemKey, value, ttl)
+}
+
+func (tc *TaggedCache) Increment(key string, value int64) (int64, error) {
+	itemKey, err := tc.ItemKey(key)
+	if err != nil {
+		return 0, err
+	}
+	return tc.store.Increment(itemKey, value)
+}
+
+func (tc *TaggedCache) Decrement(key string, value int64) (int64, error) {
+	return tc.Increment(key, -value)
+}
+
+// Forever stores value without ttl, it is kept after the tags are flushed until the store evicts it
+func (tc *TaggedCache) Forever(key string, value interface{}) error {
+	itemKey, err := tc.ItemKey(key)
+	if err != nil {
+		return err
+	}
+	return tc.store.Forever(itemKey, value)
+}
+
+func (tc *TaggedCache) Remember(key string, ttl time.Duration, callback func() (interface{}, error)) (obj interface{}, err error) {
+	itemKey, err := tc.ItemKey(key)
+	if err != nil {
+		return nil, err
+	}
+	return tc.store.Remember(itemKey, ttl, callback)
+}
+
+func (tc *TaggedCache) RememberForever(key string, callback func() (interface{}, error)) (obj interface{}, err error) {
+	itemKey, err := tc.ItemKey(key)
+	if err != nil {
+		return nil, err
+	}
+	return tc.store.RememberForever(itemKey, callback)
+}
+
+func (tc *TaggedCache) Forget(key string) error {
+	return tc.Delete(key)
+}
+
+func tagKey(name string) string {
+	return TAG_KEY_PREFIX + name + ":key"
+}
+
+func newTagVersion() string {
+	return strings.ReplaceAll(uuid.New().String(), "-", "")
+}
@@ -0,0 +1,120 @@
+package cache
+
+import (
+	"fmt"
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+	"sync"
+	"testing"
+	"time"
+)
+
+func Test_TaggedCacheConformance(t *testing.T) {
+	testCacheConformance(t, func(t *testing.T) CacheInterface {
+		return newTestMemCache(t).Tags("tag")
+	})
+}
+
+func Test_TaggedCacheFlush(t *testing.T) {
+	drivers := map[string]func(t *testing.T) CacheInterface{
+		"MemCache": func(t *testing.T) CacheInterface { return newTestMemCache(t) },
+		"GRedis":   func(t *testing.T) CacheInterface { return getTestGRedis(t) },
+	}
+
+	for name, newCache := range drivers {
+		t.Run(name, func(t *testing.T) {
+			c := newCache(t)
+			tags := func(names ...string) *TaggedCache {
+				return c.(interface {
+					Tags(names ...string) *TaggedCache
+				}).Tags(names...)
+			}
+
+			assert.NoError(t, tags("users", "posts").Set("key", "both", time.Minute))
+			assert.NoError(t, tags("users").Set("key", "users", time.Minute))
+			assert.NoError(t, tags("posts").Set("key", "posts", time.Minute))
+			assert.NoError(t, c.Set("key", "untagged", time.Minute))
+
+			// the order of the tags does not matter
+			value, err := tags("posts", "users").Get("key", nil)
+			assert.NoError(t, err)
+			assert.Equal(t, "both", value)
+
+			assert.NoError(t, tags("users").Flush())
+
+			_, err = tags("users", "posts").Get("key", nil)
+			assert.Equal(t, ErrCacheMiss, err)
+			_, err = tags("users").Get("key", nil)
+			assert.Equal(t, ErrCacheMiss, err)
+
+			value, err = tags("posts").Get("key", nil)
+			assert.NoError(t, err)
+			assert.Equal(t, "posts", value)
+			value, err = c.Get("key", nil)
+			assert.NoError(t, err)
+			assert.Equal(t, "untagged", value)
+
+			// typed reads decode the stored bytes
+			assert.NoError(t, tags("users").Set("user", map[string]int{"id": 1}, time.Minute))
+			user, err := Get[map[string]int](tags("users"), "user")
+			assert.NoError(t, err)
+			assert.Equal(t, map[string]int{"id": 1}, user)
+		})
+	}
+}
+
+func Test_TaggedCacheConcurrentFirstWriters(t *testing.T) {
+	gr := getTestGRedis(t)
+
+	// the first writers of a tag all end up in the same namespace
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			assert.NoError(t, gr.Tags("users").Set(fmt.Sprintf("key%d", i), i, time.Minute))
+		}(i)
+	}
+	wg.Wait()
+
+	for i := 0; i < 10; i++ {
+		value, err := Get[int](gr.Tags("users"), fmt.Sprintf("key%d", i))
+		assert.NoError(t, err)
+		assert.Equal(t, i, value)
+	}
+}
+
+func Test_TaggedCacheEncodesValues(t *testing.T) {
+	server := miniredis.RunT(t)
+	gr := NewGRedis(&redis.UniversalOptions{Addrs: []string{server.Addr()}})
+
+	tagged := gr.Tags("users")
+	assert.NoError(t, tagged.Set("key", "value", time.Minute))
+
+	itemKey, err := tagged.ItemKey("key")
+	assert.NoError(t, err)
+	stored, err := server.Get(itemKey)
+	assert.NoError(t, err)
+	assert.Equal(t, `"value"`, stored)
+}
+
+func Test_SetByTagsInvalidate(t *testing.T) {
+	server := miniredis.RunT(t)
+	gr := NewGRedis(&redis.UniversalOptions{Addrs: []string{server.Addr()}})
+
+	for i := 0; i < DEFAULT_INVALIDATE_BATCH+10; i++ {
+		assert.NoError(t, gr.SetByTags(fmt.Sprintf("key%d", i), "value", []string{"tag"}, time.Minute))
+	}
+	assert.NoError(t, gr.Set("other", "value", time.Minute))
+
+	stored, err := server.Get("key0")
+	assert.NoError(t, err)
+	assert.Equal(t, `"value"`, stored)
+	value, err := gr.Get("key0", nil)
+	assert.NoError(t, err)
+	assert.Equal(t, "value", value)
+
+	gr.Invalidate([]string{"tag"})
+	assert.Equal(t, []string{"other"}, server.Keys())
+}
@@ -0,0 +1,88 @@
+package cache
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// Get fetches key from the cache and decodes it straight into T.
+// Drivers implementing CodecCacheInterface decode the stored bytes with their codec,
+// the others fall back to converting the value returned by CacheInterface.Get through json.
+func Get[T any](c CacheInterface, key string) (value T, err error) {
+	if codecCache, ok := c.(CodecCacheInterface); ok {
+		b, err := codecCache.GetBytes(key)
+		if err != nil {
+			return value, err
+		}
+		err = codecCache.GetCodec().Unmarshal(b, &value)
+		return value, err
+	}
+
+	obj, err := c.Get(key, nil)
+	if err != nil {
+		return value, err
+	}
+	return convert[T](obj)
+}
+
+// convert returns obj as T, converting it through json unless it already is a T
+func convert[T any](obj interface{}) (value T, err error) {
+	if typed, ok := obj.(T); ok {
+		return typed, nil
+	}
+
+	b, err := json.Marshal(obj)
+	if err != nil {
+		return value, err
+	}
+	err = json.Unmarshal(b, &value)
+	return value, err
+}
+
+// Remember gets key as T, or executes the callback and stores its result for ttl.
+// The misses go through the Remember of the driver, so that concurrent callers share a single callback
+func Remember[T any](c CacheInterface, key string, ttl time.Duration, callback func() (T, error)) (value T, err error) {
+	value, err = Get[T](c, key)
+	if err != ErrCacheMiss {
+		return value, err
+	}
+
+	obj, err := c.Remember(key, ttl, func() (interface{}, error) {
+		return callback()
+	})
+	if err != nil {
+		return value, err
+	}
+	return remembered[T](c, key, obj)
+}
+
+// RememberForever gets key as T, or executes the callback and stores its result forever
+func RememberForever[T any](c CacheInterface, key string, callback func() (T, error)) (value T, err error) {
+	value, err = Get[T](c, key)
+	if err != ErrCacheMiss {
+		return value, err
+	}
+
+	obj, err := c.RememberForever(key, func() (interface{}, error) {
+		return callback()
+	})
+	if err != nil {
+		return value, err
+	}
+	return remembered[T](c, key, obj)
+}
+
+// remembered returns the result of a Remember of the driver as T. It is the T of the callback unless the value
+// was stored in the meantime, which is then read again with the codec of the driver
+func remembered[T any](c CacheInterface, key string, obj interface{}) (value T, err error) {
+	if typed, ok := obj.(T); ok {
+		return typed, nil
+	}
+	if _, ok := c.(CodecCacheInterface); ok {
+		value, err = Get[T](c, key)
+		if err != ErrCacheMiss {
+			return value, err
+		}
+	}
+	return convert[T](obj)
+}
@@ -0,0 +1,111 @@
+package cache
+
+import (
+	"github.com/stretchr/testify/assert"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+type typedTestProfile struct {
+	Name   string
+	Age    int
+	Tags   []string
+	Active bool
+}
+
+func Test_TypedGetRemember(t *testing.T) {
+	profile := typedTestProfile{
+		Name:   "artisan",
+		Age:    18,
+		Tags:   []string{"a", "b"},
+		Active: true,
+	}
+
+	drivers := map[string]func(t *testing.T) CodecCacheInterface{
+		"MemCache": func(t *testing.T) CodecCacheInterface { return newTestMemCache(t) },
+		"GRedis":   func(t *testing.T) CodecCacheInterface { return getTestGRedis(t) },
+	}
+	codecs := map[string]Codec{
+		"json":    JsonCodec,
+		"gob":     GobCodec,
+		"msgpack": MsgpackCodec,
+	}
+
+	for driverName, newCache := range drivers {
+		for codecName, codec := range codecs {
+			t.Run(driverName+"/"+codecName, func(t *testing.T) {
+				c := newCache(t)
+				c.SetCodec(codec)
+
+				_, err := Get[typedTestProfile](c, "profile")
+				assert.Equal(t, ErrCacheMiss, err)
+
+				calls := 0
+				callback := func() (typedTestProfile, error) {
+					calls++
+					return profile, nil
+				}
+				value, err := Remember(c, "profile", time.Minute, callback)
+				assert.NoError(t, err)
+				assert.Equal(t, profile, value)
+
+				value, err = Remember(c, "profile", time.Minute, callback)
+				assert.NoError(t, err)
+				assert.Equal(t, profile, value)
+				assert.Equal(t, 1, calls)
+
+				value, err = Get[typedTestProfile](c, "profile")
+				assert.NoError(t, err)
+				assert.Equal(t, profile, value)
+			})
+		}
+	}
+}
+
+func Test_TypedRememberDeduplicates(t *testing.T) {
+	c := newTestMemCache(t)
+
+	var calls int32
+	release := make(chan struct{})
+	wg := sync.WaitGroup{}
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			value, err := Remember(c, "profile", time.Minute, func() (typedTestProfile, error) {
+				atomic.AddInt32(&calls, 1)
+				<-release
+				return typedTestProfile{Name: "artisan"}, nil
+			})
+			assert.NoError(t, err)
+			assert.Equal(t, "artisan", value.Name)
+		}()
+	}
+
+	// the concurrent misses share the callback through the Remember of the driver
+	time.Sleep(20 * time.Millisecond)
+	close(release)
+	wg.Wait()
+	assert.Equal(t, int32(1), atomic.LoadInt32(&calls))
+}
+
+// untypedCache hides the codec of a driver, to exercise the json fallback of Get
+type untypedCache struct {
+	CacheInterface
+}
+
+func Test_TypedGetFallback(t *testing.T) {
+	c := untypedCache{newTestMemCache(t)}
+
+	assert.NoError(t, c.Set("profile", typedTestProfile{Name: "artisan", Age: 18}, time.Minute))
+	value, err := Get[typedTestProfile](c, "profile")
+	assert.NoError(t, err)
+	assert.Equal(t, typedTestProfile{Name: "artisan", Age: 18}, value)
+
+	assert.NoError(t, c.Set("name", "artisan", time.Minute))
+	name, err := Get[string](c, "name")
+	assert.NoError(t, err)
+	assert.Equal(t, "artisan", name)
+}
@@ -0,0 +1,367 @@
+package cassette
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v3"
+)
+
+// REDACTED 替换被脱敏的值
+const REDACTED = "[REDACTED]"
+
+// ErrInteractionNotFound 回放时 cassette 中没有匹配请求的记录
+var ErrInteractionNotFound = errors.New("cassette: no recorded interaction matches the request")
+
+type Mode int
+
+const (
+	// ModeReplay 只回放记录, 没有匹配的记录时返回 ErrInteractionNotFound, 不会发出真实请求
+	ModeReplay Mode = iota
+	// ModeRecord 总是发出真实请求, 覆盖原有的 cassette
+	ModeRecord
+	// ModeReplayOrRecord 有匹配的记录时回放, 否则发出真实请求并追加到 cassette
+	ModeReplayOrRecord
+)
+
+type Request struct {
+	Method string      `json:"method" yaml:"method"`
+	Url    string      `json:"url" yaml:"url"`
+	Header http.Header `json:"header,omitempty" yaml:"header,omitempty"`
+	Body   string      `json:"body,omitempty" yaml:"body,omitempty"`
+}
+
+type Response struct {
+	StatusCode int         `json:"status_code" yaml:"status_code"`
+	Header     http.Header `json:"header,omitempty" yaml:"header,omitempty"`
+	Body       string      `json:"body,omitempty" yaml:"body,omitempty"`
+}
+
+type Interaction struct {
+	Request  Request  `json:"request" yaml:"request"`
+	Response Response `json:"response" yaml:"response"`
+}
+
+type Cassette struct {
+	Interactions []*Interaction `json:"interactions" yaml:"interactions"`
+}
+
+type Options struct {
+	Mode Mode
+	// Transport 用于发出真实请求, 默认为 http.DefaultTransport
+	Transport http.RoundTripper
+	// Matchers 全部满足时请求与记录匹配, 默认为 MatchMethod 和 MatchUrl
+	Matchers []Matcher
+	// RedactHeaders 和 RedactQuery 中的请求头和 query 参数在保存前替换为 REDACTED, 响应头同样会被脱敏
+	RedactHeaders []string
+	RedactQuery   []string
+	// Redact 在保存前自定义脱敏, 例如 body 中的密钥. 匹配时对真实请求做同样的处理
+	Redact func(interaction *Interaction)
+}
+
+// Recorder 是一个记录和回放请求的 http.RoundTripper, 可以设置到 contract.ClientConfig.Transport.
+// 文件扩展名为 .json 时使用 Json 格式, 否则使用 YAML 格式
+type Recorder struct {
+	path    string
+	options Options
+
+	mu       sync.Mutex
+	cassette *Cassette
+	// replayed 记录每个 interaction 被回放的次数
+	replayed []int
+}
+
+func New(path string, options *Options) (*Recorder, error) {
+	opts := Options{}
+	if options != nil {
+		opts = *options
+	}
+	if opts.Transport == nil {
+		opts.Transport = http.DefaultTransport
+	}
+	if opts.Matchers == nil {
+		opts.Matchers = []Matcher{MatchMethod, MatchUrl}
+	}
+
+	recorder := &Recorder{
+		path:     path,
+		options:  opts,
+		cassette: &Cassette{},
+	}
+	if opts.Mode == ModeRecord {
+		return recorder, nil
+	}
+
+	err := recorder.load()
+	if err != nil && !(os.IsNotExist(err) && opts.Mode == ModeReplayOrRecord) {
+		return nil, err
+	}
+	return recorder, nil
+}
+
+// Interactions 返回 cassette 中的记录
+func (r *Recorder) Interactions() []*Interaction {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return append([]*Interaction{}, r.cassette.Interactions...)
+}
+
+func (r *Recorder) RoundTrip(request *http.Request) (*http.Response, error) {
+	body, replay, err := readRequestBody(request)
+	if err != nil {
+		return nil, err
+	}
+	recorded := r.newRequest(request, body)
+
+	if r.options.Mode != ModeRecord {
+		if interaction := r.match(recorded); interaction != nil {
+			return interaction.Response.toHttpResponse(request), nil
+		}
+		if r.options.Mode == ModeReplay {
+			return nil, errors.Wrapf(ErrInteractionNotFound, "%s %s", recorded.Method, recorded.Url)
+		}
+	}
+
+	return r.record(replay, recorded)
+}
+
+// match 按记录的顺序返回第一个未回放过的匹配记录, 都回放过时返回最后一个匹配的记录
+func (r *Recorder) match(request *Request) *Interaction {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	last := -1
+	for i, interaction := range r.cassette.Interactions {
+		if !r.matches(request, &interaction.Request) {
+			continue
+		}
+		if r.replayed[i] == 0 {
+			r.replayed[i]++
+			return interaction
+		}
+		last = i
+	}
+	if last < 0 {
+		return nil
+	}
+	r.replayed[last]++
+	return r.cassette.Interactions[last]
+}
+
+func (r *Recorder) matches(request *Request, recorded *Request) bool {
+	for _, matcher := range r.options.Matchers {
+		if !matcher(request, recorded) {
+			return false
+		}
+	}
+	return true
+}
+
+// record 发出真实请求, 脱敏后追加到 cassette 并保存
+func (r *Recorder) record(request *http.Request, recorded *Request) (*http.Response, error) {
+	response, err := r.options.Transport.RoundTrip(request)
+	if err != nil {
+		return nil, err
+	}
+	body, err := io.ReadAll(response.Body)
+	_ = response.Body.Close()
+	if err != nil {
+		return nil, errors.Wrap(err, "cassette: read response failed")
+	}
+	response.Body = io.NopCloser(bytes.NewReader(body))
+
+	interaction := &Interaction{
+		Request: *recorded,
+		Response: Response{
+			StatusCode: response.StatusCode,
+			Header:     r.redactHeader(response.Header),
+			Body:       string(body),
+		},
+	}
+	if r.options.Redact != nil {
+		r.options.Redact(interaction)
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.cassette.Interactions = append(r.cassette.Interactions, interaction)
+	r.replayed = append(r.replayed, 1)
+	return response, r.save()
+}
+
+// newRequest 转换为脱敏后的记录, 录制和匹配使用相同的转换
+func (r *Recorder) newRequest(request *http.Request, body []byte) *Request {
+	u := *request.URL
+	if len(r.options.RedactQuery) > 0 {
+		query := u.Query()
+		for _, key := range r.options.RedactQuery {
+			if query.Has(key) {
+				query.Set(key, REDACTED)
+			}
+		}
+		u.RawQuery = query.Encode()
+	}
+
+	recorded := &Request{
+		Method: request.Method,
+		Url:    u.String(),
+		Header: r.redactHeader(request.Header),
+		Body:   string(body),
+	}
+	if r.options.Redact != nil {
+		interaction := &Interaction{Request: *recorded}
+		r.options.Redact(interaction)
+		recorded = &interaction.Request
+	}
+	return recorded
+}
+
+func (r *Recorder) redactHeader(header http.Header) http.Header {
+	header = header.Clone()
+	for _, key := range r.options.RedactHeaders {
+		if header.Get(key) != "" {
+			header.Set(key, REDACTED)
+		}
+	}
+	return header
+}
+
+func (r *Recorder) isJson() bool {
+	return strings.EqualFold(filepath.Ext(r.path), ".json")
+}
+
+func (r *Recorder) load() error {
+	b, err := os.ReadFile(r.path)
+	if err != nil {
+		return err
+	}
+
+	cassette := &Cassette{}
+	if r.isJson() {
+		err = json.Unmarshal(b, cassette)
+	} else {
+		err = yaml.Unmarshal(b, cassette)
+	}
+	if err != nil {
+		return errors.Wrapf(err, "cassette: parse %s failed", r.path)
+	}
+
+	r.cassette = cassette
+	r.replayed = make([]int, len(cassette.Interactions))
+	return nil
+}
+
+// save 写入整个 cassette, 调用方持有 mu
+func (r *Recorder) save() error {
+	var b []byte
+	var err error
+	if r.isJson() {
+		b, err = json.MarshalIndent(r.cassette, "", "  ")
+	} else {
+		b, err = yaml.Marshal(r.cassette)
+	}
+	if err != nil {
+		return errors.Wrap(err, "cassette: encode failed")
+	}
+
+	err = os.MkdirAll(filepath.Dir(r.path), 0755)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(r.path, b, 0644)
+}
+
+func (response *Response) toHttpResponse(request *http.Request) *http.Response {
+	header := response.Header.Clone()
+	if header == nil {
+		header = http.Header{}
+	}
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", response.StatusCode, http.StatusText(response.StatusCode)),
+		StatusCode:    response.StatusCode,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        header,
+		Body:          io.NopCloser(strings.NewReader(response.Body)),
+		ContentLength: int64(len(response.Body)),
+		Request:       request,
+	}
+}
+
+// readRequestBody 读取并关闭请求的 body, 返回带有同样 body 的请求副本用于真实请求.
+// RoundTripper 不能修改调用方的请求, 所以不重置 request.Body
+func readRequestBody(request *http.Request) ([]byte, *http.Request, error) {
+	if request.Body == nil || request.Body == http.NoBody {
+		return nil, request, nil
+	}
+	body, err := io.ReadAll(request.Body)
+	_ = request.Body.Close()
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "cassette: read request failed")
+	}
+
+	replay := request.Clone(request.Context())
+	replay.Body = io.NopCloser(bytes.NewReader(body))
+	replay.GetBody = func() (io.ReadCloser, error) {
+		return io.NopCloser(bytes.NewReader(body)), nil
+	}
+	return body, replay, nil
+}
+
+// Matcher 判断请求与记录是否匹配, 两者都已经过脱敏
+type Matcher func(request *Request, recorded *Request) bool
+
+func MatchMethod(request *Request, recorded *Request) bool {
+	return request.Method == recorded.Method
+}
+
+// MatchUrl 比较完整的 Url, query 参数的顺序不影响匹配
+func MatchUrl(request *Request, recorded *Request) bool {
+	return normalizeUrl(request.Url) == normalizeUrl(recorded.Url)
+}
+
+// MatchBody 比较 body, 都是 Json 时按内容比较, 忽略字段顺序和空白
+func MatchBody(request *Request, recorded *Request) bool {
+	if request.Body == recorded.Body {
+		return true
+	}
+	var a, b interface{}
+	if json.Unmarshal([]byte(request.Body), &a) != nil || json.Unmarshal([]byte(recorded.Body), &b) != nil {
+		return false
+	}
+	ja, _ := json.Marshal(a)
+	jb, _ := json.Marshal(b)
+	return bytes.Equal(ja, jb)
+}
+
+// MatchHeaders 返回比较指定请求头的 Matcher
+func MatchHeaders(keys ...string) Matcher {
+	return func(request *Request, recorded *Request) bool {
+		for _, key := range keys {
+			if request.Header.Get(key) != recorded.Header.Get(key) {
+				return false
+			}
+		}
+		return true
+	}
+}
+
+func normalizeUrl(rawUrl string) string {
+	u, err := url.Parse(rawUrl)
+	if err != nil {
+		return rawUrl
+	}
+	u.RawQuery = u.Query().Encode()
+	return u.String()
+}
@@ -0,0 +1,175 @@
+package cassette
+
+import (
+	"errors"
+	"github.com/dadiYazZ/xin-da-libs/http/contract"
+	"github.com/dadiYazZ/xin-da-libs/http/helper"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+func newTestHelper(t *testing.T, baseUrl string, recorder *Recorder) *helper.RequestHelper {
+	requestHelper, err := helper.NewRequestHelper(&helper.Config{
+		BaseUrl: baseUrl,
+		ClientConfig: &contract.ClientConfig{
+			Transport: recorder,
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return requestHelper
+}
+
+func TestRecorder_RecordReplay(t *testing.T) {
+	for _, name := range []string{"cassette.yaml", "cassette.json"} {
+		t.Run(name, func(t *testing.T) {
+			var calls int32
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				atomic.AddInt32(&calls, 1)
+				body, _ := io.ReadAll(r.Body)
+				w.Header().Set("Content-Type", "application/json")
+				w.Header().Set("Set-Cookie", "session=secret")
+				_, _ = w.Write([]byte(`{"path":"` + r.URL.Path + `","body":` + string(body) + `}`))
+			}))
+			path := filepath.Join(t.TempDir(), "fixtures", name)
+			options := &Options{
+				Matchers:      []Matcher{MatchMethod, MatchUrl, MatchBody},
+				RedactHeaders: []string{"Authorization", "Set-Cookie"},
+				RedactQuery:   []string{"access_token"},
+			}
+
+			// record
+			options.Mode = ModeRecord
+			recorder, err := New(path, options)
+			assert.NoError(t, err)
+			requestHelper := newTestHelper(t, server.URL, recorder)
+			result := map[string]interface{}{}
+			err = requestHelper.Df().Method(http.MethodPost).Uri("/orders?access_token=live-token").
+				Header("Authorization", "Bearer live-token").
+				Json(map[string]int{"amount": 100}).Result(&result)
+			assert.NoError(t, err)
+			assert.Equal(t, "/orders", result["path"])
+			server.Close()
+
+			b, err := os.ReadFile(path)
+			assert.NoError(t, err)
+			assert.NotContains(t, string(b), "live-token")
+			assert.NotContains(t, string(b), "session=secret")
+
+			// replay offline, with another token
+			options.Mode = ModeReplay
+			recorder, err = New(path, options)
+			assert.NoError(t, err)
+			requestHelper = newTestHelper(t, server.URL, recorder)
+			result = map[string]interface{}{}
+			err = requestHelper.Df().Method(http.MethodPost).Uri("/orders?access_token=other-token").
+				Json(map[string]int{"amount": 100}).Result(&result)
+			assert.NoError(t, err)
+			assert.Equal(t, "/orders", result["path"])
+			assert.Equal(t, map[string]interface{}{"amount": float64(100)}, result["body"])
+			assert.Equal(t, int32(1), atomic.LoadInt32(&calls))
+
+			// another body does not match
+			_, err = requestHelper.Df().Method(http.MethodPost).Uri("/orders?access_token=other-token").
+				Json(map[string]int{"amount": 200}).Request()
+			assert.True(t, errors.Is(err, ErrInteractionNotFound))
+		})
+	}
+}
+
+func TestRecorder_ReplayOrRecord(t *testing.T) {
+	var calls int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := atomic.AddInt32(&calls, 1)
+		_, _ = w.Write([]byte(strings.Repeat("x", int(n))))
+	}))
+	defer server.Close()
+
+	path := filepath.Join(t.TempDir(), "cassette.yaml")
+	recorder, err := New(path, &Options{Mode: ModeReplayOrRecord})
+	assert.NoError(t, err)
+
+	request, _ := http.NewRequest(http.MethodGet, server.URL+"/a", nil)
+	response, err := recorder.RoundTrip(request)
+	assert.NoError(t, err)
+	body, _ := io.ReadAll(response.Body)
+	assert.Equal(t, "x", string(body))
+
+	// the recorded interaction is replayed
+	response, err = recorder.RoundTrip(request)
+	assert.NoError(t, err)
+	body, _ = io.ReadAll(response.Body)
+	assert.Equal(t, "x", string(body))
+	assert.Equal(t, int32(1), atomic.LoadInt32(&calls))
+	assert.Len(t, recorder.Interactions(), 1)
+
+	request, _ = http.NewRequest(http.MethodGet, server.URL+"/b", nil)
+	_, err = recorder.RoundTrip(request)
+	assert.NoError(t, err)
+	assert.Equal(t, int32(2), atomic.LoadInt32(&calls))
+	assert.Len(t, recorder.Interactions(), 2)
+}
+
+func TestRecorder_ReplayInOrder(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cassette.yaml")
+	err := os.WriteFile(path, []byte(`interactions:
+  - request:
+      method: GET
+      url: https://example.com/status
+    response:
+      status_code: 202
+      body: pending
+  - request:
+      method: GET
+      url: https://example.com/status
+    response:
+      status_code: 200
+      body: done
+`), 0644)
+	assert.NoError(t, err)
+
+	recorder, err := New(path, nil)
+	assert.NoError(t, err)
+
+	for _, expected := range []string{"pending", "done", "done"} {
+		request, _ := http.NewRequest(http.MethodGet, "https://example.com/status", nil)
+		response, err := recorder.RoundTrip(request)
+		assert.NoError(t, err)
+		body, _ := io.ReadAll(response.Body)
+		assert.Equal(t, expected, string(body))
+	}
+
+	_, err = New(filepath.Join(t.TempDir(), "missing.yaml"), nil)
+	assert.Error(t, err)
+}
+
+func TestRecorder_RequestUntouched(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		_, _ = w.Write(body)
+	}))
+	defer server.Close()
+
+	recorder, err := New(filepath.Join(t.TempDir(), "cassette.yaml"), &Options{Mode: ModeRecord})
+	assert.NoError(t, err)
+
+	body := io.NopCloser(strings.NewReader("payload"))
+	request, _ := http.NewRequest(http.MethodPost, server.URL, nil)
+	request.Body = body
+	response, err := recorder.RoundTrip(request)
+	assert.NoError(t, err)
+	b, _ := io.ReadAll(response.Body)
+	assert.Equal(t, "payload", string(b))
+
+	// the RoundTripper must not modify the request of the caller
+	assert.Equal(t, body, request.Body)
+	assert.Nil(t, request.GetBody)
+}
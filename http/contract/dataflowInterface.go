@@ -5,6 +5,9 @@ import (
 	"io"
 	"net/http"
 	"net/textproto"
+	"net/url"
+
+	"github.com/dadiYazZ/xin-da-libs/object"
 )
 
 // RequestDataflowInterface 是一个 Http 请求构建器, 建议将注释中的私有方法实现到内部
@@ -22,17 +25,28 @@ type RequestDataflowInterface interface {
 	Query(key string, values ...string) RequestDataflowInterface
 
 	Json(jsonAny interface{}) RequestDataflowInterface
+	Form(data object.StringMap) RequestDataflowInterface
+	FormValues(values url.Values) RequestDataflowInterface
+	Bytes(b []byte) RequestDataflowInterface
 	Body(body io.Reader) RequestDataflowInterface
 	Any(data BodyEncoder) RequestDataflowInterface
 	Xml(xmlAny interface{}) RequestDataflowInterface
 	Multipart(multipartDf func(multipart MultipartDfInterface)) RequestDataflowInterface
+	// StreamMultipart 与 Multipart 相同, 但 part 在发送时才被读取, 适合上传大文件
+	StreamMultipart(multipartDf func(multipart MultipartDfInterface)) RequestDataflowInterface
 
 	Err() error
+	// GetRequest 返回构建中的请求, 用于在发送前读取 Url 和 Context
+	GetRequest() *http.Request
 
 	// 在发送前应该检查错误
 	// validateRequest() error
 
+	// ErrorResult 注册非 2xx 状态码对应的错误结构, Result 会将响应解码到其中
+	ErrorResult(statusCode int, errResult interface{}) RequestDataflowInterface
+
 	Request() (response *http.Response, err error)
+	// Result 按 Content-Type 解码响应, 非 2xx 响应返回 HttpError
 	Result(result interface{}) (err error)
 	RequestResHelper() (response ResponseHelper, err error)
 }
@@ -56,9 +70,19 @@ type MultipartDfInterface interface {
 	Part(header textproto.MIMEHeader, reader io.Reader) MultipartDfInterface
 	FieldValue(fieldName string, value string) MultipartDfInterface
 	Field(fieldName string, reader io.Reader) MultipartDfInterface
+	// Progress 设置发送进度回调, total 为 -1 表示总长度未知
+	Progress(progress func(written int64, total int64)) MultipartDfInterface
 	Close() error
 	GetBoundary() string
 	GetReader() io.Reader
 	GetContentType() string
 	Err() error
 }
+
+// MultipartBodyInterface 由可以预先计算长度和重放 body 的 MultipartDfInterface 实现
+type MultipartBodyInterface interface {
+	// ContentLength 返回 body 的总长度, 无法计算时返回 -1
+	ContentLength() int64
+	// GetBody 返回重建 body 的方法, 无法重放时返回 nil
+	GetBody() func() (io.ReadCloser, error)
+}
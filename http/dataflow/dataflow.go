@@ -12,6 +12,7 @@ import (
 	"strings"
 
 	"github.com/dadiYazZ/xin-da-libs/http/contract"
+	"github.com/dadiYazZ/xin-da-libs/object"
 	"github.com/pkg/errors"
 )
 
@@ -20,6 +21,7 @@ type Dataflow struct {
 	middlewareHandle contract.RequestMiddleware
 	request          *http.Request
 	option           *Option
+	errorResults     map[int]interface{}
 	err              []error
 }
 
@@ -162,6 +164,32 @@ func (d *Dataflow) Json(jsonAny interface{}) contract.RequestDataflowInterface {
 	return d
 }
 
+// Form 以 application/x-www-form-urlencoded 编码 data, key 按字典序排列, 相同的参数总是得到相同的 body
+func (d *Dataflow) Form(data object.StringMap) contract.RequestDataflowInterface {
+	values := url.Values{}
+	for key, value := range data {
+		values.Set(key, value)
+	}
+	return d.FormValues(values)
+}
+
+// FormValues 与 Form 相同, 支持一个 key 对应多个值
+func (d *Dataflow) FormValues(values url.Values) contract.RequestDataflowInterface {
+	d.Header("content-type", "application/x-www-form-urlencoded")
+	d.Body(strings.NewReader(values.Encode()))
+	return d
+}
+
+// Bytes 直接发送 b, 没有设置 content-type 时使用 application/octet-stream
+func (d *Dataflow) Bytes(b []byte) contract.RequestDataflowInterface {
+	d.makeHeaderIfNil()
+	if d.request.Header.Get("content-type") == "" {
+		d.Header("content-type", "application/octet-stream")
+	}
+	d.Body(bytes.NewReader(b))
+	return d
+}
+
 func (d *Dataflow) Body(body io.Reader) contract.RequestDataflowInterface {
 	if body != nil {
 		d.request.Body = io.NopCloser(body)
@@ -223,7 +251,14 @@ func (d *Dataflow) Xml(xmlAny interface{}) contract.RequestDataflowInterface {
 }
 
 func (d *Dataflow) Multipart(multipartDf func(multipart contract.MultipartDfInterface)) contract.RequestDataflowInterface {
-	multipart := NewMultipartHelper()
+	return d.multipart(NewMultipartHelper(), multipartDf)
+}
+
+func (d *Dataflow) StreamMultipart(multipartDf func(multipart contract.MultipartDfInterface)) contract.RequestDataflowInterface {
+	return d.multipart(NewStreamMultipartHelper(), multipartDf)
+}
+
+func (d *Dataflow) multipart(multipart contract.MultipartDfInterface, multipartDf func(multipart contract.MultipartDfInterface)) contract.RequestDataflowInterface {
 	multipartDf(multipart)
 	err := multipart.Close()
 	if err != nil {
@@ -236,7 +271,25 @@ func (d *Dataflow) Multipart(multipartDf func(multipart contract.MultipartDfInte
 		return d
 	}
 	d.Header("content-type", multipart.GetContentType())
-	d.Body(multipart.GetReader())
+
+	body, ok := multipart.(contract.MultipartBodyInterface)
+	if !ok {
+		d.Body(multipart.GetReader())
+		return d
+	}
+
+	reader := multipart.GetReader()
+	if closer, ok := reader.(io.ReadCloser); ok {
+		d.request.Body = closer
+	} else {
+		d.request.Body = io.NopCloser(reader)
+	}
+	// ContentLength 为 0 时按未知长度分块发送
+	d.request.ContentLength = 0
+	if length := body.ContentLength(); length > 0 {
+		d.request.ContentLength = length
+	}
+	d.request.GetBody = body.GetBody()
 	return d
 }
 
@@ -247,6 +300,10 @@ func (d *Dataflow) Err() error {
 	return nil
 }
 
+func (d *Dataflow) GetRequest() *http.Request {
+	return d.request
+}
+
 func (d *Dataflow) Request() (response *http.Response, err error) {
 	if d.Err() != nil {
 		return nil, d.Err()
@@ -265,7 +322,7 @@ func (d *Dataflow) Request() (response *http.Response, err error) {
 	return resp, nil
 }
 
-// Result 实现了 Json 解码
+// Result 按响应的 Content-Type 解码到 result, 支持 Json, Xml 和表单, 非 2xx 响应返回 *HttpError
 func (d *Dataflow) Result(result interface{}) (err error) {
 	if result == nil {
 		return errors.New("nil result")
@@ -280,13 +337,21 @@ func (d *Dataflow) Result(result interface{}) (err error) {
 		return err
 	}
 
-	// decode 不支持 array
-	decoder := json.NewDecoder(resp.Body)
-	err = decoder.Decode(result)
-	if err != nil {
-		return errors.Wrap(err, "decode response failed")
+	return d.decodeResponse(resp, result)
+}
+
+// ErrorResult 注册状态码对应的错误结构, 该状态码的响应会解码到 errResult 中, 作为 HttpError.Detail 返回.
+// statusCode 为 0 时匹配其它所有非 2xx 状态码
+func (d *Dataflow) ErrorResult(statusCode int, errResult interface{}) contract.RequestDataflowInterface {
+	if errResult == nil || reflect.ValueOf(errResult).Kind() != reflect.Ptr {
+		d.err = append(d.err, errors.New("error result is not pointer"))
+		return d
 	}
-	return nil
+	if d.errorResults == nil {
+		d.errorResults = map[int]interface{}{}
+	}
+	d.errorResults[statusCode] = errResult
+	return d
 }
 
 type Response struct {
@@ -7,9 +7,12 @@ import (
 	"errors"
 	"github.com/dadiYazZ/xin-da-libs/http/contract"
 	"github.com/dadiYazZ/xin-da-libs/http/drivers/http"
+	"github.com/dadiYazZ/xin-da-libs/object"
+	"github.com/stretchr/testify/assert"
 	"io"
 	"log"
 	http2 "net/http"
+	"net/http/httptest"
 	"strings"
 	"testing"
 	"time"
@@ -140,3 +143,160 @@ func TestDataflow_Multipart(t *testing.T) {
 		t.Error(df.Err())
 	}
 }
+
+type CaseApiError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e *CaseApiError) Error() string {
+	return e.Message
+}
+
+func newTestDataflow(t *testing.T, handler http2.HandlerFunc) *Dataflow {
+	server := httptest.NewServer(handler)
+	t.Cleanup(server.Close)
+
+	client, err := http.NewHttpClient(&contract.ClientConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return NewDataflow(client, nil, &Option{
+		BaseUrl: server.URL,
+	})
+}
+
+func TestDataflow_ResultContentType(t *testing.T) {
+	cases := map[string]struct {
+		contentType string
+		body        string
+	}{
+		"json": {"application/json; charset=utf-8", `{"a":"1","b":"2"}`},
+		"xml":  {"text/xml", `<xml><a>1</a><b>2</b></xml>`},
+		"form": {"application/x-www-form-urlencoded", `a=1&b=2`},
+	}
+
+	for name, c := range cases {
+		t.Run(name, func(t *testing.T) {
+			df := newTestDataflow(t, func(w http2.ResponseWriter, r *http2.Request) {
+				w.Header().Set("Content-Type", c.contentType)
+				_, _ = w.Write([]byte(c.body))
+			})
+
+			result := object.HashMap{}
+			err := df.Method(http2.MethodGet).Result(&result)
+			assert.NoError(t, err)
+			assert.Equal(t, "1", result["a"])
+			assert.Equal(t, "2", result["b"])
+		})
+	}
+}
+
+func TestDataflow_ResultXmlStruct(t *testing.T) {
+	df := newTestDataflow(t, func(w http2.ResponseWriter, r *http2.Request) {
+		w.Header().Set("Content-Type", "application/xml")
+		_, _ = w.Write([]byte(`<doc><node1><a>1</a><b>1</b><b>2</b></node1></doc>`))
+	})
+
+	result := CaseXmlDoc{}
+	err := df.Method(http2.MethodGet).Result(&result)
+	assert.NoError(t, err)
+	assert.Equal(t, CaseXmlNode{A: "1", B: []string{"1", "2"}}, result.Node1)
+}
+
+func TestDataflow_ResultHttpError(t *testing.T) {
+	body := "<html>" + strings.Repeat("x", DEFAULT_ERROR_BODY_LIMIT) + "</html>"
+	df := newTestDataflow(t, func(w http2.ResponseWriter, r *http2.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		w.WriteHeader(http2.StatusInternalServerError)
+		_, _ = w.Write([]byte(body))
+	})
+
+	result := object.HashMap{}
+	err := df.Method(http2.MethodGet).Result(&result)
+
+	var httpErr *HttpError
+	assert.True(t, errors.As(err, &httpErr))
+	assert.Equal(t, http2.StatusInternalServerError, httpErr.StatusCode)
+	assert.Equal(t, "text/html", httpErr.Header.Get("Content-Type"))
+	assert.Equal(t, body[:DEFAULT_ERROR_BODY_LIMIT], string(httpErr.Body))
+	assert.True(t, httpErr.Truncated)
+	assert.Nil(t, httpErr.Detail)
+}
+
+func TestNewHttpError_LimitsBody(t *testing.T) {
+	// a huge error body is not read beyond the limit
+	body := &countingReader{Reader: strings.NewReader(strings.Repeat("x", 10*DEFAULT_ERROR_BODY_LIMIT))}
+	httpErr := NewHttpError(nil, &http2.Response{StatusCode: http2.StatusBadGateway, Body: io.NopCloser(body)}, nil)
+	assert.True(t, httpErr.Truncated)
+	assert.Len(t, httpErr.Body, DEFAULT_ERROR_BODY_LIMIT)
+	assert.Equal(t, DEFAULT_ERROR_BODY_LIMIT+1, body.read)
+}
+
+type countingReader struct {
+	io.Reader
+	read int
+}
+
+func (r *countingReader) Read(p []byte) (int, error) {
+	n, err := r.Reader.Read(p)
+	r.read += n
+	return n, err
+}
+
+func TestDataflow_ErrorResult(t *testing.T) {
+	df := newTestDataflow(t, func(w http2.ResponseWriter, r *http2.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http2.StatusNotFound)
+		_, _ = w.Write([]byte(`{"code":40401,"message":"order not found"}`))
+	})
+
+	notFound := &CaseApiError{}
+	other := &CaseApiError{}
+	result := object.HashMap{}
+	err := df.Method(http2.MethodGet).
+		ErrorResult(http2.StatusNotFound, notFound).
+		ErrorResult(0, other).
+		Result(&result)
+
+	var httpErr *HttpError
+	assert.True(t, errors.As(err, &httpErr))
+	assert.Equal(t, notFound, httpErr.Detail)
+	assert.Equal(t, 40401, notFound.Code)
+	assert.Equal(t, 0, other.Code)
+
+	var apiErr *CaseApiError
+	assert.True(t, errors.As(err, &apiErr))
+	assert.Equal(t, "order not found", apiErr.Message)
+}
+
+func TestDataflow_Form(t *testing.T) {
+	df := InitBaseDataflow()
+
+	df.Form(object.StringMap{
+		"sign":      "abc",
+		"app_id":    "10001",
+		"timestamp": "1700000000",
+		"content":   "a b&c",
+	})
+
+	bodyBytes, _ := io.ReadAll(df.request.Body)
+	assert.Equal(t, "app_id=10001&content=a+b%26c&sign=abc&timestamp=1700000000", string(bodyBytes))
+	assert.Equal(t, "application/x-www-form-urlencoded", df.request.Header.Get("content-type"))
+	assert.Equal(t, int64(len(bodyBytes)), df.request.ContentLength)
+}
+
+func TestDataflow_Bytes(t *testing.T) {
+	df := InitBaseDataflow()
+
+	df.Bytes([]byte("raw"))
+
+	bodyBytes, _ := io.ReadAll(df.request.Body)
+	assert.Equal(t, "raw", string(bodyBytes))
+	assert.Equal(t, "application/octet-stream", df.request.Header.Get("content-type"))
+
+	replay, err := df.request.GetBody()
+	assert.NoError(t, err)
+	bodyBytes, _ = io.ReadAll(replay)
+	assert.Equal(t, "raw", string(bodyBytes))
+}
@@ -0,0 +1,340 @@
+package dataflow
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/textproto"
+	"os"
+	"path"
+	"strings"
+	"sync"
+
+	"github.com/dadiYazZ/xin-da-libs/http/contract"
+	"github.com/pkg/errors"
+)
+
+var quoteEscaper = strings.NewReplacer("\\", "\\\\", `"`, "\\\"")
+
+// ErrPartSizeChanged part 的长度与计算 ContentLength 时不同, 例如文件在添加后被修改
+var ErrPartSizeChanged = errors.New("multipart part size changed")
+
+// StreamMultipartDf 是流式的 multipart 构建器, 各个 part 只在发送时才被读取并通过 io.Pipe 写入请求.
+// 所有 part 的长度都已知时会计算出 ContentLength, 所有 part 都可以重新读取时(文件路径, 字段值,
+// bytes 和 strings 的 reader) 支持 GetBody, 使请求可以被重试
+type StreamMultipartDf struct {
+	boundary string
+	parts    []*streamPart
+	progress func(written int64, total int64)
+	closed   bool
+	errs     []error
+}
+
+type streamPart struct {
+	header textproto.MIMEHeader
+	// size 为 -1 表示长度未知
+	size int64
+	// open 返回 part 的内容, 不可重放的 part 只能 open 一次
+	open       func() (io.ReadCloser, error)
+	replayable bool
+}
+
+func NewStreamMultipartHelper() contract.MultipartDfInterface {
+	return &StreamMultipartDf{
+		boundary: multipart.NewWriter(io.Discard).Boundary(),
+	}
+}
+
+func (m *StreamMultipartDf) addPart(part *streamPart) {
+	if m.closed {
+		m.errs = append(m.errs, errors.New("multipart is closed"))
+		return
+	}
+	m.parts = append(m.parts, part)
+}
+
+func (m *StreamMultipartDf) Boundary(b string) contract.MultipartDfInterface {
+	err := multipart.NewWriter(io.Discard).SetBoundary(b)
+	if err != nil {
+		m.errs = append(m.errs, errors.Wrap(err, "set boundary failed"))
+		return m
+	}
+	m.boundary = b
+	return m
+}
+
+// FileByPath 只读取文件的大小, 文件内容在发送时读取, 每次重试都会重新打开文件.
+// 发送时文件的长度与添加时不同会以 ErrPartSizeChanged 中止请求, 不会发出与 ContentLength 不符的 body
+func (m *StreamMultipartDf) FileByPath(fieldName string, filePath string) contract.MultipartDfInterface {
+	info, err := os.Stat(filePath)
+	if err != nil {
+		m.errs = append(m.errs, errors.Wrap(err, "create file part failed"))
+		return m
+	}
+	_, fileName := path.Split(filePath)
+
+	m.addPart(&streamPart{
+		header: fileHeader(fieldName, fileName),
+		size:   info.Size(),
+		open: func() (io.ReadCloser, error) {
+			return os.Open(filePath)
+		},
+		replayable: true,
+	})
+	return m
+}
+
+func (m *StreamMultipartDf) FileMem(fieldName string, fileName string, reader io.Reader) contract.MultipartDfInterface {
+	m.addPart(readerPart(fileHeader(fieldName, fileName), reader))
+	return m
+}
+
+func (m *StreamMultipartDf) Part(header textproto.MIMEHeader, reader io.Reader) contract.MultipartDfInterface {
+	m.addPart(readerPart(header, reader))
+	return m
+}
+
+func (m *StreamMultipartDf) FieldValue(fieldName string, value string) contract.MultipartDfInterface {
+	m.addPart(readerPart(fieldHeader(fieldName), strings.NewReader(value)))
+	return m
+}
+
+func (m *StreamMultipartDf) Field(fieldName string, reader io.Reader) contract.MultipartDfInterface {
+	m.addPart(readerPart(fieldHeader(fieldName), reader))
+	return m
+}
+
+// Progress 设置发送进度回调, total 为 -1 表示总长度未知
+func (m *StreamMultipartDf) Progress(progress func(written int64, total int64)) contract.MultipartDfInterface {
+	m.progress = progress
+	return m
+}
+
+// Close 结束 part 的添加, 结尾的 boundary 在发送时写入
+func (m *StreamMultipartDf) Close() error {
+	m.closed = true
+	return nil
+}
+
+func (m *StreamMultipartDf) GetBoundary() string {
+	return m.boundary
+}
+
+// GetReader 返回一个新的 body, 第一次 Read 时才开始读取各个 part, 返回值同时实现了 io.Closer
+func (m *StreamMultipartDf) GetReader() io.Reader {
+	return m.newBody()
+}
+
+func (m *StreamMultipartDf) GetContentType() string {
+	writer := multipart.NewWriter(io.Discard)
+	_ = writer.SetBoundary(m.boundary)
+	return writer.FormDataContentType()
+}
+
+func (m *StreamMultipartDf) Err() error {
+	if len(m.errs) == 0 {
+		return nil
+	}
+	return m.errs[0]
+}
+
+// ContentLength 根据 part 的长度计算 body 的总长度, 有长度未知的 part 时返回 -1
+func (m *StreamMultipartDf) ContentLength() int64 {
+	counter := &countWriter{}
+	writer := multipart.NewWriter(counter)
+	_ = writer.SetBoundary(m.boundary)
+
+	var length int64
+	for _, part := range m.parts {
+		if part.size < 0 {
+			return -1
+		}
+		length += part.size
+		_, _ = writer.CreatePart(part.header)
+	}
+	_ = writer.Close()
+
+	return length + counter.n
+}
+
+// GetBody 在所有 part 都可以重新读取时返回重建 body 的方法, 否则返回 nil
+func (m *StreamMultipartDf) GetBody() func() (io.ReadCloser, error) {
+	for _, part := range m.parts {
+		if !part.replayable {
+			return nil
+		}
+	}
+	return func() (io.ReadCloser, error) {
+		return m.newBody(), nil
+	}
+}
+
+func (m *StreamMultipartDf) newBody() io.ReadCloser {
+	pr, pw := io.Pipe()
+	return &progressReader{
+		reader: &streamBody{
+			pr:    pr,
+			start: func() { go m.write(pw) },
+		},
+		total:    m.ContentLength(),
+		progress: m.progress,
+	}
+}
+
+// write 依次写入各个 part, 读取端关闭后写入会失败并退出
+func (m *StreamMultipartDf) write(pw *io.PipeWriter) {
+	writer := multipart.NewWriter(pw)
+	err := writer.SetBoundary(m.boundary)
+
+	for _, part := range m.parts {
+		if err != nil {
+			break
+		}
+		err = writePart(writer, part)
+	}
+	if err == nil {
+		err = writer.Close()
+	}
+
+	_ = pw.CloseWithError(err)
+}
+
+func writePart(writer *multipart.Writer, part *streamPart) error {
+	w, err := writer.CreatePart(part.header)
+	if err != nil {
+		return errors.Wrap(err, "create part failed")
+	}
+	reader, err := part.open()
+	if err != nil {
+		return errors.Wrap(err, "open part failed")
+	}
+	defer reader.Close()
+
+	if part.size < 0 {
+		_, err = io.Copy(w, reader)
+		if err != nil {
+			return errors.Wrap(err, "write part failed")
+		}
+		return nil
+	}
+
+	// 长度已知的 part 计入了 ContentLength, 只写入 size 字节并检查内容没有变短或变长
+	_, err = io.CopyN(w, reader, part.size)
+	if err == io.EOF {
+		return errors.Wrapf(ErrPartSizeChanged, "less than %d bytes", part.size)
+	}
+	if err != nil {
+		return errors.Wrap(err, "write part failed")
+	}
+	n, _ := reader.Read(make([]byte, 1))
+	if n > 0 {
+		return errors.Wrapf(ErrPartSizeChanged, "more than %d bytes", part.size)
+	}
+	return nil
+}
+
+// readerPart 为 reader 创建 part, bytes 和 strings 的 reader 长度已知且可以重放, 其它 reader 只能读取一次
+func readerPart(header textproto.MIMEHeader, reader io.Reader) *streamPart {
+	var content []byte
+	known := true
+	switch v := reader.(type) {
+	case nil:
+	case *bytes.Buffer:
+		content = v.Bytes()
+	case *bytes.Reader:
+		snapshot := *v
+		content, _ = io.ReadAll(&snapshot)
+	case *strings.Reader:
+		snapshot := *v
+		content, _ = io.ReadAll(&snapshot)
+	default:
+		known = false
+	}
+	if known {
+		return &streamPart{
+			header: header,
+			size:   int64(len(content)),
+			open: func() (io.ReadCloser, error) {
+				return io.NopCloser(bytes.NewReader(content)), nil
+			},
+			replayable: true,
+		}
+	}
+
+	once := sync.Once{}
+	return &streamPart{
+		header: header,
+		size:   -1,
+		open: func() (body io.ReadCloser, err error) {
+			err = errors.New("part reader was consumed already")
+			once.Do(func() {
+				body, err = io.NopCloser(reader), nil
+			})
+			return body, err
+		},
+	}
+}
+
+func fileHeader(fieldName string, fileName string) textproto.MIMEHeader {
+	header := make(textproto.MIMEHeader)
+	header.Set("Content-Disposition", fmt.Sprintf(`form-data; name="%s"; filename="%s"`,
+		quoteEscaper.Replace(fieldName), quoteEscaper.Replace(fileName)))
+	header.Set("Content-Type", "application/octet-stream")
+	return header
+}
+
+func fieldHeader(fieldName string) textproto.MIMEHeader {
+	header := make(textproto.MIMEHeader)
+	header.Set("Content-Disposition", fmt.Sprintf(`form-data; name="%s"`, quoteEscaper.Replace(fieldName)))
+	return header
+}
+
+// streamBody 在第一次 Read 时才启动写入的 goroutine, 未读取就关闭的 body 不会打开任何文件
+type streamBody struct {
+	pr    *io.PipeReader
+	start func()
+	once  sync.Once
+}
+
+func (b *streamBody) Read(p []byte) (int, error) {
+	b.once.Do(b.start)
+	return b.pr.Read(p)
+}
+
+func (b *streamBody) Close() error {
+	return b.pr.Close()
+}
+
+// progressReader 在每次读取后回调已读取的长度
+type progressReader struct {
+	reader   io.Reader
+	written  int64
+	total    int64
+	progress func(written int64, total int64)
+}
+
+func (r *progressReader) Read(p []byte) (int, error) {
+	n, err := r.reader.Read(p)
+	if n > 0 && r.progress != nil {
+		r.written += int64(n)
+		r.progress(r.written, r.total)
+	}
+	return n, err
+}
+
+func (r *progressReader) Close() error {
+	if closer, ok := r.reader.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
+
+type countWriter struct {
+	n int64
+}
+
+func (w *countWriter) Write(p []byte) (int, error) {
+	w.n += int64(len(p))
+	return len(p), nil
+}
@@ -0,0 +1,124 @@
+package dataflow
+
+import (
+	"bytes"
+	"github.com/dadiYazZ/xin-da-libs/http/contract"
+	"github.com/stretchr/testify/assert"
+	"io"
+	http2 "net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestStreamMultipartDf_Request(t *testing.T) {
+	filePath := filepath.Join(t.TempDir(), "upload.txt")
+	assert.NoError(t, os.WriteFile(filePath, []byte(strings.Repeat("file content ", 1000)), 0644))
+
+	received := map[string]string{}
+	var contentLength int64
+	df := newTestDataflow(t, func(w http2.ResponseWriter, r *http2.Request) {
+		contentLength = r.ContentLength
+		reader, err := r.MultipartReader()
+		if !assert.NoError(t, err) {
+			return
+		}
+		for {
+			part, err := reader.NextPart()
+			if err != nil {
+				break
+			}
+			b, _ := io.ReadAll(part)
+			received[part.FormName()+"|"+part.FileName()] = string(b)
+		}
+	})
+
+	var written, total int64
+	_, err := df.Method(http2.MethodPost).StreamMultipart(func(multipart contract.MultipartDfInterface) {
+		multipart.FieldValue("param1", "value1")
+		multipart.FileByPath("file", filePath)
+		multipart.FileMem("mem", "mem.txt", bytes.NewReader([]byte("in memory")))
+		multipart.Progress(func(sent int64, size int64) {
+			written, total = sent, size
+		})
+	}).Request()
+	assert.NoError(t, err)
+
+	assert.Equal(t, map[string]string{
+		"param1|":         "value1",
+		"file|upload.txt": strings.Repeat("file content ", 1000),
+		"mem|mem.txt":     "in memory",
+	}, received)
+	assert.Greater(t, contentLength, int64(13000))
+	assert.Equal(t, contentLength, total)
+	assert.Equal(t, total, written)
+}
+
+func TestStreamMultipartDf_GetBody(t *testing.T) {
+	multipart := NewStreamMultipartHelper()
+	multipart.Boundary("test-boundary")
+	multipart.FieldValue("param1", "value1")
+	multipart.Field("data", strings.NewReader("it's a string reader"))
+	assert.NoError(t, multipart.Close())
+	assert.NoError(t, multipart.Err())
+
+	body := multipart.(contract.MultipartBodyInterface)
+	first, err := io.ReadAll(multipart.GetReader())
+	assert.NoError(t, err)
+	assert.Equal(t, int64(len(first)), body.ContentLength())
+
+	replay, err := body.GetBody()()
+	assert.NoError(t, err)
+	second, err := io.ReadAll(replay)
+	assert.NoError(t, err)
+	assert.Equal(t, first, second)
+	assert.Contains(t, string(first), "--test-boundary--")
+}
+
+func TestStreamMultipartDf_UnknownLength(t *testing.T) {
+	multipart := NewStreamMultipartHelper()
+	multipart.Field("data", io.LimitReader(strings.NewReader("streamed"), 100))
+
+	body := multipart.(contract.MultipartBodyInterface)
+	assert.Equal(t, int64(-1), body.ContentLength())
+	assert.Nil(t, body.GetBody())
+
+	b, err := io.ReadAll(multipart.GetReader())
+	assert.NoError(t, err)
+	assert.Contains(t, string(b), "streamed")
+
+	// the reader can only be read once
+	_, err = io.ReadAll(multipart.GetReader())
+	assert.Error(t, err)
+}
+
+func TestStreamMultipartDf_FileNotFound(t *testing.T) {
+	multipart := NewStreamMultipartHelper()
+	multipart.FileByPath("file", filepath.Join(t.TempDir(), "missing.txt"))
+	assert.Error(t, multipart.Err())
+}
+
+func TestStreamMultipartDf_FileChanged(t *testing.T) {
+	filePath := filepath.Join(t.TempDir(), "upload.txt")
+	assert.NoError(t, os.WriteFile(filePath, []byte("file content"), 0644))
+
+	multipart := NewStreamMultipartHelper()
+	multipart.FileByPath("file", filePath)
+	assert.NoError(t, multipart.Err())
+	body := multipart.(contract.MultipartBodyInterface)
+	length := body.ContentLength()
+
+	// a file changed after it was added fails the body instead of sending another length
+	for _, content := range []string{"short", "file content grown"} {
+		assert.NoError(t, os.WriteFile(filePath, []byte(content), 0644))
+		b, err := io.ReadAll(multipart.GetReader())
+		assert.ErrorIs(t, err, ErrPartSizeChanged, content)
+		assert.LessOrEqual(t, int64(len(b)), length)
+	}
+
+	assert.NoError(t, os.WriteFile(filePath, []byte("file content"), 0644))
+	b, err := io.ReadAll(multipart.GetReader())
+	assert.NoError(t, err)
+	assert.Equal(t, length, int64(len(b)))
+}
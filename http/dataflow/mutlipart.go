@@ -12,9 +12,10 @@ import (
 )
 
 type MultipartDf struct {
-	buf     bytes.Buffer
-	mWriter *multipart.Writer
-	errs    []error
+	buf      bytes.Buffer
+	mWriter  *multipart.Writer
+	progress func(written int64, total int64)
+	errs     []error
 }
 
 func NewMultipartHelper() contract.MultipartDfInterface {
@@ -121,6 +122,12 @@ func (m *MultipartDf) Field(fieldName string, reader io.Reader) contract.Multipa
 	return m
 }
 
+// Progress 设置发送进度回调
+func (m *MultipartDf) Progress(progress func(written int64, total int64)) contract.MultipartDfInterface {
+	m.progress = progress
+	return m
+}
+
 func (m *MultipartDf) Close() error {
 	return m.mWriter.Close()
 }
@@ -130,7 +137,21 @@ func (m *MultipartDf) GetBoundary() string {
 }
 
 func (m *MultipartDf) GetReader() io.Reader {
-	return &m.buf
+	if m.progress == nil {
+		return &m.buf
+	}
+	return &progressReader{reader: &m.buf, total: int64(m.buf.Len()), progress: m.progress}
+}
+
+func (m *MultipartDf) ContentLength() int64 {
+	return int64(m.buf.Len())
+}
+
+func (m *MultipartDf) GetBody() func() (io.ReadCloser, error) {
+	content := m.buf.Bytes()
+	return func() (io.ReadCloser, error) {
+		return &progressReader{reader: bytes.NewReader(content), total: int64(len(content)), progress: m.progress}, nil
+	}
 }
 
 func (m *MultipartDf) GetContentType() string {
@@ -0,0 +1,301 @@
+package dataflow
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"github.com/dadiYazZ/xin-da-libs/object"
+	"github.com/pkg/errors"
+)
+
+// DEFAULT_ERROR_BODY_LIMIT 是 HttpError 保留的响应 body 长度上限
+const DEFAULT_ERROR_BODY_LIMIT = 4096
+
+// HttpError 是 Result 收到非 2xx 响应时返回的错误
+type HttpError struct {
+	Method     string
+	Url        string
+	StatusCode int
+	Status     string
+	Header     http.Header
+	// Body 最多保留 DEFAULT_ERROR_BODY_LIMIT 字节, Truncated 表示是否被截断
+	Body      []byte
+	Truncated bool
+	// Detail 是通过 ErrorResult 注册的错误结构, 已经用响应 body 解码
+	Detail interface{}
+}
+
+func (e *HttpError) Error() string {
+	message := fmt.Sprintf("%s %s: unexpected status %s", e.Method, e.Url, e.Status)
+	if len(e.Body) > 0 {
+		message += ": " + string(e.Body)
+		if e.Truncated {
+			message += "..."
+		}
+	}
+	return message
+}
+
+// Unwrap 在 Detail 实现了 error 时返回它, 使 errors.As 可以直接取到业务错误结构
+func (e *HttpError) Unwrap() error {
+	if err, ok := e.Detail.(error); ok {
+		return err
+	}
+	return nil
+}
+
+// NewHttpError 读取非 2xx 响应最多 DEFAULT_ERROR_BODY_LIMIT 字节的 body, 并解码到 detail 中, detail 可以为 nil.
+// request 为 nil 时使用 response.Request
+func NewHttpError(request *http.Request, response *http.Response, detail interface{}) *HttpError {
+	httpErr := &HttpError{
+		StatusCode: response.StatusCode,
+		Status:     response.Status,
+		Header:     response.Header,
+	}
+	if request == nil {
+		request = response.Request
+	}
+	if request != nil {
+		httpErr.Method = request.Method
+		if request.URL != nil {
+			httpErr.Url = request.URL.Redacted()
+		}
+	}
+
+	// 只读取 DEFAULT_ERROR_BODY_LIMIT, 多读一个字节用于判断是否截断
+	body, err := io.ReadAll(io.LimitReader(response.Body, DEFAULT_ERROR_BODY_LIMIT+1))
+	if err != nil {
+		return httpErr
+	}
+	if len(body) > DEFAULT_ERROR_BODY_LIMIT {
+		body = body[:DEFAULT_ERROR_BODY_LIMIT]
+		httpErr.Truncated = true
+	}
+	httpErr.Body = body
+
+	// 截断的 body 无法完整解码
+	if detail != nil && len(body) > 0 && !httpErr.Truncated && DecodeBody(response.Header.Get("Content-Type"), body, detail) == nil {
+		httpErr.Detail = detail
+	}
+
+	return httpErr
+}
+
+// DecodeBody 按 Content-Type 选择解码器, 未知类型按 Json 解码, 也用于解码服务端收到的请求 body
+func DecodeBody(contentType string, body []byte, result interface{}) error {
+	// 原始内容不需要解码
+	switch v := result.(type) {
+	case *[]byte:
+		*v = body
+		return nil
+	case *string:
+		*v = string(body)
+		return nil
+	}
+
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		mediaType = ""
+	}
+
+	switch {
+	case mediaType == "application/xml" || mediaType == "text/xml" || strings.HasSuffix(mediaType, "+xml"):
+		return decodeXml(body, result)
+	case mediaType == "application/x-www-form-urlencoded":
+		return decodeForm(body, result)
+	default:
+		return json.Unmarshal(body, result)
+	}
+}
+
+// decodeXml 解码到 map 时使用 object.Xml2Map, 其它类型使用 xml.Unmarshal
+func decodeXml(body []byte, result interface{}) error {
+	switch v := result.(type) {
+	case *object.HashMap:
+		m, err := object.Xml2Map(body)
+		*v = m
+		return err
+	case *map[string]interface{}:
+		m, err := object.Xml2Map(body)
+		*v = m
+		return err
+	}
+	return xml.Unmarshal(body, result)
+}
+
+// decodeForm 解码到结构体时按 json tag 赋值, 值按字段的类型转换
+func decodeForm(body []byte, result interface{}) error {
+	values, err := url.ParseQuery(string(body))
+	if err != nil {
+		return err
+	}
+
+	switch v := result.(type) {
+	case *url.Values:
+		*v = values
+		return nil
+	case *map[string][]string:
+		*v = values
+		return nil
+	}
+
+	fields := object.StringMap{}
+	for key := range values {
+		fields[key] = values.Get(key)
+	}
+	switch v := result.(type) {
+	case *object.StringMap:
+		*v = fields
+		return nil
+	case *map[string]string:
+		*v = fields
+		return nil
+	}
+
+	b, err := json.Marshal(formFields(values, reflect.TypeOf(result)))
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(b, result)
+}
+
+// formFields 按 result 结构体字段的类型转换表单的值, 使数字, 布尔和切片字段也可以通过 Json 赋值.
+// 带 . 的字段名赋值到嵌套的结构体, 例如 payer.name, 其它字段取第一个值
+func formFields(values url.Values, t reflect.Type) map[string]interface{} {
+	fields := map[string]interface{}{}
+	for key, value := range values {
+		setFormField(fields, indirectType(t), strings.Split(key, "."), value)
+	}
+	return fields
+}
+
+func setFormField(fields map[string]interface{}, t reflect.Type, path []string, values []string) {
+	fieldType, found := jsonFieldType(t, path[0])
+	if len(path) == 1 {
+		if !found {
+			fields[path[0]] = values[0]
+			return
+		}
+		fields[path[0]] = formValue(fieldType, values)
+		return
+	}
+
+	if !found || indirectType(fieldType).Kind() != reflect.Struct {
+		fields[strings.Join(path, ".")] = values[0]
+		return
+	}
+	nested, ok := fields[path[0]].(map[string]interface{})
+	if !ok {
+		nested = map[string]interface{}{}
+		fields[path[0]] = nested
+	}
+	setFormField(nested, indirectType(fieldType), path[1:], values)
+}
+
+// jsonFieldType 返回结构体中 Json 名称为 name 的字段类型, 与 encoding/json 一样名称不区分大小写.
+// 带 string 选项的字段由 encoding/json 转换, 视为没有找到
+func jsonFieldType(t reflect.Type, name string) (reflect.Type, bool) {
+	if t == nil || t.Kind() != reflect.Struct {
+		return nil, false
+	}
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		tag := field.Tag.Get("json")
+		if tag == "-" || (!field.IsExported() && !field.Anonymous) {
+			continue
+		}
+		tagName, options, _ := strings.Cut(tag, ",")
+		if field.Anonymous && tagName == "" && indirectType(field.Type).Kind() == reflect.Struct {
+			if fieldType, found := jsonFieldType(indirectType(field.Type), name); found {
+				return fieldType, true
+			}
+			continue
+		}
+		if tagName == "" {
+			tagName = field.Name
+		}
+		if strings.EqualFold(tagName, name) {
+			return field.Type, !strings.Contains(","+options+",", ",string,")
+		}
+	}
+	return nil, false
+}
+
+func formValue(t reflect.Type, values []string) interface{} {
+	t = indirectType(t)
+	if t.Kind() == reflect.Slice && t.Elem().Kind() != reflect.Uint8 {
+		items := make([]interface{}, 0, len(values))
+		for _, value := range values {
+			items = append(items, formScalar(t.Elem(), value))
+		}
+		return items
+	}
+	return formScalar(t, values[0])
+}
+
+// formScalar 转换数字和布尔值, 无法转换时保留字符串, 由 json.Unmarshal 返回类型错误
+func formScalar(t reflect.Type, value string) interface{} {
+	switch indirectType(t).Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+		if _, err := strconv.ParseFloat(value, 64); err == nil {
+			return json.Number(value)
+		}
+	case reflect.Bool:
+		if b, err := strconv.ParseBool(value); err == nil {
+			return b
+		}
+	}
+	return value
+}
+
+func indirectType(t reflect.Type) reflect.Type {
+	for t != nil && t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	return t
+}
+
+// IsSuccess 判断状态码是否为 2xx
+func IsSuccess(statusCode int) bool {
+	return statusCode >= 200 && statusCode < 300
+}
+
+// errorResult 返回状态码注册的错误结构, 状态码 0 的结构匹配其它所有状态码
+func (d *Dataflow) errorResult(statusCode int) interface{} {
+	if detail, ok := d.errorResults[statusCode]; ok {
+		return detail
+	}
+	return d.errorResults[0]
+}
+
+func (d *Dataflow) decodeResponse(response *http.Response, result interface{}) error {
+	defer response.Body.Close()
+
+	if !IsSuccess(response.StatusCode) {
+		return NewHttpError(d.request, response, d.errorResult(response.StatusCode))
+	}
+
+	body, err := io.ReadAll(response.Body)
+	if err != nil {
+		return errors.Wrap(err, "read response failed")
+	}
+	if len(body) == 0 {
+		return nil
+	}
+
+	err = DecodeBody(response.Header.Get("Content-Type"), body, result)
+	if err != nil {
+		return errors.Wrap(err, "decode response failed")
+	}
+	return nil
+}
@@ -2,18 +2,70 @@ package http
 
 import (
 	"crypto/tls"
+	"crypto/x509"
+	"io"
 	"net/http"
 	"net/url"
+	"sync"
+	"sync/atomic"
 
 	"github.com/dadiYazZ/xin-da-libs/http/contract"
 	"github.com/dadiYazZ/xin-da-libs/xin-da-fmt"
 	"github.com/pkg/errors"
 )
 
-// Client 是 net/http 的封装
+// Client 是 net/http 的封装, 配置可以在使用中通过 SetConfig 替换, 正在进行的请求不受影响
 type Client struct {
+	state atomic.Pointer[clientState]
+}
+
+// clientState 是一份配置和根据它创建的 http.Client, 整体替换以保证并发安全
+type clientState struct {
 	conf       *contract.ClientConfig
 	coreClient *http.Client
+
+	// inflight 是正在使用该 http.Client 的请求数, 响应的 body 关闭后才结束
+	inflight atomic.Int64
+	// retired 为 true 时已经被替换, 请求全部结束后关闭 Transport 的连接
+	retired atomic.Bool
+}
+
+// acquire 在状态没有被替换时登记一个请求
+func (s *clientState) acquire() bool {
+	s.inflight.Add(1)
+	if s.retired.Load() {
+		s.release()
+		return false
+	}
+	return true
+}
+
+// release 结束一个请求, 被替换的状态的最后一个请求结束时关闭连接
+func (s *clientState) release() {
+	if s.inflight.Add(-1) == 0 && s.retired.Load() {
+		s.coreClient.CloseIdleConnections()
+	}
+}
+
+// retire 标记状态已被替换, 没有请求时立即关闭连接, 否则由最后一个请求关闭
+func (s *clientState) retire() {
+	s.retired.Store(true)
+	if s.inflight.Load() == 0 {
+		s.coreClient.CloseIdleConnections()
+	}
+}
+
+// releaseBody 在响应的 body 第一次关闭时结束请求
+type releaseBody struct {
+	io.ReadCloser
+	once    sync.Once
+	release func()
+}
+
+func (b *releaseBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.once.Do(b.release)
+	return err
 }
 
 func NewHttpClient(config *contract.ClientConfig) (*Client, error) {
@@ -21,79 +73,176 @@ func NewHttpClient(config *contract.ClientConfig) (*Client, error) {
 		config = &contract.ClientConfig{}
 		config.Default()
 	}
-	coreClient := http.Client{
-		Timeout: config.Timeout,
+
+	client := &Client{}
+	err := client.Reconfigure(config)
+	if err != nil {
+		return nil, err
 	}
-	var proxy func(*http.Request) (*url.URL, error)
-	if config.ProxyURI != "" {
-		if proxyURL, err := url.Parse(config.ProxyURI); err == nil {
-			proxy = http.ProxyURL(proxyURL)
-		}
+	return client, nil
+}
+
+// NewTransport 根据配置创建 Transport, 在 http.DefaultTransport 的基础上设置 tls, 代理, 连接池和 HTTP/2
+func NewTransport(config *contract.ClientConfig) (http.RoundTripper, error) {
+	if config.Transport != nil {
+		// 自定义的 Transport 由调用方自己配置 tls 和代理
+		return config.Transport, nil
 	}
-	if config.Cert.CertFile != "" && config.Cert.KeyFile != "" {
-		certPair, err := tls.LoadX509KeyPair(config.Cert.CertFile, config.Cert.KeyFile)
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+
+	tlsConfig, err := newTlsConfig(config)
+	if err != nil {
+		return nil, err
+	}
+	transport.TLSClientConfig = tlsConfig
+
+	if config.ProxyURI != "" {
+		proxyURL, err := url.Parse(config.ProxyURI)
 		if err != nil {
-			return nil, errors.Wrap(err, "failed to load certificate")
+			return nil, errors.Wrap(err, "invalid proxy uri")
+		}
+		switch proxyURL.Scheme {
+		case "http", "https", "socks5", "socks5h":
+		default:
+			return nil, errors.Errorf("unsupported proxy scheme %s", proxyURL.Scheme)
 		}
-		coreClient.Transport = &http.Transport{
-			TLSClientConfig: &tls.Config{
-				Certificates: []tls.Certificate{certPair},
-			}, Proxy: proxy}
-	} else if proxy != nil {
-		coreClient.Transport = &http.Transport{TLSClientConfig: &tls.Config{
-			InsecureSkipVerify: true,
-		}, Proxy: proxy}
+		// 代理的认证信息由 Transport 从 URI 中读取
+		transport.Proxy = http.ProxyURL(proxyURL)
 	}
 
-	if config.Transport != nil {
-		// 这里会直接覆盖上面的 tls 和 proxy 有能力配置这个的话会自己解决的
-		coreClient.Transport = config.Transport
+	if config.MaxIdleConns > 0 {
+		transport.MaxIdleConns = config.MaxIdleConns
+	}
+	if config.MaxIdleConnsPerHost > 0 {
+		transport.MaxIdleConnsPerHost = config.MaxIdleConnsPerHost
+	}
+	if config.MaxConnsPerHost > 0 {
+		transport.MaxConnsPerHost = config.MaxConnsPerHost
+	}
+	if config.IdleConnTimeout > 0 {
+		transport.IdleConnTimeout = config.IdleConnTimeout
+	}
+
+	if config.DisableHTTP2 {
+		transport.ForceAttemptHTTP2 = false
+		transport.TLSNextProto = map[string]func(string, *tls.Conn) http.RoundTripper{}
+	} else {
+		// 自定义了 TLSClientConfig 的 Transport 需要显式开启 HTTP/2
+		transport.ForceAttemptHTTP2 = true
 	}
 
-	return &Client{
-		conf:       config,
-		coreClient: &coreClient,
-	}, nil
+	return transport, nil
 }
 
-// SetConfig 配置客户端
-func (c *Client) SetConfig(config *contract.ClientConfig) {
-	if config != nil {
-		c.conf = config
+// newTlsConfig 复制 TlsConfig, 追加根证书和客户端证书, 默认校验服务端证书
+func newTlsConfig(config *contract.ClientConfig) (*tls.Config, error) {
+	tlsConfig := &tls.Config{}
+	if config.TlsConfig != nil {
+		tlsConfig = config.TlsConfig.Clone()
 	}
-	var proxy func(*http.Request) (*url.URL, error)
-	if config.ProxyURI != "" {
-		if proxyURL, err := url.Parse(config.ProxyURI); err == nil {
-			proxy = http.ProxyURL(proxyURL)
+	if config.InsecureSkipVerify {
+		tlsConfig.InsecureSkipVerify = true
+	}
+
+	if len(config.RootCAs) > 0 {
+		pool := tlsConfig.RootCAs
+		if pool == nil {
+			systemPool, err := x509.SystemCertPool()
+			if err != nil {
+				systemPool = x509.NewCertPool()
+			}
+			pool = systemPool
+		} else {
+			pool = pool.Clone()
+		}
+		if !pool.AppendCertsFromPEM(config.RootCAs) {
+			return nil, errors.New("failed to load root ca, no certificate found in pem")
 		}
+		tlsConfig.RootCAs = pool
 	}
-	coreClient := http.Client{
-		Timeout: config.Timeout,
+
+	var certPair tls.Certificate
+	var err error
+	switch {
+	case len(config.Cert.CertPEM) > 0 && len(config.Cert.KeyPEM) > 0:
+		certPair, err = tls.X509KeyPair(config.Cert.CertPEM, config.Cert.KeyPEM)
+	case config.Cert.CertFile != "" && config.Cert.KeyFile != "":
+		certPair, err = tls.LoadX509KeyPair(config.Cert.CertFile, config.Cert.KeyFile)
+	default:
+		return tlsConfig, nil
 	}
-	// todo set coreClient
-	if config.Cert.CertFile != "" && config.Cert.KeyFile != "" {
-		certPair, err := tls.LoadX509KeyPair(config.Cert.CertFile, config.Cert.KeyFile)
-		if err != nil {
-			err = errors.Wrap(err, "failed to load certificate")
-			xin_da_fmt.Dump(err)
-			return
-		}
-		coreClient.Transport = &http.Transport{TLSClientConfig: &tls.Config{
-			Certificates: []tls.Certificate{certPair},
-		}}
-		c.coreClient = &coreClient
-	} else if proxy != nil {
-		coreClient.Transport = &http.Transport{TLSClientConfig: &tls.Config{
-			InsecureSkipVerify: true,
-		}, Proxy: proxy}
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to load certificate")
+	}
+	tlsConfig.Certificates = append(tlsConfig.Certificates, certPair)
+
+	return tlsConfig, nil
+}
+
+// Reconfigure 根据配置创建新的 http.Client 并替换当前的, 配置无效时返回错误并保留原来的配置.
+// 原来的 Transport 在进行中的请求都结束, 即响应的 body 都关闭后关闭连接, 包括 HTTP/2 的连接.
+// 不关闭 body 的请求会使原来的连接一直保留到 IdleConnTimeout
+func (c *Client) Reconfigure(config *contract.ClientConfig) error {
+	if config == nil {
+		return errors.New("nil client config")
+	}
+	conf := *config
+
+	transport, err := NewTransport(&conf)
+	if err != nil {
+		return err
+	}
+
+	previous := c.state.Swap(&clientState{
+		conf: &conf,
+		coreClient: &http.Client{
+			Timeout:   conf.Timeout,
+			Transport: transport,
+		},
+	})
+	if previous != nil && previous.coreClient.Transport != transport {
+		previous.retire()
+	}
+	return nil
+}
+
+// SetConfig 配置客户端, 配置无效时保留原来的配置, 需要得到错误时请使用 Reconfigure
+func (c *Client) SetConfig(config *contract.ClientConfig) {
+	err := c.Reconfigure(config)
+	if err != nil {
+		xin_da_fmt.Dump(err)
 	}
 }
 
 // GetConfig 返回配置副本
 func (c *Client) GetConfig() contract.ClientConfig {
-	return *c.conf
+	return *c.state.Load().conf
 }
 
 func (c *Client) DoRequest(request *http.Request) (response *http.Response, err error) {
-	return c.coreClient.Do(request)
+	state := c.acquire()
+	response, err = state.coreClient.Do(request)
+	if err != nil {
+		state.release()
+		return nil, err
+	}
+
+	// 101 响应的 body 是升级后的连接, 不再属于连接池
+	if response.Body == nil || response.Body == http.NoBody || response.StatusCode == http.StatusSwitchingProtocols {
+		state.release()
+		return response, nil
+	}
+	response.Body = &releaseBody{ReadCloser: response.Body, release: state.release}
+	return response, nil
+}
+
+// acquire 返回当前的状态并登记请求, 登记时刚好被替换的状态不再使用
+func (c *Client) acquire() *clientState {
+	for {
+		state := c.state.Load()
+		if state.acquire() {
+			return state
+		}
+	}
 }
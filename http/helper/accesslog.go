@@ -0,0 +1,249 @@
+package helper
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	libsHelper "github.com/dadiYazZ/xin-da-libs/helper"
+	"github.com/dadiYazZ/xin-da-libs/http/contract"
+	logger "github.com/dadiYazZ/xin-da-libs/logger/contract"
+)
+
+const (
+	// REDACTED 替换被脱敏的值
+	REDACTED = "[REDACTED]"
+
+	DEFAULT_ACCESS_LOG_MESSAGE  = "http request"
+	DEFAULT_ACCESS_LOG_MAX_BODY = 2048
+)
+
+// DEFAULT_REDACT_HEADERS 是默认脱敏的请求头和响应头
+var DEFAULT_REDACT_HEADERS = []string{
+	"Authorization",
+	"Proxy-Authorization",
+	"Cookie",
+	"Set-Cookie",
+}
+
+type AccessLogOptions struct {
+	// Logger 必填, 日志通过 Logger.WithContext(request.Context()) 输出
+	Logger logger.LoggerInterface
+	// Message 日志的内容, 默认为 DEFAULT_ACCESS_LOG_MESSAGE
+	Message string
+
+	// LogHeaders 记录请求头和响应头
+	LogHeaders bool
+	// RedactHeaders 中的请求头和响应头替换为 REDACTED, 默认为 DEFAULT_REDACT_HEADERS
+	RedactHeaders []string
+	// RedactQuery 中的 query 参数替换为 REDACTED, 例如 access_token
+	RedactQuery []string
+
+	// LogBody 记录请求和响应的 body, 最多 MaxBody 字节
+	LogBody bool
+	// MaxBody 记录的 body 长度上限, 默认为 DEFAULT_ACCESS_LOG_MAX_BODY
+	MaxBody int
+	// RedactJsonFields 中的 Json 字段在任意层级替换为 REDACTED, 只对完整记录的 Json body 生效
+	RedactJsonFields []string
+	// RedactBody 在记录前自定义脱敏, 在 RedactJsonFields 之后执行
+	RedactBody func(header http.Header, body []byte) []byte
+}
+
+func (o *AccessLogOptions) withDefaults() AccessLogOptions {
+	options := AccessLogOptions{}
+	if o != nil {
+		options = *o
+	}
+	if options.Message == "" {
+		options.Message = DEFAULT_ACCESS_LOG_MESSAGE
+	}
+	if options.RedactHeaders == nil {
+		options.RedactHeaders = DEFAULT_REDACT_HEADERS
+	}
+	if options.MaxBody <= 0 {
+		options.MaxBody = DEFAULT_ACCESS_LOG_MAX_BODY
+	}
+	return options
+}
+
+// AccessLogMiddleware 为每个请求输出一条结构化日志, 包含 method, url, status, duration, request_size, response_size 和 trace_id.
+// 请求失败或响应状态码 >= 500 时使用 Error 级别, 否则使用 Info 级别.
+// 与 TracingMiddleware 组合时, 应该先注册 TracingMiddleware, 日志中的 trace_id 才是请求 span 的
+func AccessLogMiddleware(options *AccessLogOptions) contract.RequestMiddleware {
+	opts := options.withDefaults()
+	if opts.Logger == nil {
+		panic("access log middleware: nil logger")
+	}
+
+	return func(handle contract.RequestHandle) contract.RequestHandle {
+		return func(request *http.Request) (response *http.Response, err error) {
+			var requestBody []byte
+			if opts.LogBody {
+				requestBody = peekRequestBody(request, opts.MaxBody)
+			}
+
+			start := time.Now()
+			response, err = handle(request)
+			duration := time.Since(start)
+
+			fields := []interface{}{
+				"method", request.Method,
+				"url", redactUrl(request.URL, opts.RedactQuery),
+				"duration", duration.String(),
+				"request_size", request.ContentLength,
+			}
+			if traceID := libsHelper.TraceIDFromContext(request.Context()); traceID != "" {
+				fields = append(fields, "trace_id", traceID)
+			}
+			if opts.LogHeaders {
+				fields = append(fields, "request_header", redactHeader(request.Header, opts.RedactHeaders))
+			}
+			if opts.LogBody && requestBody != nil {
+				fields = append(fields, "request_body", opts.redactBody(request.Header, requestBody))
+			}
+
+			log := opts.Logger.WithContext(request.Context())
+			if err != nil {
+				fields = append(fields, "error", err.Error())
+				log.Error(opts.Message, fields...)
+				return response, err
+			}
+
+			fields = append(fields,
+				"status", response.StatusCode,
+				"response_size", response.ContentLength,
+			)
+			if opts.LogHeaders {
+				fields = append(fields, "response_header", redactHeader(response.Header, opts.RedactHeaders))
+			}
+			if opts.LogBody {
+				if responseBody := peekResponseBody(response, opts.MaxBody); responseBody != nil {
+					fields = append(fields, "response_body", opts.redactBody(response.Header, responseBody))
+				}
+			}
+
+			if response.StatusCode >= http.StatusInternalServerError {
+				log.Error(opts.Message, fields...)
+			} else {
+				log.Info(opts.Message, fields...)
+			}
+			return response, err
+		}
+	}
+}
+
+// redactBody 对完整的 Json body 脱敏字段, 被截断的 body 只执行 RedactBody
+func (o *AccessLogOptions) redactBody(header http.Header, body []byte) string {
+	if len(o.RedactJsonFields) > 0 && len(body) <= o.MaxBody {
+		var value interface{}
+		if json.Unmarshal(body, &value) == nil {
+			if b, err := json.Marshal(redactJsonValue(value, o.RedactJsonFields)); err == nil {
+				body = b
+			}
+		}
+	}
+	if o.RedactBody != nil {
+		body = o.RedactBody(header, body)
+	}
+	if len(body) > o.MaxBody {
+		return string(body[:o.MaxBody]) + "..."
+	}
+	return string(body)
+}
+
+func redactJsonValue(value interface{}, fields []string) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for key, item := range v {
+			if containsFold(fields, key) {
+				v[key] = REDACTED
+			} else {
+				v[key] = redactJsonValue(item, fields)
+			}
+		}
+	case []interface{}:
+		for i, item := range v {
+			v[i] = redactJsonValue(item, fields)
+		}
+	}
+	return value
+}
+
+func containsFold(values []string, value string) bool {
+	for _, v := range values {
+		if strings.EqualFold(v, value) {
+			return true
+		}
+	}
+	return false
+}
+
+// peekRequestBody 读取请求 body 的前 limit+1 字节, 优先通过 GetBody 读取副本, 否则读取后重新拼接 body
+func peekRequestBody(request *http.Request, limit int) []byte {
+	if request.Body == nil || request.Body == http.NoBody {
+		return nil
+	}
+	if request.GetBody != nil {
+		body, err := request.GetBody()
+		if err != nil {
+			return nil
+		}
+		defer body.Close()
+		prefix, _ := io.ReadAll(io.LimitReader(body, int64(limit)+1))
+		return prefix
+	}
+
+	prefix, body := peekBody(request.Body, limit)
+	request.Body = body
+	return prefix
+}
+
+// peekResponseBody 读取响应 body 的前 limit+1 字节, 调用方仍然可以读取完整的 body
+func peekResponseBody(response *http.Response, limit int) []byte {
+	if response.Body == nil || response.Body == http.NoBody {
+		return nil
+	}
+	prefix, body := peekBody(response.Body, limit)
+	response.Body = body
+	return prefix
+}
+
+func peekBody(body io.ReadCloser, limit int) ([]byte, io.ReadCloser) {
+	prefix, _ := io.ReadAll(io.LimitReader(body, int64(limit)+1))
+	return prefix, struct {
+		io.Reader
+		io.Closer
+	}{io.MultiReader(bytes.NewReader(prefix), body), body}
+}
+
+// redactUrl 返回脱敏后的 Url, 去掉 Url 中的密码
+func redactUrl(u *url.URL, keys []string) string {
+	if u == nil {
+		return ""
+	}
+	redacted := *u
+	if len(keys) > 0 && redacted.RawQuery != "" {
+		query := redacted.Query()
+		for _, key := range keys {
+			if query.Has(key) {
+				query.Set(key, REDACTED)
+			}
+		}
+		redacted.RawQuery = query.Encode()
+	}
+	return redacted.Redacted()
+}
+
+func redactHeader(header http.Header, keys []string) http.Header {
+	header = header.Clone()
+	for _, key := range keys {
+		if header.Get(key) != "" {
+			header.Set(key, REDACTED)
+		}
+	}
+	return header
+}
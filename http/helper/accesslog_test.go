@@ -0,0 +1,144 @@
+package helper
+
+import (
+	"context"
+	"errors"
+	"github.com/dadiYazZ/xin-da-libs/http/contract"
+	logger "github.com/dadiYazZ/xin-da-libs/logger/contract"
+	"github.com/dadiYazZ/xin-da-libs/logger/drivers"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"net/http"
+	"sync"
+	"testing"
+)
+
+type accessLogEntry struct {
+	level  string
+	msg    string
+	fields map[string]interface{}
+}
+
+// testLogger records the entries of Info and Error
+type testLogger struct {
+	drivers.DummyLogger
+	mu      sync.Mutex
+	entries []accessLogEntry
+}
+
+func (l *testLogger) WithContext(ctx context.Context) logger.LoggerInterface {
+	return l
+}
+
+func (l *testLogger) log(level string, msg string, v ...interface{}) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	fields := map[string]interface{}{}
+	for i := 0; i+1 < len(v); i += 2 {
+		fields[v[i].(string)] = v[i+1]
+	}
+	l.entries = append(l.entries, accessLogEntry{level: level, msg: msg, fields: fields})
+}
+
+func (l *testLogger) Info(msg string, v ...interface{})  { l.log("info", msg, v...) }
+func (l *testLogger) Error(msg string, v ...interface{}) { l.log("error", msg, v...) }
+
+func TestAccessLogMiddleware(t *testing.T) {
+	responseBody := `{"token":"response-secret","user":{"name":"joe","password":"123456"}}`
+	helper := newTestRequestHelper(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Set-Cookie", "session=secret")
+		_, _ = w.Write([]byte(responseBody))
+	})
+	log := &testLogger{}
+	helper.WithMiddleware(AccessLogMiddleware(&AccessLogOptions{
+		Logger:           log,
+		LogHeaders:       true,
+		LogBody:          true,
+		RedactQuery:      []string{"access_token"},
+		RedactJsonFields: []string{"token", "password"},
+	}))
+
+	result := map[string]interface{}{}
+	err := helper.Df().Method(http.MethodPost).Uri("/users?access_token=secret&page=1").
+		Header("Authorization", "Bearer secret").
+		Json(map[string]string{"password": "123456"}).Result(&result)
+	assert.NoError(t, err)
+	// the middleware does not consume the response
+	assert.Equal(t, "response-secret", result["token"])
+
+	assert.Len(t, log.entries, 1)
+	entry := log.entries[0]
+	assert.Equal(t, "info", entry.level)
+	assert.Equal(t, DEFAULT_ACCESS_LOG_MESSAGE, entry.msg)
+	assert.Equal(t, http.MethodPost, entry.fields["method"])
+	assert.Equal(t, helper.config.BaseUrl+"/users?access_token=%5BREDACTED%5D&page=1", entry.fields["url"])
+	assert.Equal(t, http.StatusOK, entry.fields["status"])
+	assert.NotEmpty(t, entry.fields["duration"])
+	// the Json encoder ends the body with a newline
+	assert.Equal(t, int64(len(`{"password":"123456"}`)+1), entry.fields["request_size"])
+	assert.Equal(t, int64(len(responseBody)), entry.fields["response_size"])
+	assert.Equal(t, REDACTED, entry.fields["request_header"].(http.Header).Get("Authorization"))
+	assert.Equal(t, REDACTED, entry.fields["response_header"].(http.Header).Get("Set-Cookie"))
+	assert.Equal(t, `{"password":"[REDACTED]"}`, entry.fields["request_body"])
+	assert.Equal(t, `{"token":"[REDACTED]","user":{"name":"joe","password":"[REDACTED]"}}`, entry.fields["response_body"])
+}
+
+func TestAccessLogMiddleware_Truncate(t *testing.T) {
+	helper := newTestRequestHelper(t, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadGateway)
+		_, _ = io.Copy(w, r.Body)
+	})
+	log := &testLogger{}
+	helper.WithMiddleware(AccessLogMiddleware(&AccessLogOptions{
+		Logger:  log,
+		LogBody: true,
+		MaxBody: 4,
+	}))
+
+	response, err := helper.Df().Method(http.MethodPost).Uri("/").
+		Header("Content-Type", "text/plain").
+		Body(&readerOnly{data: []byte("0123456789")}).Request()
+	assert.NoError(t, err)
+	body, _ := io.ReadAll(response.Body)
+	assert.Equal(t, "0123456789", string(body))
+
+	entry := log.entries[0]
+	assert.Equal(t, "error", entry.level)
+	assert.Equal(t, "0123...", entry.fields["request_body"])
+	assert.Equal(t, "0123...", entry.fields["response_body"])
+	assert.Nil(t, entry.fields["request_header"])
+}
+
+func TestAccessLogMiddleware_Error(t *testing.T) {
+	helper := newTestRequestHelper(t, func(w http.ResponseWriter, r *http.Request) {})
+	log := &testLogger{}
+	failed := errors.New("connection refused")
+	helper.WithMiddleware(AccessLogMiddleware(&AccessLogOptions{Logger: log}), func(handle contract.RequestHandle) contract.RequestHandle {
+		return func(request *http.Request) (*http.Response, error) {
+			return nil, failed
+		}
+	})
+
+	_, err := helper.Df().Method(http.MethodGet).Uri("/").Request()
+	assert.ErrorIs(t, err, failed)
+	assert.Equal(t, "error", log.entries[0].level)
+	assert.Equal(t, failed.Error(), log.entries[0].fields["error"])
+	assert.Nil(t, log.entries[0].fields["status"])
+
+	assert.Panics(t, func() { AccessLogMiddleware(nil) })
+}
+
+// readerOnly hides the type of the body, so that GetBody is not set
+type readerOnly struct {
+	data []byte
+}
+
+func (r *readerOnly) Read(p []byte) (int, error) {
+	if len(r.data) == 0 {
+		return 0, io.EOF
+	}
+	n := copy(p, r.data)
+	r.data = r.data[n:]
+	return n, nil
+}
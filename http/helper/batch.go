@@ -0,0 +1,193 @@
+package helper
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/dadiYazZ/xin-da-libs/http/contract"
+)
+
+const DEFAULT_BATCH_WORKERS = 10
+
+type BatchOptions struct {
+	// Workers 同时执行的请求数, 默认为 DEFAULT_BATCH_WORKERS
+	Workers int
+	// PerHost 每个 host 同时执行的请求数, 为 0 时不限制
+	PerHost int
+	// FailFast 第一个请求失败后取消其它请求, 默认执行所有请求
+	FailFast bool
+}
+
+// BatchResult 是一个请求的结果, 与传入的 dataflow 按下标对应
+type BatchResult[T any] struct {
+	Result T
+	Err    error
+}
+
+// BatchError 汇总失败的请求, Errors 与 dataflow 按下标对应, 成功的请求为 nil
+type BatchError struct {
+	Errors []error
+	// First 是最先失败的请求的错误, FailFast 时其它请求因它被取消
+	First error
+}
+
+func (e *BatchError) Error() string {
+	failed := 0
+	for _, err := range e.Errors {
+		if err != nil {
+			failed++
+		}
+	}
+	return fmt.Sprintf("%d of %d batch requests failed, first error: %s", failed, len(e.Errors), e.First)
+}
+
+// Unwrap 使 errors.Is 和 errors.As 可以匹配任意一个请求的错误
+func (e *BatchError) Unwrap() []error {
+	return e.Errors
+}
+
+// Batch 并发执行 dataflows, 将每个响应通过 Result 解码为 T, 结果与 dataflows 按下标对应.
+// ctx 取消时未开始的请求不再发出, 正在执行的请求也会被取消. 有请求失败时返回 *BatchError
+func Batch[T any](ctx context.Context, dataflows []contract.RequestDataflowInterface, options *BatchOptions) ([]BatchResult[T], error) {
+	opts := BatchOptions{}
+	if options != nil {
+		opts = *options
+	}
+	if opts.Workers <= 0 {
+		opts.Workers = DEFAULT_BATCH_WORKERS
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	results := make([]BatchResult[T], len(dataflows))
+	limiter := newHostLimiter(opts.PerHost)
+
+	var mu sync.Mutex
+	var first error
+	fail := func(err error) {
+		mu.Lock()
+		defer mu.Unlock()
+		if first == nil {
+			first = err
+			if opts.FailFast {
+				cancel()
+			}
+		}
+	}
+
+	jobs := make(chan int)
+	wg := sync.WaitGroup{}
+	for i := 0; i < opts.Workers && i < len(dataflows); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for index := range jobs {
+				results[index].Result, results[index].Err = runBatchItem[T](ctx, dataflows[index], limiter)
+				if results[index].Err != nil {
+					fail(results[index].Err)
+				}
+			}
+		}()
+	}
+
+	dispatched := 0
+dispatch:
+	for ; dispatched < len(dataflows); dispatched++ {
+		select {
+		case jobs <- dispatched:
+		case <-ctx.Done():
+			break dispatch
+		}
+	}
+	close(jobs)
+	wg.Wait()
+
+	// 没有发出的请求返回取消的原因
+	for index := dispatched; index < len(dataflows); index++ {
+		results[index].Err = ctx.Err()
+	}
+
+	batchErr := &BatchError{Errors: make([]error, len(results)), First: first}
+	failed := false
+	for index, result := range results {
+		if result.Err != nil {
+			batchErr.Errors[index] = result.Err
+			failed = true
+		}
+	}
+	if !failed {
+		return results, nil
+	}
+	if batchErr.First == nil {
+		batchErr.First = ctx.Err()
+	}
+	return results, batchErr
+}
+
+func runBatchItem[T any](ctx context.Context, df contract.RequestDataflowInterface, limiter *hostLimiter) (result T, err error) {
+	if err = df.Err(); err != nil {
+		return result, err
+	}
+
+	request := df.GetRequest()
+	host := ""
+	if request.URL != nil {
+		host = request.URL.Host
+	}
+	release, err := limiter.acquire(ctx, host)
+	if err != nil {
+		return result, err
+	}
+	defer release()
+
+	// dataflow 设置了自己的 context 时, 它或 batch 取消都会取消请求
+	requestCtx := ctx
+	if parent := request.Context(); parent != context.Background() {
+		var cancel context.CancelFunc
+		requestCtx, cancel = context.WithCancel(parent)
+		defer cancel()
+		stop := context.AfterFunc(ctx, cancel)
+		defer stop()
+	}
+
+	err = df.WithContext(requestCtx).Result(&result)
+	return result, err
+}
+
+// hostLimiter 限制每个 host 同时执行的请求数
+type hostLimiter struct {
+	limit int
+
+	mu    sync.Mutex
+	slots map[string]chan struct{}
+}
+
+func newHostLimiter(limit int) *hostLimiter {
+	return &hostLimiter{
+		limit: limit,
+		slots: map[string]chan struct{}{},
+	}
+}
+
+func (l *hostLimiter) acquire(ctx context.Context, host string) (func(), error) {
+	if l.limit <= 0 {
+		return func() {}, ctx.Err()
+	}
+
+	l.mu.Lock()
+	slot, ok := l.slots[host]
+	if !ok {
+		slot = make(chan struct{}, l.limit)
+		l.slots[host] = slot
+	}
+	l.mu.Unlock()
+
+	select {
+	case slot <- struct{}{}:
+		return func() { <-slot }, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
@@ -0,0 +1,123 @@
+package helper
+
+import (
+	"context"
+	"errors"
+	"github.com/dadiYazZ/xin-da-libs/http/contract"
+	"github.com/dadiYazZ/xin-da-libs/http/dataflow"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+type batchItem struct {
+	Id int `json:"id"`
+}
+
+func TestBatch(t *testing.T) {
+	var running, maxRunning int32
+	helper := newTestRequestHelper(t, func(w http.ResponseWriter, r *http.Request) {
+		n := atomic.AddInt32(&running, 1)
+		defer atomic.AddInt32(&running, -1)
+		for {
+			max := atomic.LoadInt32(&maxRunning)
+			if n <= max || atomic.CompareAndSwapInt32(&maxRunning, max, n) {
+				break
+			}
+		}
+		time.Sleep(10 * time.Millisecond)
+
+		id, _ := strconv.Atoi(r.URL.Query().Get("id"))
+		if id%5 == 4 {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"id":` + strconv.Itoa(id) + `}`))
+	})
+
+	dataflows := []contract.RequestDataflowInterface{}
+	for i := 0; i < 20; i++ {
+		dataflows = append(dataflows, helper.Df().Method(http.MethodGet).Uri("/items").Query("id", strconv.Itoa(i)))
+	}
+
+	results, err := Batch[batchItem](context.Background(), dataflows, &BatchOptions{Workers: 8, PerHost: 3})
+	var batchErr *BatchError
+	assert.True(t, errors.As(err, &batchErr))
+	var httpErr *dataflow.HttpError
+	assert.True(t, errors.As(err, &httpErr))
+	assert.Equal(t, http.StatusNotFound, httpErr.StatusCode)
+
+	assert.Len(t, results, 20)
+	for i, result := range results {
+		if i%5 == 4 {
+			assert.Error(t, result.Err)
+			assert.Equal(t, result.Err, batchErr.Errors[i])
+			continue
+		}
+		assert.NoError(t, result.Err)
+		assert.Nil(t, batchErr.Errors[i])
+		assert.Equal(t, i, result.Result.Id)
+	}
+	assert.LessOrEqual(t, atomic.LoadInt32(&maxRunning), int32(3))
+}
+
+func TestBatch_FailFast(t *testing.T) {
+	var calls int32
+	helper := newTestRequestHelper(t, func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		if r.URL.Query().Get("id") == "0" {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		select {
+		case <-r.Context().Done():
+		case <-time.After(time.Second):
+		}
+	})
+
+	dataflows := []contract.RequestDataflowInterface{}
+	for i := 0; i < 10; i++ {
+		dataflows = append(dataflows, helper.Df().Method(http.MethodGet).Uri("/items").Query("id", strconv.Itoa(i)))
+	}
+
+	start := time.Now()
+	results, err := Batch[string](context.Background(), dataflows, &BatchOptions{Workers: 2, FailFast: true})
+	assert.Less(t, time.Since(start), time.Second)
+
+	var batchErr *BatchError
+	assert.True(t, errors.As(err, &batchErr))
+	var httpErr *dataflow.HttpError
+	assert.True(t, errors.As(batchErr.First, &httpErr))
+	assert.True(t, errors.Is(results[9].Err, context.Canceled))
+	assert.Less(t, atomic.LoadInt32(&calls), int32(10))
+
+	// all succeeded
+	results, err = Batch[string](context.Background(), []contract.RequestDataflowInterface{
+		helper.Df().Method(http.MethodGet).Uri("/items").Query("id", "1"),
+	}, nil)
+	assert.NoError(t, err)
+	assert.NoError(t, results[0].Err)
+}
+
+func TestBatch_Cancel(t *testing.T) {
+	helper := newTestRequestHelper(t, func(w http.ResponseWriter, r *http.Request) {
+		<-r.Context().Done()
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	dataflows := []contract.RequestDataflowInterface{
+		helper.Df().Method(http.MethodGet).Uri("/a"),
+		helper.Df().Method(http.MethodGet).Uri("/b"),
+		helper.Df().Method(http.MethodGet).Uri("/c"),
+	}
+	results, err := Batch[string](ctx, dataflows, &BatchOptions{Workers: 1})
+	assert.Error(t, err)
+	for _, result := range results {
+		assert.True(t, errors.Is(result.Err, context.DeadlineExceeded))
+	}
+}
@@ -0,0 +1,216 @@
+package helper
+
+import (
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/dadiYazZ/xin-da-libs/http/contract"
+	"github.com/pkg/errors"
+)
+
+const (
+	DEFAULT_BREAKER_FAILURE_THRESHOLD = 5
+	DEFAULT_BREAKER_OPEN_TIMEOUT      = 30 * time.Second
+	DEFAULT_BREAKER_HALF_OPEN_PROBES  = 1
+)
+
+// ErrCircuitOpen 目标 host 的熔断器处于打开状态, 请求没有发出
+var ErrCircuitOpen = errors.New("circuit breaker is open")
+
+type CircuitState int
+
+const (
+	CircuitClosed CircuitState = iota
+	CircuitOpen
+	CircuitHalfOpen
+)
+
+func (s CircuitState) String() string {
+	switch s {
+	case CircuitOpen:
+		return "open"
+	case CircuitHalfOpen:
+		return "half-open"
+	default:
+		return "closed"
+	}
+}
+
+type CircuitBreakerOptions struct {
+	// FailureThreshold 连续失败多少次后打开熔断器
+	FailureThreshold int
+	// OpenTimeout 熔断器打开多久后进入半开状态, 放行探测请求
+	OpenTimeout time.Duration
+	// HalfOpenProbes 半开状态下同时放行的探测请求数, 全部成功后关闭熔断器, 任意一个失败则重新打开
+	HalfOpenProbes int
+	// IsFailure 自定义失败的判断, 默认为请求错误或 5xx 响应
+	IsFailure func(response *http.Response, err error) bool
+}
+
+// CircuitBreaker 按 host 统计失败次数, 各个 host 的熔断状态互不影响
+type CircuitBreaker struct {
+	options CircuitBreakerOptions
+
+	mu       sync.Mutex
+	circuits map[string]*circuit
+	now      func() time.Time
+}
+
+type circuit struct {
+	state    CircuitState
+	failures int
+	openedAt time.Time
+	// probes 半开状态下已放行的探测请求数, successes 其中成功的个数
+	probes    int
+	successes int
+}
+
+func NewCircuitBreaker(options *CircuitBreakerOptions) *CircuitBreaker {
+	opts := CircuitBreakerOptions{}
+	if options != nil {
+		opts = *options
+	}
+	if opts.FailureThreshold <= 0 {
+		opts.FailureThreshold = DEFAULT_BREAKER_FAILURE_THRESHOLD
+	}
+	if opts.OpenTimeout <= 0 {
+		opts.OpenTimeout = DEFAULT_BREAKER_OPEN_TIMEOUT
+	}
+	if opts.HalfOpenProbes <= 0 {
+		opts.HalfOpenProbes = DEFAULT_BREAKER_HALF_OPEN_PROBES
+	}
+	if opts.IsFailure == nil {
+		opts.IsFailure = func(response *http.Response, err error) bool {
+			return err != nil || response.StatusCode >= http.StatusInternalServerError
+		}
+	}
+
+	return &CircuitBreaker{
+		options:  opts,
+		circuits: map[string]*circuit{},
+		now:      time.Now,
+	}
+}
+
+// CircuitBreakerMiddleware 返回一个独立熔断器的中间件, 需要查询熔断状态时请使用 NewCircuitBreaker
+func CircuitBreakerMiddleware(options *CircuitBreakerOptions) contract.RequestMiddleware {
+	return NewCircuitBreaker(options).Middleware()
+}
+
+// Middleware 在熔断器打开时直接返回 ErrCircuitOpen, 不会发出请求
+func (b *CircuitBreaker) Middleware() contract.RequestMiddleware {
+	return func(handle contract.RequestHandle) contract.RequestHandle {
+		return func(request *http.Request) (response *http.Response, err error) {
+			host := request.URL.Host
+			probe, err := b.allow(host)
+			if err != nil {
+				return nil, err
+			}
+
+			response, err = handle(request)
+			b.record(host, probe, b.options.IsFailure(response, err))
+			return response, err
+		}
+	}
+}
+
+// State 返回 host 当前的熔断状态
+func (b *CircuitBreaker) State(host string) CircuitState {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	c, found := b.circuits[host]
+	if !found {
+		return CircuitClosed
+	}
+	if c.state == CircuitOpen && b.now().Sub(c.openedAt) >= b.options.OpenTimeout {
+		return CircuitHalfOpen
+	}
+	return c.state
+}
+
+// Reset 关闭 host 的熔断器并清空失败次数
+func (b *CircuitBreaker) Reset(host string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	delete(b.circuits, host)
+}
+
+// allow 判断请求能否发出, probe 表示该请求是半开状态下的探测请求
+func (b *CircuitBreaker) allow(host string) (probe bool, err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	c, found := b.circuits[host]
+	if !found {
+		c = &circuit{}
+		b.circuits[host] = c
+	}
+
+	if c.state == CircuitOpen && b.now().Sub(c.openedAt) >= b.options.OpenTimeout {
+		c.state = CircuitHalfOpen
+		c.probes = 0
+		c.successes = 0
+	}
+
+	switch c.state {
+	case CircuitOpen:
+		return false, ErrCircuitOpen
+	case CircuitHalfOpen:
+		if c.probes >= b.options.HalfOpenProbes {
+			return false, ErrCircuitOpen
+		}
+		c.probes++
+		return true, nil
+	}
+	return false, nil
+}
+
+func (b *CircuitBreaker) record(host string, probe bool, failed bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	c, found := b.circuits[host]
+	if !found {
+		// 请求期间被 Reset 了
+		return
+	}
+
+	if probe {
+		// 探测期间状态可能已经被其它探测请求改变
+		if c.state != CircuitHalfOpen {
+			return
+		}
+		if failed {
+			b.open(c)
+			return
+		}
+		c.successes++
+		if c.successes >= b.options.HalfOpenProbes {
+			*c = circuit{}
+		}
+		return
+	}
+
+	if c.state != CircuitClosed {
+		return
+	}
+	if !failed {
+		c.failures = 0
+		return
+	}
+	c.failures++
+	if c.failures >= b.options.FailureThreshold {
+		b.open(c)
+	}
+}
+
+func (b *CircuitBreaker) open(c *circuit) {
+	c.state = CircuitOpen
+	c.openedAt = b.now()
+	c.failures = 0
+	c.probes = 0
+	c.successes = 0
+}
@@ -0,0 +1,101 @@
+package helper
+
+import (
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestCircuitBreaker_HalfOpen(t *testing.T) {
+	var failing int32 = 1
+	var attempts int32
+	helper := newTestRequestHelper(t, func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&attempts, 1)
+		if atomic.LoadInt32(&failing) == 1 {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	})
+
+	now := time.Now()
+	breaker := NewCircuitBreaker(&CircuitBreakerOptions{
+		FailureThreshold: 2,
+		OpenTimeout:      time.Minute,
+	})
+	breaker.now = func() time.Time { return now }
+	helper.WithMiddleware(breaker.Middleware())
+
+	for i := 0; i < 2; i++ {
+		response, err := helper.Df().Method(http.MethodGet).Request()
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusInternalServerError, response.StatusCode)
+	}
+
+	// the circuit is open, the request is not sent
+	_, err := helper.Df().Method(http.MethodGet).Request()
+	assert.True(t, errors.Is(err, ErrCircuitOpen))
+	assert.Equal(t, int32(2), atomic.LoadInt32(&attempts))
+
+	// a failed probe opens the circuit again
+	now = now.Add(time.Minute)
+	_, err = helper.Df().Method(http.MethodGet).Request()
+	assert.NoError(t, err)
+	_, err = helper.Df().Method(http.MethodGet).Request()
+	assert.True(t, errors.Is(err, ErrCircuitOpen))
+	assert.Equal(t, int32(3), atomic.LoadInt32(&attempts))
+
+	// a successful probe closes it
+	atomic.StoreInt32(&failing, 0)
+	now = now.Add(time.Minute)
+	response, err := helper.Df().Method(http.MethodGet).Request()
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, response.StatusCode)
+	response, err = helper.Df().Method(http.MethodGet).Request()
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, response.StatusCode)
+}
+
+func TestCircuitBreaker_PerHost(t *testing.T) {
+	breaker := NewCircuitBreaker(&CircuitBreakerOptions{FailureThreshold: 1})
+	handle := breaker.Middleware()(func(request *http.Request) (*http.Response, error) {
+		if request.URL.Host == "down.example.com" {
+			return nil, errors.New("connection refused")
+		}
+		return &http.Response{StatusCode: http.StatusOK}, nil
+	})
+
+	down, _ := http.NewRequest(http.MethodGet, "http://down.example.com", nil)
+	up, _ := http.NewRequest(http.MethodGet, "http://up.example.com", nil)
+
+	_, err := handle(down)
+	assert.Error(t, err)
+	assert.Equal(t, CircuitOpen, breaker.State("down.example.com"))
+
+	_, err = handle(up)
+	assert.NoError(t, err)
+	assert.Equal(t, CircuitClosed, breaker.State("up.example.com"))
+
+	breaker.Reset("down.example.com")
+	assert.Equal(t, CircuitClosed, breaker.State("down.example.com"))
+}
+
+func TestRetryMiddleware_CircuitBreaker(t *testing.T) {
+	var attempts int32
+	helper := newTestRequestHelper(t, func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&attempts, 1)
+		w.WriteHeader(http.StatusServiceUnavailable)
+	})
+	helper.WithMiddleware(
+		RetryMiddleware(&RetryOptions{MaxAttempts: 5, BaseDelay: time.Millisecond}),
+		CircuitBreakerMiddleware(&CircuitBreakerOptions{FailureThreshold: 2}),
+	)
+
+	// the retries stop as soon as the circuit opens
+	_, err := helper.Df().Method(http.MethodGet).Request()
+	assert.True(t, errors.Is(err, ErrCircuitOpen))
+	assert.Equal(t, int32(2), atomic.LoadInt32(&attempts))
+}
@@ -0,0 +1,411 @@
+package helper
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dadiYazZ/xin-da-libs/cache"
+	"github.com/dadiYazZ/xin-da-libs/http/contract"
+)
+
+const (
+	DEFAULT_CACHE_KEY_PREFIX    = "http_cache:"
+	DEFAULT_CACHE_MAX_BODY_SIZE = 1 << 20
+	// DEFAULT_CACHE_STALE_TTL 是过期但带有 ETag 或 Last-Modified 的响应保留的时间, 用于条件请求
+	DEFAULT_CACHE_STALE_TTL = 24 * time.Hour
+
+	// CACHE_STATUS_HEADER 响应头标记响应的来源
+	CACHE_STATUS_HEADER = "X-Cache"
+	CACHE_HIT           = "HIT"
+	CACHE_MISS          = "MISS"
+	// CACHE_REVALIDATED 过期的响应经过条件请求确认未修改
+	CACHE_REVALIDATED = "REVALIDATED"
+)
+
+// cacheableStatuses 是可以缓存的响应状态码
+var cacheableStatuses = map[int]bool{
+	http.StatusOK:                   true,
+	http.StatusNonAuthoritativeInfo: true,
+	http.StatusNoContent:            true,
+	http.StatusMultipleChoices:      true,
+	http.StatusMovedPermanently:     true,
+	http.StatusNotFound:             true,
+	http.StatusGone:                 true,
+}
+
+type CacheOptions struct {
+	// Cache 必填, 保存响应的缓存
+	Cache cache.CacheInterface
+	// KeyPrefix 缓存 key 的前缀, 默认为 DEFAULT_CACHE_KEY_PREFIX
+	KeyPrefix string
+	// MaxBodySize 超过该长度的响应不缓存, 默认为 DEFAULT_CACHE_MAX_BODY_SIZE
+	MaxBodySize int64
+	// StaleTTL 默认为 DEFAULT_CACHE_STALE_TTL
+	StaleTTL time.Duration
+	// Routes 为匹配的请求强制设置缓存时间, 忽略响应的 Cache-Control 和 Expires
+	Routes []CacheRoute
+}
+
+// CacheRoute 匹配请求的 host 和 path, 为空时匹配所有
+type CacheRoute struct {
+	Host string
+	// Path 以 * 结尾时按前缀匹配
+	Path string
+	TTL  time.Duration
+}
+
+func (route *CacheRoute) match(request *http.Request) bool {
+	if route.Host != "" && !strings.EqualFold(route.Host, request.URL.Host) {
+		return false
+	}
+	if prefix, ok := strings.CutSuffix(route.Path, "*"); ok {
+		return strings.HasPrefix(request.URL.Path, prefix)
+	}
+	return route.Path == "" || route.Path == request.URL.Path
+}
+
+// cachedResponse 是保存在缓存中的响应
+type cachedResponse struct {
+	StatusCode int
+	Header     http.Header
+	Body       []byte
+	// Vary 是响应的 Vary 请求头在原请求中的值
+	Vary map[string]string
+	// ResponseTime 收到响应的时间, Expires 之前响应是新鲜的
+	ResponseTime time.Time
+	Expires      time.Time
+}
+
+// ResponseCache 按 RFC 7234 缓存 GET 和 HEAD 请求的响应, 作为私有缓存不区分 private 和 s-maxage.
+// 新鲜的响应直接返回, 过期的响应带有 ETag 或 Last-Modified 时发出条件请求, 收到 304 后返回缓存的响应.
+// 其它方法的请求成功后会删除相同 Url 的缓存
+type ResponseCache struct {
+	options CacheOptions
+	now     func() time.Time
+}
+
+// NewResponseCache 在 options.Cache 为 nil 时 panic
+func NewResponseCache(options *CacheOptions) *ResponseCache {
+	opts := CacheOptions{}
+	if options != nil {
+		opts = *options
+	}
+	if opts.Cache == nil {
+		panic("response cache: nil cache")
+	}
+	if opts.KeyPrefix == "" {
+		opts.KeyPrefix = DEFAULT_CACHE_KEY_PREFIX
+	}
+	if opts.MaxBodySize <= 0 {
+		opts.MaxBodySize = DEFAULT_CACHE_MAX_BODY_SIZE
+	}
+	if opts.StaleTTL <= 0 {
+		opts.StaleTTL = DEFAULT_CACHE_STALE_TTL
+	}
+	return &ResponseCache{
+		options: opts,
+		now:     time.Now,
+	}
+}
+
+// CacheMiddleware 创建 ResponseCache 并返回它的中间件
+func CacheMiddleware(options *CacheOptions) contract.RequestMiddleware {
+	return NewResponseCache(options).Middleware()
+}
+
+func (c *ResponseCache) Middleware() contract.RequestMiddleware {
+	return func(handle contract.RequestHandle) contract.RequestHandle {
+		return func(request *http.Request) (*http.Response, error) {
+			if request.Method != http.MethodGet && request.Method != http.MethodHead {
+				return c.invalidate(handle, request)
+			}
+			return c.roundTrip(handle, request)
+		}
+	}
+}
+
+// Invalidate 删除请求对应的缓存
+func (c *ResponseCache) Invalidate(request *http.Request) error {
+	err := c.options.Cache.Delete(c.key(request.Method, request))
+	if err != nil {
+		return err
+	}
+	if request.Method == http.MethodGet {
+		return c.options.Cache.Delete(c.key(http.MethodHead, request))
+	}
+	return nil
+}
+
+func (c *ResponseCache) roundTrip(handle contract.RequestHandle, request *http.Request) (*http.Response, error) {
+	requestDirectives := parseCacheControl(request.Header)
+	_, noStore := requestDirectives["no-store"]
+	// 调用方自己发出的条件请求不使用缓存
+	if noStore || request.Header.Get("If-None-Match") != "" || request.Header.Get("If-Modified-Since") != "" || request.Header.Get("Range") != "" {
+		return handle(request)
+	}
+
+	ttl, forced := c.routeTTL(request)
+	key := c.key(request.Method, request)
+
+	cached, err := cache.Get[cachedResponse](c.options.Cache, key)
+	if err != nil || !cached.matchVary(request) {
+		response, err := handle(request)
+		return c.store(request, response, err)
+	}
+
+	_, noCache := requestDirectives["no-cache"]
+	now := c.now()
+	if !noCache && now.Before(cached.Expires) {
+		return cached.toHttpResponse(request, now, CACHE_HIT), nil
+	}
+
+	etag := cached.Header.Get("ETag")
+	lastModified := cached.Header.Get("Last-Modified")
+	if etag == "" && lastModified == "" {
+		response, err := handle(request)
+		return c.store(request, response, err)
+	}
+
+	conditional := request.Clone(request.Context())
+	if etag != "" {
+		conditional.Header.Set("If-None-Match", etag)
+	}
+	if lastModified != "" {
+		conditional.Header.Set("If-Modified-Since", lastModified)
+	}
+	response, err := handle(conditional)
+	if err != nil || response.StatusCode != http.StatusNotModified {
+		return c.store(request, response, err)
+	}
+	DrainBody(response)
+
+	// 使用 304 响应的响应头更新缓存的响应
+	if cached.Header == nil {
+		cached.Header = http.Header{}
+	}
+	for k, values := range response.Header {
+		if k == "Content-Length" {
+			continue
+		}
+		cached.Header[k] = values
+	}
+	now = c.now()
+	cached.ResponseTime = now
+	cached.Expires = now.Add(freshness(cached.Header, now, ttl, forced))
+	c.save(key, &cached)
+
+	return cached.toHttpResponse(request, now, CACHE_REVALIDATED), nil
+}
+
+// store 缓存可以缓存的响应, 并返回一个可以读取完整 body 的响应
+func (c *ResponseCache) store(request *http.Request, response *http.Response, err error) (*http.Response, error) {
+	if err != nil {
+		return response, err
+	}
+	ttl, forced := c.routeTTL(request)
+	if !c.storable(response, forced) {
+		return response, nil
+	}
+
+	body, readErr := io.ReadAll(io.LimitReader(response.Body, c.options.MaxBodySize+1))
+	if readErr != nil {
+		_ = response.Body.Close()
+		return nil, readErr
+	}
+	if int64(len(body)) > c.options.MaxBodySize {
+		response.Body = struct {
+			io.Reader
+			io.Closer
+		}{io.MultiReader(bytes.NewReader(body), response.Body), response.Body}
+		return response, nil
+	}
+	_ = response.Body.Close()
+	response.Body = io.NopCloser(bytes.NewReader(body))
+
+	now := c.now()
+	cached := &cachedResponse{
+		StatusCode:   response.StatusCode,
+		Header:       response.Header.Clone(),
+		Body:         body,
+		Vary:         map[string]string{},
+		ResponseTime: now,
+	}
+	cached.Expires = now.Add(freshness(response.Header, now, ttl, forced))
+	for _, name := range varyHeaders(response.Header) {
+		cached.Vary[name] = request.Header.Get(name)
+	}
+	c.save(c.key(request.Method, request), cached)
+
+	response.Header.Set(CACHE_STATUS_HEADER, CACHE_MISS)
+	return response, nil
+}
+
+func (c *ResponseCache) storable(response *http.Response, forced bool) bool {
+	if !cacheableStatuses[response.StatusCode] {
+		return false
+	}
+	for _, name := range varyHeaders(response.Header) {
+		if name == "*" {
+			return false
+		}
+	}
+	if forced {
+		return true
+	}
+	if _, noStore := parseCacheControl(response.Header)["no-store"]; noStore {
+		return false
+	}
+	return true
+}
+
+// save 保存响应, 有 ETag 或 Last-Modified 的响应在过期后继续保留 StaleTTL 用于条件请求.
+// 既不新鲜也不能验证的响应不保存, 缓存出错时只是不使用缓存
+func (c *ResponseCache) save(key string, cached *cachedResponse) {
+	ttl := cached.Expires.Sub(cached.ResponseTime)
+	if cached.Header.Get("ETag") != "" || cached.Header.Get("Last-Modified") != "" {
+		ttl += c.options.StaleTTL
+	}
+	if ttl <= 0 {
+		_ = c.options.Cache.Delete(key)
+		return
+	}
+	_ = c.options.Cache.Set(key, cached, ttl)
+}
+
+func (c *ResponseCache) invalidate(handle contract.RequestHandle, request *http.Request) (*http.Response, error) {
+	response, err := handle(request)
+	if err == nil && response.StatusCode < http.StatusBadRequest {
+		get := request.Clone(request.Context())
+		get.Method = http.MethodGet
+		_ = c.Invalidate(get)
+	}
+	return response, err
+}
+
+func (c *ResponseCache) routeTTL(request *http.Request) (time.Duration, bool) {
+	for _, route := range c.options.Routes {
+		if route.match(request) {
+			return route.TTL, true
+		}
+	}
+	return 0, false
+}
+
+// key 使用 Url 和 Authorization 的摘要, 避免 query 中的密钥出现在缓存 key 中.
+// 不同凭证的请求分开缓存, 一个调用方的响应不会返回给另一个调用方, RFC 7234 3.2
+func (c *ResponseCache) key(method string, request *http.Request) string {
+	sum := sha256.Sum256([]byte(method + " " + request.URL.String() + "\n" + request.Header.Get("Authorization")))
+	return c.options.KeyPrefix + hex.EncodeToString(sum[:])
+}
+
+func (cached *cachedResponse) matchVary(request *http.Request) bool {
+	for name, value := range cached.Vary {
+		if request.Header.Get(name) != value {
+			return false
+		}
+	}
+	return true
+}
+
+func (cached *cachedResponse) toHttpResponse(request *http.Request, now time.Time, status string) *http.Response {
+	header := cached.Header.Clone()
+	if header == nil {
+		header = http.Header{}
+	}
+	age := int64(now.Sub(cached.ResponseTime) / time.Second)
+	header.Set("Age", strconv.FormatInt(age, 10))
+	header.Set(CACHE_STATUS_HEADER, status)
+
+	var body io.ReadCloser = http.NoBody
+	if request.Method != http.MethodHead && len(cached.Body) > 0 {
+		body = io.NopCloser(bytes.NewReader(cached.Body))
+	}
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", cached.StatusCode, http.StatusText(cached.StatusCode)),
+		StatusCode:    cached.StatusCode,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        header,
+		Body:          body,
+		ContentLength: int64(len(cached.Body)),
+		Request:       request,
+	}
+}
+
+// freshness 返回响应从 now 开始保持新鲜的时间, 强制的 ttl 优先, 然后是 max-age, 最后是 Expires 减去 Date.
+// 响应的 Age 会从中扣除, no-cache 的响应每次都需要验证
+func freshness(header http.Header, now time.Time, ttl time.Duration, forced bool) time.Duration {
+	if forced {
+		return ttl
+	}
+
+	directives := parseCacheControl(header)
+	if _, noCache := directives["no-cache"]; noCache {
+		return 0
+	}
+
+	var lifetime time.Duration
+	if maxAge, ok := directives["max-age"]; ok {
+		seconds, err := strconv.ParseInt(maxAge, 10, 64)
+		if err != nil {
+			return 0
+		}
+		lifetime = time.Duration(seconds) * time.Second
+	} else if expires := header.Get("Expires"); expires != "" {
+		expiresAt, err := http.ParseTime(expires)
+		if err != nil {
+			return 0
+		}
+		date := now
+		if d, err := http.ParseTime(header.Get("Date")); err == nil {
+			date = d
+		}
+		lifetime = expiresAt.Sub(date)
+	} else {
+		return 0
+	}
+
+	if age, err := strconv.ParseInt(header.Get("Age"), 10, 64); err == nil && age > 0 {
+		lifetime -= time.Duration(age) * time.Second
+	}
+	if lifetime < 0 {
+		return 0
+	}
+	return lifetime
+}
+
+// parseCacheControl 解析 Cache-Control, 指令名转换为小写, 没有值的指令值为空字符串
+func parseCacheControl(header http.Header) map[string]string {
+	directives := map[string]string{}
+	for _, line := range header.Values("Cache-Control") {
+		for _, part := range strings.Split(line, ",") {
+			part = strings.TrimSpace(part)
+			if part == "" {
+				continue
+			}
+			name, value, _ := strings.Cut(part, "=")
+			directives[strings.ToLower(strings.TrimSpace(name))] = strings.Trim(strings.TrimSpace(value), `"`)
+		}
+	}
+	return directives
+}
+
+func varyHeaders(header http.Header) []string {
+	var names []string
+	for _, line := range header.Values("Vary") {
+		for _, name := range strings.Split(line, ",") {
+			if name = strings.TrimSpace(name); name != "" {
+				names = append(names, http.CanonicalHeaderKey(name))
+			}
+		}
+	}
+	return names
+}
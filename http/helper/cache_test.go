@@ -0,0 +1,191 @@
+package helper
+
+import (
+	"github.com/dadiYazZ/xin-da-libs/cache"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"net/http"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func newTestResponseCache(t *testing.T, options *CacheOptions) (*ResponseCache, *time.Time) {
+	memCache, err := cache.NewMemCacheWithOptions(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if options == nil {
+		options = &CacheOptions{}
+	}
+	options.Cache = memCache
+	responseCache := NewResponseCache(options)
+	now := time.Now()
+	responseCache.now = func() time.Time { return now }
+	return responseCache, &now
+}
+
+func getCached(t *testing.T, helper *RequestHelper, uri string) (string, string) {
+	response, err := helper.Df().Method(http.MethodGet).Uri(uri).Request()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer response.Body.Close()
+	body, _ := io.ReadAll(response.Body)
+	return string(body), response.Header.Get(CACHE_STATUS_HEADER)
+}
+
+func TestResponseCache_MaxAge(t *testing.T) {
+	var calls int32
+	helper := newTestRequestHelper(t, func(w http.ResponseWriter, r *http.Request) {
+		n := atomic.AddInt32(&calls, 1)
+		w.Header().Set("Cache-Control", "max-age=60")
+		_, _ = w.Write([]byte(strconv.Itoa(int(n))))
+	})
+	responseCache, now := newTestResponseCache(t, nil)
+	helper.WithMiddleware(responseCache.Middleware())
+
+	body, status := getCached(t, helper, "/config")
+	assert.Equal(t, "1", body)
+	assert.Equal(t, CACHE_MISS, status)
+
+	*now = now.Add(30 * time.Second)
+	body, status = getCached(t, helper, "/config")
+	assert.Equal(t, "1", body)
+	assert.Equal(t, CACHE_HIT, status)
+
+	// another url is cached separately
+	body, _ = getCached(t, helper, "/config?env=test")
+	assert.Equal(t, "2", body)
+
+	// without a validator the expired response is fetched again
+	*now = now.Add(time.Minute)
+	body, status = getCached(t, helper, "/config")
+	assert.Equal(t, "3", body)
+	assert.Equal(t, CACHE_MISS, status)
+
+	// a successful POST invalidates the url
+	_, err := helper.Df().Method(http.MethodPost).Uri("/config").Request()
+	assert.NoError(t, err)
+	body, _ = getCached(t, helper, "/config")
+	assert.Equal(t, "5", body)
+}
+
+func TestResponseCache_Revalidate(t *testing.T) {
+	var calls, notModified int32
+	helper := newTestRequestHelper(t, func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		w.Header().Set("ETag", `"v1"`)
+		w.Header().Set("Cache-Control", "no-cache")
+		if r.Header.Get("If-None-Match") == `"v1"` {
+			atomic.AddInt32(&notModified, 1)
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		_, _ = w.Write([]byte("payload"))
+	})
+	responseCache, _ := newTestResponseCache(t, nil)
+	helper.WithMiddleware(responseCache.Middleware())
+
+	body, status := getCached(t, helper, "/token")
+	assert.Equal(t, "payload", body)
+	assert.Equal(t, CACHE_MISS, status)
+
+	body, status = getCached(t, helper, "/token")
+	assert.Equal(t, "payload", body)
+	assert.Equal(t, CACHE_REVALIDATED, status)
+	assert.Equal(t, int32(2), atomic.LoadInt32(&calls))
+	assert.Equal(t, int32(1), atomic.LoadInt32(&notModified))
+}
+
+func TestResponseCache_NoStore(t *testing.T) {
+	var calls int32
+	helper := newTestRequestHelper(t, func(w http.ResponseWriter, r *http.Request) {
+		n := atomic.AddInt32(&calls, 1)
+		w.Header().Set("Cache-Control", "no-store")
+		_, _ = w.Write([]byte(strconv.Itoa(int(n))))
+	})
+	responseCache, now := newTestResponseCache(t, &CacheOptions{
+		Routes: []CacheRoute{{Path: "/forced/*", TTL: time.Minute}},
+	})
+	helper.WithMiddleware(responseCache.Middleware())
+
+	body, _ := getCached(t, helper, "/plain")
+	assert.Equal(t, "1", body)
+	body, _ = getCached(t, helper, "/plain")
+	assert.Equal(t, "2", body)
+
+	// the route ttl overrides the response
+	body, _ = getCached(t, helper, "/forced/config")
+	assert.Equal(t, "3", body)
+	body, status := getCached(t, helper, "/forced/config")
+	assert.Equal(t, "3", body)
+	assert.Equal(t, CACHE_HIT, status)
+
+	*now = now.Add(2 * time.Minute)
+	body, _ = getCached(t, helper, "/forced/config")
+	assert.Equal(t, "4", body)
+}
+
+func TestResponseCache_Vary(t *testing.T) {
+	var calls int32
+	helper := newTestRequestHelper(t, func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		w.Header().Set("Cache-Control", "max-age=60")
+		w.Header().Set("Vary", "Accept-Language")
+		_, _ = w.Write([]byte(r.Header.Get("Accept-Language")))
+	})
+	responseCache, _ := newTestResponseCache(t, nil)
+	helper.WithMiddleware(responseCache.Middleware())
+
+	get := func(language string) string {
+		response, err := helper.Df().Method(http.MethodGet).Uri("/").Header("Accept-Language", language).Request()
+		assert.NoError(t, err)
+		body, _ := io.ReadAll(response.Body)
+		return string(body)
+	}
+	assert.Equal(t, "en", get("en"))
+	assert.Equal(t, "en", get("en"))
+	assert.Equal(t, "zh", get("zh"))
+	assert.Equal(t, int32(2), atomic.LoadInt32(&calls))
+}
+
+func TestResponseCache_Authorization(t *testing.T) {
+	var calls int32
+	helper := newTestRequestHelper(t, func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		w.Header().Set("Cache-Control", "max-age=60")
+		_, _ = w.Write([]byte(r.Header.Get("Authorization")))
+	})
+	responseCache, _ := newTestResponseCache(t, nil)
+	helper.WithMiddleware(responseCache.Middleware())
+
+	get := func(authorization string) string {
+		response, err := helper.Df().Method(http.MethodGet).Uri("/me").Header("Authorization", authorization).Request()
+		assert.NoError(t, err)
+		body, _ := io.ReadAll(response.Body)
+		return string(body)
+	}
+	// responses are cached per credential and never served to another caller
+	assert.Equal(t, "Bearer a", get("Bearer a"))
+	assert.Equal(t, "Bearer b", get("Bearer b"))
+	assert.Equal(t, "Bearer a", get("Bearer a"))
+	assert.Equal(t, int32(2), atomic.LoadInt32(&calls))
+}
+
+func TestFreshness(t *testing.T) {
+	now := time.Now()
+	header := http.Header{}
+	header.Set("Date", now.Add(-10*time.Second).UTC().Format(http.TimeFormat))
+	header.Set("Expires", now.Add(50*time.Second).UTC().Format(http.TimeFormat))
+	assert.Equal(t, time.Minute, freshness(header, now, 0, false))
+
+	header.Set("Cache-Control", `public, max-age="120"`)
+	header.Set("Age", "20")
+	assert.Equal(t, 100*time.Second, freshness(header, now, 0, false))
+
+	header.Set("Cache-Control", "max-age=120, no-cache")
+	assert.Equal(t, time.Duration(0), freshness(header, now, 0, false))
+	assert.Equal(t, time.Hour, freshness(header, now, time.Hour, true))
+}
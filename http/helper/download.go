@@ -0,0 +1,95 @@
+package helper
+
+import (
+	"context"
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"hash"
+	"io"
+	http2 "net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/dadiYazZ/xin-da-libs/http/dataflow"
+	"github.com/pkg/errors"
+)
+
+// ErrHashMismatch 下载内容的摘要与 HashValue 不一致
+var ErrHashMismatch = errors.New("downloaded content hash mismatch")
+
+// newDownloadHash 根据 HashType 创建摘要算法, HashType 为空时不校验
+func newDownloadHash(hashType string) (hash.Hash, error) {
+	switch strings.ReplaceAll(strings.ToLower(hashType), "-", "") {
+	case "":
+		return nil, nil
+	case "md5":
+		return md5.New(), nil
+	case "sha1":
+		return sha1.New(), nil
+	case "sha256":
+		return sha256.New(), nil
+	case "sha512":
+		return sha512.New(), nil
+	}
+	return nil, errors.Errorf("unsupported hash type %s", hashType)
+}
+
+// Download 以流的方式把 DownloadURL 的内容写入 writer, 不会将整个文件读入内存.
+// 设置了 HashType 时, 下载完成后用十六进制的 HashValue 校验内容, 不一致时返回 ErrHashMismatch,
+// 此时内容已经写入了 writer, 由调用方丢弃. 非 2xx 响应返回 *dataflow.HttpError
+func (r *RequestHelper) Download(ctx context.Context, download *RequestDownload, writer io.Writer) (written int64, err error) {
+	digest, err := newDownloadHash(download.HashType)
+	if err != nil {
+		return 0, err
+	}
+
+	response, err := r.Df().WithContext(ctx).Method(http2.MethodGet).Url(download.DownloadURL).Request()
+	if err != nil {
+		return 0, err
+	}
+	defer response.Body.Close()
+
+	if !dataflow.IsSuccess(response.StatusCode) {
+		return 0, dataflow.NewHttpError(nil, response, nil)
+	}
+
+	if digest != nil {
+		writer = io.MultiWriter(writer, digest)
+	}
+	written, err = io.Copy(writer, response.Body)
+	if err != nil {
+		return written, errors.Wrap(err, "download failed")
+	}
+
+	if digest != nil && !strings.EqualFold(hex.EncodeToString(digest.Sum(nil)), download.HashValue) {
+		return written, ErrHashMismatch
+	}
+	return written, nil
+}
+
+// DownloadFile 先下载到同目录的临时文件, 校验通过后再重命名为 filePath, 失败时不会留下不完整的文件
+func (r *RequestHelper) DownloadFile(ctx context.Context, download *RequestDownload, filePath string) (written int64, err error) {
+	file, err := os.CreateTemp(filepath.Dir(filePath), filepath.Base(filePath)+".*.tmp")
+	if err != nil {
+		return 0, err
+	}
+	defer func() {
+		if err != nil {
+			_ = file.Close()
+			_ = os.Remove(file.Name())
+		}
+	}()
+
+	written, err = r.Download(ctx, download, file)
+	if err != nil {
+		return written, err
+	}
+	if err = file.Close(); err != nil {
+		return written, err
+	}
+	return written, os.Rename(file.Name(), filePath)
+}
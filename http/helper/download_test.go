@@ -0,0 +1,86 @@
+package helper
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"github.com/dadiYazZ/xin-da-libs/http/dataflow"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRequestHelper_Download(t *testing.T) {
+	content := strings.Repeat("download content ", 1000)
+	sum := sha256.Sum256([]byte(content))
+
+	helper := newTestRequestHelper(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/file" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		_, _ = w.Write([]byte(content))
+	})
+	downloadURL := helper.config.BaseUrl + "/file"
+
+	var buf bytes.Buffer
+	written, err := helper.Download(context.Background(), &RequestDownload{
+		HashType:    "SHA256",
+		HashValue:   hex.EncodeToString(sum[:]),
+		DownloadURL: downloadURL,
+	}, &buf)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(len(content)), written)
+	assert.Equal(t, content, buf.String())
+
+	_, err = helper.Download(context.Background(), &RequestDownload{
+		HashType:    "md5",
+		HashValue:   "00000000000000000000000000000000",
+		DownloadURL: downloadURL,
+	}, &bytes.Buffer{})
+	assert.Equal(t, ErrHashMismatch, err)
+
+	_, err = helper.Download(context.Background(), &RequestDownload{
+		HashType:    "crc32",
+		DownloadURL: downloadURL,
+	}, &bytes.Buffer{})
+	assert.Error(t, err)
+
+	_, err = helper.Download(context.Background(), &RequestDownload{
+		DownloadURL: helper.config.BaseUrl + "/missing",
+	}, &bytes.Buffer{})
+	var httpErr *dataflow.HttpError
+	assert.True(t, errors.As(err, &httpErr))
+	assert.Equal(t, http.StatusNotFound, httpErr.StatusCode)
+}
+
+func TestRequestHelper_DownloadFile(t *testing.T) {
+	helper := newTestRequestHelper(t, func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("content"))
+	})
+	dir := t.TempDir()
+
+	filePath := filepath.Join(dir, "file.txt")
+	_, err := helper.DownloadFile(context.Background(), &RequestDownload{
+		DownloadURL: helper.config.BaseUrl,
+	}, filePath)
+	assert.NoError(t, err)
+	b, err := os.ReadFile(filePath)
+	assert.NoError(t, err)
+	assert.Equal(t, "content", string(b))
+
+	// a mismatching download leaves no file behind
+	_, err = helper.DownloadFile(context.Background(), &RequestDownload{
+		HashType:    "sha1",
+		HashValue:   "0000",
+		DownloadURL: helper.config.BaseUrl,
+	}, filepath.Join(dir, "corrupted.txt"))
+	assert.Equal(t, ErrHashMismatch, err)
+	entries, _ := os.ReadDir(dir)
+	assert.Len(t, entries, 1)
+}
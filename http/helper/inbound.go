@@ -0,0 +1,247 @@
+package helper
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/dadiYazZ/xin-da-libs/http/dataflow"
+	"github.com/dadiYazZ/xin-da-libs/object"
+	"github.com/pkg/errors"
+)
+
+const (
+	DEFAULT_BIND_MAX_BODY_SIZE   = 10 << 20
+	DEFAULT_MULTIPART_MAX_MEMORY = 32 << 20
+)
+
+var (
+	// ErrBodyTooLarge 请求 body 超过了 BindOptions.MaxBodySize
+	ErrBodyTooLarge = errors.New("request body too large")
+	// ErrUnsupportedMediaType 请求的 Content-Type 不是 Json, Xml, 表单或 multipart
+	ErrUnsupportedMediaType = errors.New("unsupported media type")
+)
+
+// ValidationError 是请求缺少必填字段时返回的错误
+type ValidationError struct {
+	Err error
+}
+
+func (e *ValidationError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *ValidationError) Unwrap() error {
+	return e.Err
+}
+
+type BindOptions struct {
+	// MaxBodySize 请求 body 的长度上限, 默认为 DEFAULT_BIND_MAX_BODY_SIZE
+	MaxBodySize int64
+	// MaxMemory multipart 请求保存在内存中的上限, 超过的文件写入临时文件, 默认为 DEFAULT_MULTIPART_MAX_MEMORY
+	MaxMemory int64
+	// Required 绑定后必须存在的字段, 按 json 字段名匹配, 支持 a.b 形式的嵌套字段
+	Required []string
+}
+
+func (o *BindOptions) withDefaults() BindOptions {
+	options := BindOptions{}
+	if o != nil {
+		options = *o
+	}
+	if options.MaxBodySize <= 0 {
+		options.MaxBodySize = DEFAULT_BIND_MAX_BODY_SIZE
+	}
+	if options.MaxMemory <= 0 {
+		options.MaxMemory = DEFAULT_MULTIPART_MAX_MEMORY
+	}
+	return options
+}
+
+// BindRequest 按 Content-Type 将请求 body 解码到 result, 没有 Content-Type 时按 Json 解码.
+// result 可以是结构体, object.HashMap 或 object.StringMap 的指针, 表单和 multipart 的每个字段取第一个值,
+// multipart 的文件可以通过 request.MultipartForm 读取, result 为 *multipart.Form 时直接返回整个表单.
+// 解码后检查 BindOptions.Required 中的字段, 缺少时返回 ValidationError
+func BindRequest(request *http.Request, result interface{}, options *BindOptions) error {
+	opts := options.withDefaults()
+
+	err := bindBody(request, result, &opts)
+	if err != nil {
+		return err
+	}
+	if len(opts.Required) == 0 {
+		return nil
+	}
+	return ValidateRequired(result, opts.Required...)
+}
+
+// BindRequestAs 将请求 body 解码为 T
+func BindRequestAs[T any](request *http.Request, options *BindOptions) (*T, error) {
+	result := new(T)
+	err := BindRequest(request, result, options)
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+func bindBody(request *http.Request, result interface{}, opts *BindOptions) error {
+	if request.Body == nil || request.Body == http.NoBody {
+		return nil
+	}
+	request.Body = http.MaxBytesReader(nil, request.Body, opts.MaxBodySize)
+
+	mediaType := "application/json"
+	if contentType := request.Header.Get("Content-Type"); contentType != "" {
+		var err error
+		mediaType, _, err = mime.ParseMediaType(contentType)
+		if err != nil {
+			return errors.Wrap(ErrUnsupportedMediaType, contentType)
+		}
+	}
+
+	switch {
+	case mediaType == "multipart/form-data":
+		return bindMultipart(request, result, opts)
+	case mediaType == "application/json" || strings.HasSuffix(mediaType, "+json"),
+		mediaType == "application/xml" || mediaType == "text/xml" || strings.HasSuffix(mediaType, "+xml"),
+		mediaType == "application/x-www-form-urlencoded":
+	default:
+		return errors.Wrap(ErrUnsupportedMediaType, mediaType)
+	}
+
+	body, err := io.ReadAll(request.Body)
+	if err != nil {
+		return bindError(err)
+	}
+	if len(body) == 0 {
+		return nil
+	}
+	err = dataflow.DecodeBody(mediaType, body, result)
+	if err != nil {
+		return errors.Wrap(err, "decode request failed")
+	}
+	return nil
+}
+
+func bindMultipart(request *http.Request, result interface{}, opts *BindOptions) error {
+	err := request.ParseMultipartForm(opts.MaxMemory)
+	if err != nil {
+		return bindError(err)
+	}
+	if form, ok := result.(*multipart.Form); ok {
+		*form = *request.MultipartForm
+		return nil
+	}
+
+	body := url.Values(request.MultipartForm.Value).Encode()
+	err = dataflow.DecodeBody("application/x-www-form-urlencoded", []byte(body), result)
+	if err != nil {
+		return errors.Wrap(err, "decode request failed")
+	}
+	return nil
+}
+
+func bindError(err error) error {
+	var maxBytesErr *http.MaxBytesError
+	if errors.As(err, &maxBytesErr) {
+		return errors.Wrapf(ErrBodyTooLarge, "limit %d bytes", maxBytesErr.Limit)
+	}
+	return errors.Wrap(err, "read request failed")
+}
+
+// ValidateRequired 通过 object.Attribute.CheckRequiredAttributes 检查必填字段, 结构体按 json 字段名检查.
+// 空字符串视为缺少, 使结构体中没有赋值的字符串字段也能被检查
+func ValidateRequired(data interface{}, required ...string) error {
+	// 通过 Json 转换为副本, 不修改 data
+	attributes, err := object.StructToHashMap(data)
+	if err != nil {
+		return errors.Wrap(err, "validate request failed")
+	}
+	dropEmpty(*attributes)
+	(*attributes)["required"] = required
+
+	err = object.NewAttribute(attributes).CheckRequiredAttributes()
+	if err != nil {
+		return &ValidationError{Err: err}
+	}
+	return nil
+}
+
+// dropEmpty 递归删除值为 nil 或空字符串的字段
+func dropEmpty(m map[string]interface{}) {
+	for key, item := range m {
+		switch v := item.(type) {
+		case nil:
+			delete(m, key)
+		case string:
+			if v == "" {
+				delete(m, key)
+			}
+		case map[string]interface{}:
+			dropEmpty(v)
+		}
+	}
+}
+
+// BindErrorStatus 返回 BindRequest 的错误对应的响应状态码
+func BindErrorStatus(err error) int {
+	var validationErr *ValidationError
+	switch {
+	case err == nil:
+		return http.StatusOK
+	case errors.Is(err, ErrBodyTooLarge):
+		return http.StatusRequestEntityTooLarge
+	case errors.Is(err, ErrUnsupportedMediaType):
+		return http.StatusUnsupportedMediaType
+	case errors.As(err, &validationErr):
+		return http.StatusUnprocessableEntity
+	default:
+		return http.StatusBadRequest
+	}
+}
+
+// HttpResponseJson 以 Json 格式写入响应, writer 上已经设置的响应头会保留
+func HttpResponseJson(writer http.ResponseWriter, statusCode int, data interface{}) error {
+	body, err := json.Marshal(data)
+	if err != nil {
+		return errors.Wrap(err, "encode response failed")
+	}
+	return writeResponse(writer, statusCode, "application/json; charset=utf-8", body)
+}
+
+// HttpResponseXml 以 Xml 格式写入响应, object.HashMap 和 object.StringMap 使用 <xml> 作为根节点
+func HttpResponseXml(writer http.ResponseWriter, statusCode int, data interface{}) error {
+	var body []byte
+	switch v := data.(type) {
+	case *object.HashMap:
+		body = []byte(object.Map2Xml(v, false))
+	case object.HashMap:
+		body = []byte(object.Map2Xml(&v, false))
+	case *object.StringMap:
+		body = []byte(object.StringMap2Xml(v))
+	case object.StringMap:
+		body = []byte(object.StringMap2Xml(&v))
+	default:
+		b, err := xml.Marshal(data)
+		if err != nil {
+			return errors.Wrap(err, "encode response failed")
+		}
+		body = b
+	}
+	return writeResponse(writer, statusCode, "application/xml; charset=utf-8", body)
+}
+
+func writeResponse(writer http.ResponseWriter, statusCode int, contentType string, body []byte) error {
+	if writer.Header().Get("Content-Type") == "" {
+		writer.Header().Set("Content-Type", contentType)
+	}
+	writer.WriteHeader(statusCode)
+	_, err := writer.Write(body)
+	return err
+}
@@ -0,0 +1,208 @@
+package helper
+
+import (
+	"bytes"
+	"errors"
+	"github.com/dadiYazZ/xin-da-libs/object"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+type testCallback struct {
+	OrderId string `json:"order_id" xml:"order_id"`
+	Amount  int    `json:"amount" xml:"amount"`
+	Payer   struct {
+		Name string `json:"name" xml:"name"`
+	} `json:"payer" xml:"payer"`
+}
+
+func newInboundRequest(contentType string, body string) *http.Request {
+	request := httptest.NewRequest(http.MethodPost, "/callback", strings.NewReader(body))
+	if contentType != "" {
+		request.Header.Set("Content-Type", contentType)
+	}
+	return request
+}
+
+func newMultipartRequest(t *testing.T, fields map[string]string) *http.Request {
+	body := &bytes.Buffer{}
+	writer := multipart.NewWriter(body)
+	for key, value := range fields {
+		assert.NoError(t, writer.WriteField(key, value))
+	}
+	assert.NoError(t, writer.Close())
+	return newInboundRequest(writer.FormDataContentType(), body.String())
+}
+
+func TestBindRequest_FormTypes(t *testing.T) {
+	type order struct {
+		Id      int64    `json:"id"`
+		Price   float64  `json:"price"`
+		Paid    bool     `json:"paid"`
+		Count   *int     `json:"count"`
+		Tags    []string `json:"tags"`
+		Items   []int    `json:"items"`
+		Version int      `json:"version,string"`
+	}
+	result, err := BindRequestAs[order](newInboundRequest("application/x-www-form-urlencoded",
+		"id=9007199254740993&price=1.5&paid=true&count=2&tags=a&tags=b&items=1&items=2&version=3&extra=x"), nil)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(9007199254740993), result.Id)
+	assert.Equal(t, 1.5, result.Price)
+	assert.True(t, result.Paid)
+	assert.Equal(t, 2, *result.Count)
+	assert.Equal(t, []string{"a", "b"}, result.Tags)
+	assert.Equal(t, []int{1, 2}, result.Items)
+	assert.Equal(t, 3, result.Version)
+
+	// a value which does not fit the field is a bad request
+	err = BindRequest(newInboundRequest("application/x-www-form-urlencoded", "amount=abc"), &testCallback{}, nil)
+	assert.Error(t, err)
+	assert.Equal(t, http.StatusBadRequest, BindErrorStatus(err))
+}
+
+func TestBindRequest(t *testing.T) {
+	requests := map[string]*http.Request{
+		"json":    newInboundRequest("application/json; charset=utf-8", `{"order_id":"1001","amount":100,"payer":{"name":"joe"}}`),
+		"default": newInboundRequest("", `{"order_id":"1001","amount":100,"payer":{"name":"joe"}}`),
+		"xml":     newInboundRequest("text/xml", `<xml><order_id>1001</order_id><amount>100</amount><payer><name>joe</name></payer></xml>`),
+		// form fields are bound by the json tags and converted to the types of the fields
+		"form":      newInboundRequest("application/x-www-form-urlencoded", "order_id=1001&amount=100&payer.name=joe"),
+		"multipart": newMultipartRequest(t, map[string]string{"order_id": "1001", "amount": "100", "payer.name": "joe"}),
+	}
+	for name, request := range requests {
+		t.Run(name, func(t *testing.T) {
+			callback, err := BindRequestAs[testCallback](request, &BindOptions{Required: []string{"order_id", "payer.name"}})
+			assert.NoError(t, err)
+			assert.Equal(t, "1001", callback.OrderId)
+			assert.Equal(t, 100, callback.Amount)
+			assert.Equal(t, "joe", callback.Payer.Name)
+		})
+	}
+
+	// the fields are kept as strings in a map
+	form := object.StringMap{}
+	err := BindRequest(newInboundRequest("application/x-www-form-urlencoded", "order_id=1001&sign=abc"), &form, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, object.StringMap{"order_id": "1001", "sign": "abc"}, form)
+
+	hashMap := object.HashMap{}
+	err = BindRequest(newInboundRequest("application/json", `{"order_id":"1001"}`), &hashMap, &BindOptions{Required: []string{"order_id"}})
+	assert.NoError(t, err)
+	assert.Equal(t, "1001", hashMap["order_id"])
+	_, hasRequired := hashMap["required"]
+	assert.False(t, hasRequired)
+}
+
+func TestBindRequest_Multipart(t *testing.T) {
+	buf := &bytes.Buffer{}
+	writer := multipart.NewWriter(buf)
+	_ = writer.WriteField("order_id", "1001")
+	part, _ := writer.CreateFormFile("receipt", "receipt.txt")
+	_, _ = part.Write([]byte("paid"))
+	_ = writer.Close()
+
+	request := newInboundRequest(writer.FormDataContentType(), buf.String())
+	result := object.StringMap{}
+	err := BindRequest(request, &result, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, "1001", result["order_id"])
+
+	file, err := request.MultipartForm.File["receipt"][0].Open()
+	assert.NoError(t, err)
+	content, _ := io.ReadAll(file)
+	assert.Equal(t, "paid", string(content))
+}
+
+func TestBindRequest_Errors(t *testing.T) {
+	err := BindRequest(newInboundRequest("application/json", `{"order_id":"1001","padding":"`+strings.Repeat("x", 100)+`"}`), &object.HashMap{}, &BindOptions{MaxBodySize: 64})
+	assert.True(t, errors.Is(err, ErrBodyTooLarge))
+	assert.Equal(t, http.StatusRequestEntityTooLarge, BindErrorStatus(err))
+
+	err = BindRequest(newInboundRequest("text/plain", "1001"), &object.HashMap{}, nil)
+	assert.True(t, errors.Is(err, ErrUnsupportedMediaType))
+	assert.Equal(t, http.StatusUnsupportedMediaType, BindErrorStatus(err))
+
+	err = BindRequest(newInboundRequest("application/json", `{"amount":100}`), &testCallback{}, &BindOptions{Required: []string{"order_id"}})
+	var validationErr *ValidationError
+	assert.True(t, errors.As(err, &validationErr))
+	assert.Equal(t, `"order_id" cannot be empty.`, err.Error())
+	assert.Equal(t, http.StatusUnprocessableEntity, BindErrorStatus(err))
+
+	err = BindRequest(newInboundRequest("application/json", `{"amount":`), &testCallback{}, nil)
+	assert.Equal(t, http.StatusBadRequest, BindErrorStatus(err))
+}
+
+func TestHttpResponseJsonXml(t *testing.T) {
+	recorder := httptest.NewRecorder()
+	recorder.Header().Set("X-Request-Id", "1")
+	err := HttpResponseJson(recorder, http.StatusCreated, map[string]string{"code": "SUCCESS"})
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusCreated, recorder.Code)
+	assert.Equal(t, "application/json; charset=utf-8", recorder.Header().Get("Content-Type"))
+	assert.Equal(t, "1", recorder.Header().Get("X-Request-Id"))
+	assert.Equal(t, `{"code":"SUCCESS"}`, recorder.Body.String())
+
+	recorder = httptest.NewRecorder()
+	err = HttpResponseXml(recorder, http.StatusOK, &object.StringMap{"return_code": "SUCCESS"})
+	assert.NoError(t, err)
+	assert.Equal(t, "application/xml; charset=utf-8", recorder.Header().Get("Content-Type"))
+	assert.Equal(t, `<xml><return_code><![CDATA[SUCCESS]]></return_code></xml>`, recorder.Body.String())
+
+	recorder = httptest.NewRecorder()
+	err = HttpResponseXml(recorder, http.StatusOK, &testCallback{OrderId: "1001"})
+	assert.NoError(t, err)
+	assert.Equal(t, `<testCallback><order_id>1001</order_id><amount>0</amount><payer><name></name></payer></testCallback>`, recorder.Body.String())
+}
+
+func TestHttpResponseSend(t *testing.T) {
+	helper := newTestRequestHelper(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Add("Set-Cookie", "a=1")
+		w.Header().Add("Set-Cookie", "b=2")
+		w.WriteHeader(http.StatusAccepted)
+		_, _ = w.Write([]byte(`{"code":"SUCCESS"}`))
+	})
+	response, err := helper.Df().Method(http.MethodGet).Uri("/").Request()
+	assert.NoError(t, err)
+
+	recorder := httptest.NewRecorder()
+	err = HttpResponseSend(response, recorder)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusAccepted, recorder.Code)
+	assert.Equal(t, "application/json", recorder.Header().Get("Content-Type"))
+	assert.Equal(t, []string{"a=1", "b=2"}, recorder.Header().Values("Set-Cookie"))
+	assert.Equal(t, `{"code":"SUCCESS"}`, recorder.Body.String())
+}
+
+func TestHttpResponseSend_HopByHop(t *testing.T) {
+	response := &http.Response{
+		StatusCode: http.StatusOK,
+		Header: http.Header{
+			"Content-Type":     {"text/plain"},
+			"Content-Length":   {"2"},
+			"Connection":       {"keep-alive, X-Upstream-Hop"},
+			"Keep-Alive":       {"timeout=5"},
+			"Upgrade":          {"h2c"},
+			"Trailer":          {"X-Checksum"},
+			"Proxy-Connection": {"keep-alive"},
+			"X-Upstream-Hop":   {"1"},
+			"X-Request-Id":     {"1"},
+		},
+		Body: io.NopCloser(strings.NewReader("ok")),
+	}
+
+	recorder := httptest.NewRecorder()
+	assert.NoError(t, HttpResponseSend(response, recorder))
+	assert.Equal(t, "ok", recorder.Body.String())
+	assert.Equal(t, "text/plain", recorder.Header().Get("Content-Type"))
+	assert.Equal(t, "1", recorder.Header().Get("X-Request-Id"))
+	for _, key := range []string{"Connection", "Keep-Alive", "Upgrade", "Trailer", "Proxy-Connection", "X-Upstream-Hop"} {
+		assert.Empty(t, recorder.Header().Values(key), key)
+	}
+}
@@ -0,0 +1,475 @@
+package helper
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/dadiYazZ/xin-da-libs/http/contract"
+	"github.com/dadiYazZ/xin-da-libs/http/dataflow"
+	"github.com/dadiYazZ/xin-da-libs/object"
+	"github.com/pkg/errors"
+)
+
+// Page 是一页的分页参数
+type Page struct {
+	// Number 是页码, Offset 是之前的页返回的 item 总数
+	Number int
+	Offset int
+	// Cursor 是 CursorStrategy 的游标, 或 LinkHeaderStrategy 的下一页 Url
+	Cursor string
+}
+
+// PageResponse 是一页的响应, Body 是解码后的 body, Json 中的数字解码为 json.Number
+type PageResponse struct {
+	Request *http.Request
+	Header  http.Header
+	Body    interface{}
+	// Items 是这一页的 item 数
+	Items int
+}
+
+// PageStrategy 决定每一页的请求参数和是否还有下一页
+type PageStrategy interface {
+	// First 返回第一页
+	First() *Page
+	// Apply 将 page 的分页参数设置到 dataflow
+	Apply(df contract.RequestDataflowInterface, page *Page)
+	// Next 根据 page 的响应返回下一页, 没有下一页时返回 nil
+	Next(page *Page, response *PageResponse) *Page
+}
+
+// PageNumberStrategy 按页码翻页, 返回的 item 数为 0 或少于 Size 时结束
+type PageNumberStrategy struct {
+	// Param 默认为 page, SizeParam 为空时不发送每页的数量
+	Param     string
+	SizeParam string
+	Size      int
+	// ZeroBased 页码从 0 开始, 默认从 1 开始
+	ZeroBased bool
+}
+
+func (s *PageNumberStrategy) First() *Page {
+	if s.ZeroBased {
+		return &Page{Number: 0}
+	}
+	return &Page{Number: 1}
+}
+
+func (s *PageNumberStrategy) Apply(df contract.RequestDataflowInterface, page *Page) {
+	param := s.Param
+	if param == "" {
+		param = "page"
+	}
+	df.Query(param, strconv.Itoa(page.Number))
+	if s.SizeParam != "" && s.Size > 0 {
+		df.Query(s.SizeParam, strconv.Itoa(s.Size))
+	}
+}
+
+func (s *PageNumberStrategy) Next(page *Page, response *PageResponse) *Page {
+	if response.Items == 0 || response.Items < s.Size {
+		return nil
+	}
+	return &Page{Number: page.Number + 1, Offset: page.Offset + response.Items}
+}
+
+// OffsetStrategy 按偏移量翻页, 返回的 item 数为 0 或少于 Limit 时结束
+type OffsetStrategy struct {
+	// Param 默认为 offset, LimitParam 默认为 limit
+	Param      string
+	LimitParam string
+	Limit      int
+}
+
+func (s *OffsetStrategy) First() *Page {
+	return &Page{}
+}
+
+func (s *OffsetStrategy) Apply(df contract.RequestDataflowInterface, page *Page) {
+	param, limitParam := s.Param, s.LimitParam
+	if param == "" {
+		param = "offset"
+	}
+	if limitParam == "" {
+		limitParam = "limit"
+	}
+	df.Query(param, strconv.Itoa(page.Offset))
+	if s.Limit > 0 {
+		df.Query(limitParam, strconv.Itoa(s.Limit))
+	}
+}
+
+func (s *OffsetStrategy) Next(page *Page, response *PageResponse) *Page {
+	if response.Items == 0 || response.Items < s.Limit {
+		return nil
+	}
+	return &Page{Number: page.Number + 1, Offset: page.Offset + response.Items}
+}
+
+// CursorStrategy 从响应的 Path 字段读取下一页的游标, 作为 Param 参数发送, 游标为空时结束
+type CursorStrategy struct {
+	// Param 默认为 cursor
+	Param string
+	// Path 是游标在响应中的字段路径, 例如 meta.next_cursor
+	Path string
+	// HasMorePath 可选, 该字段为 false 时结束
+	HasMorePath string
+}
+
+func (s *CursorStrategy) First() *Page {
+	return &Page{}
+}
+
+func (s *CursorStrategy) Apply(df contract.RequestDataflowInterface, page *Page) {
+	param := s.Param
+	if param == "" {
+		param = "cursor"
+	}
+	if page.Cursor != "" {
+		df.Query(param, page.Cursor)
+	}
+}
+
+func (s *CursorStrategy) Next(page *Page, response *PageResponse) *Page {
+	if s.HasMorePath != "" {
+		if hasMore, ok := valueAtPath(response.Body, s.HasMorePath); ok && hasMore == false {
+			return nil
+		}
+	}
+	value, ok := valueAtPath(response.Body, s.Path)
+	if !ok || value == nil {
+		return nil
+	}
+	cursor := fmt.Sprint(value)
+	// 游标没有变化时结束, 避免死循环
+	if cursor == "" || cursor == page.Cursor {
+		return nil
+	}
+	return &Page{Number: page.Number + 1, Offset: page.Offset + response.Items, Cursor: cursor}
+}
+
+// LinkHeaderStrategy 按 RFC 5988 的 Link 响应头翻页, 请求 rel 为 Rel 的 Url, 没有时结束
+type LinkHeaderStrategy struct {
+	// Rel 默认为 next
+	Rel string
+}
+
+func (s *LinkHeaderStrategy) First() *Page {
+	return &Page{}
+}
+
+func (s *LinkHeaderStrategy) Apply(df contract.RequestDataflowInterface, page *Page) {
+	if page.Cursor != "" {
+		df.Url(page.Cursor)
+	}
+}
+
+func (s *LinkHeaderStrategy) Next(page *Page, response *PageResponse) *Page {
+	rel := s.Rel
+	if rel == "" {
+		rel = "next"
+	}
+	link := parseLinkHeader(response.Header.Values("Link"))[rel]
+	if link == "" {
+		return nil
+	}
+	// 相对地址按当前页的 Url 解析
+	if response.Request != nil && response.Request.URL != nil {
+		next, err := response.Request.URL.Parse(link)
+		if err != nil {
+			return nil
+		}
+		link = next.String()
+	}
+	if link == page.Cursor {
+		return nil
+	}
+	return &Page{Number: page.Number + 1, Offset: page.Offset + response.Items, Cursor: link}
+}
+
+// parseLinkHeader 返回 rel 到 Url 的映射, 例如 <https://api.example.com/items?page=2>; rel="next"
+func parseLinkHeader(values []string) map[string]string {
+	links := map[string]string{}
+	for _, value := range values {
+		for _, link := range strings.Split(value, ",") {
+			segments := strings.Split(link, ";")
+			target := strings.TrimSpace(segments[0])
+			if !strings.HasPrefix(target, "<") || !strings.HasSuffix(target, ">") {
+				continue
+			}
+			target = strings.TrimSuffix(strings.TrimPrefix(target, "<"), ">")
+			for _, param := range segments[1:] {
+				key, val, ok := strings.Cut(strings.TrimSpace(param), "=")
+				if !ok || !strings.EqualFold(strings.TrimSpace(key), "rel") {
+					continue
+				}
+				// rel 可以包含多个以空格分隔的值
+				for _, rel := range strings.Fields(strings.Trim(val, `"`)) {
+					if _, exists := links[rel]; !exists {
+						links[rel] = target
+					}
+				}
+			}
+		}
+	}
+	return links
+}
+
+type PaginatorOptions struct {
+	// Strategy 必填
+	Strategy PageStrategy
+	// ItemsPath 是 item 数组在响应中的字段路径, 例如 data.list, 为空时响应本身是数组
+	ItemsPath string
+	// Prefetch 在后台提前请求的页数, 为 0 时读完当前页才请求下一页
+	Prefetch int
+	// MaxPages 最多请求的页数, 为 0 时不限制
+	MaxPages int
+}
+
+// pageResult 是一页的 item 或请求的错误
+type pageResult[T any] struct {
+	items []T
+	err   error
+}
+
+// Paginator 逐个返回分页接口的 item, 在需要时才请求下一页.
+// 用法与 bufio.Scanner 相同: for p.Next() { p.Item() }, 结束后检查 p.Err()
+type Paginator[T any] struct {
+	ctx         context.Context
+	newDataflow func() contract.RequestDataflowInterface
+	options     PaginatorOptions
+
+	page    *Page
+	fetched int
+	items   []T
+	item    T
+	err     error
+
+	// prefetch 模式下由后台 goroutine 请求页面
+	startOnce sync.Once
+	pages     chan pageResult[T]
+	cancel    context.CancelFunc
+}
+
+// NewPaginator 创建分页器, newDataflow 每次返回一个新的请求模板, 例如
+// func() contract.RequestDataflowInterface { return helper.Df().Method(http.MethodGet).Uri("/items") }
+func NewPaginator[T any](ctx context.Context, newDataflow func() contract.RequestDataflowInterface, options *PaginatorOptions) (*Paginator[T], error) {
+	if newDataflow == nil || options == nil || options.Strategy == nil {
+		return nil, errors.New("paginator: dataflow and strategy are required")
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	return &Paginator[T]{
+		ctx:         ctx,
+		newDataflow: newDataflow,
+		options:     *options,
+		page:        options.Strategy.First(),
+		cancel:      cancel,
+	}, nil
+}
+
+// Next 移动到下一个 item, 没有更多 item 或出错时返回 false
+func (p *Paginator[T]) Next() bool {
+	for len(p.items) == 0 {
+		if p.err != nil {
+			return false
+		}
+		items, ok, err := p.nextPage()
+		if !ok {
+			return false
+		}
+		if err != nil {
+			p.err = err
+			return false
+		}
+		p.items = items
+	}
+	p.item, p.items = p.items[0], p.items[1:]
+	return true
+}
+
+// Item 返回当前的 item
+func (p *Paginator[T]) Item() T {
+	return p.item
+}
+
+// Err 返回请求或解码的错误, ctx 取消时返回 ctx 的错误
+func (p *Paginator[T]) Err() error {
+	return p.err
+}
+
+// Close 停止后台的预取, 没有读完时应该调用
+func (p *Paginator[T]) Close() {
+	p.cancel()
+}
+
+// All 读取所有的 item
+func (p *Paginator[T]) All() ([]T, error) {
+	defer p.Close()
+	var all []T
+	for p.Next() {
+		all = append(all, p.Item())
+	}
+	return all, p.Err()
+}
+
+// nextPage 返回下一页的 item, ok 为 false 时已经没有下一页
+func (p *Paginator[T]) nextPage() (items []T, ok bool, err error) {
+	if p.options.Prefetch <= 0 {
+		if err = p.ctx.Err(); err != nil {
+			return nil, true, err
+		}
+		return p.fetch()
+	}
+
+	p.startOnce.Do(func() {
+		p.pages = make(chan pageResult[T], p.options.Prefetch)
+		go p.prefetch()
+	})
+	select {
+	case result, more := <-p.pages:
+		if !more {
+			return nil, false, nil
+		}
+		return result.items, true, result.err
+	case <-p.ctx.Done():
+		return nil, true, p.ctx.Err()
+	}
+}
+
+func (p *Paginator[T]) prefetch() {
+	defer close(p.pages)
+	for {
+		items, ok, err := p.fetch()
+		if !ok {
+			return
+		}
+		select {
+		case p.pages <- pageResult[T]{items: items, err: err}:
+		case <-p.ctx.Done():
+			return
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+// fetch 请求当前页并移动到下一页, 只在一个 goroutine 中调用
+func (p *Paginator[T]) fetch() (items []T, ok bool, err error) {
+	if p.page == nil || (p.options.MaxPages > 0 && p.fetched >= p.options.MaxPages) {
+		return nil, false, nil
+	}
+	page := p.page
+	p.fetched++
+
+	df := p.newDataflow()
+	p.options.Strategy.Apply(df, page)
+	response, err := df.WithContext(p.ctx).Request()
+	if err != nil {
+		return nil, true, err
+	}
+	defer response.Body.Close()
+	if !dataflow.IsSuccess(response.StatusCode) {
+		return nil, true, dataflow.NewHttpError(df.GetRequest(), response, nil)
+	}
+
+	body, err := decodePage(response)
+	if err != nil {
+		return nil, true, err
+	}
+	items, err = pageItems[T](body, p.options.ItemsPath)
+	if err != nil {
+		return nil, true, err
+	}
+
+	p.page = p.options.Strategy.Next(page, &PageResponse{
+		Request: df.GetRequest(),
+		Header:  response.Header,
+		Body:    body,
+		Items:   len(items),
+	})
+	return items, true, nil
+}
+
+// decodePage 将响应解码为 map 或数组, Xml 响应解码为 object.HashMap.
+// 数字保留为 json.Number, 使数字游标原样发送, 超过 2^53 的 int64 id 不丢失精度
+func decodePage(response *http.Response) (interface{}, error) {
+	b, err := io.ReadAll(response.Body)
+	if err != nil {
+		return nil, errors.Wrap(err, "read page failed")
+	}
+	if len(b) == 0 {
+		return nil, nil
+	}
+
+	contentType := response.Header.Get("Content-Type")
+	if mediaType, _, _ := mime.ParseMediaType(contentType); mediaType == "application/xml" || mediaType == "text/xml" || strings.HasSuffix(mediaType, "+xml") {
+		body := object.HashMap{}
+		err = dataflow.DecodeBody(contentType, b, &body)
+		return map[string]interface{}(body), errors.Wrap(err, "decode page failed")
+	}
+
+	var body interface{}
+	decoder := json.NewDecoder(bytes.NewReader(b))
+	decoder.UseNumber()
+	err = decoder.Decode(&body)
+	if err == nil && decoder.More() {
+		err = errors.New("invalid character after top-level value")
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "decode page failed")
+	}
+	return body, nil
+}
+
+// pageItems 读取 path 处的数组并转换为 []T, 字段不存在时这一页没有 item
+func pageItems[T any](body interface{}, path string) ([]T, error) {
+	value, ok := valueAtPath(body, path)
+	if !ok || value == nil {
+		return nil, nil
+	}
+	// Xml 中只有一个元素的数组会被解码为单个值
+	if _, isArray := value.([]interface{}); !isArray {
+		value = []interface{}{value}
+	}
+
+	b, err := json.Marshal(value)
+	if err != nil {
+		return nil, err
+	}
+	var items []T
+	err = json.Unmarshal(b, &items)
+	if err != nil {
+		return nil, errors.Wrap(err, "decode page items failed")
+	}
+	return items, nil
+}
+
+// valueAtPath 按 a.b.c 形式的路径读取嵌套的字段, path 为空时返回 body 本身
+func valueAtPath(body interface{}, path string) (interface{}, bool) {
+	if path == "" {
+		return body, true
+	}
+	value := body
+	for _, segment := range strings.Split(path, ".") {
+		var ok bool
+		switch v := value.(type) {
+		case map[string]interface{}:
+			value, ok = v[segment]
+		case object.HashMap:
+			value, ok = v[segment]
+		}
+		if !ok {
+			return nil, false
+		}
+	}
+	return value, true
+}
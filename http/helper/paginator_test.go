@@ -0,0 +1,253 @@
+package helper
+
+import (
+	"context"
+	"errors"
+	"github.com/dadiYazZ/xin-da-libs/http/contract"
+	"github.com/dadiYazZ/xin-da-libs/http/dataflow"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// itemsJson returns [{"id":from},...,{"id":to-1}]
+func itemsJson(from, to int) string {
+	items := "["
+	for i := from; i < to; i++ {
+		if i > from {
+			items += ","
+		}
+		items += `{"id":` + strconv.Itoa(i) + `}`
+	}
+	return items + "]"
+}
+
+func collectIds(t *testing.T, paginator *Paginator[batchItem]) []int {
+	items, err := paginator.All()
+	assert.NoError(t, err)
+	ids := []int{}
+	for _, item := range items {
+		ids = append(ids, item.Id)
+	}
+	return ids
+}
+
+func TestPaginator_PageNumber(t *testing.T) {
+	var calls int32
+	helper := newTestRequestHelper(t, func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		page, _ := strconv.Atoi(r.URL.Query().Get("page"))
+		size, _ := strconv.Atoi(r.URL.Query().Get("size"))
+		to := page * size
+		if to > 7 {
+			to = 7
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"data":{"list":` + itemsJson((page-1)*size, to) + `}}`))
+	})
+	newDataflow := func() contract.RequestDataflowInterface {
+		return helper.Df().Method(http.MethodGet).Uri("/items")
+	}
+
+	paginator, err := NewPaginator[batchItem](context.Background(), newDataflow, &PaginatorOptions{
+		Strategy:  &PageNumberStrategy{SizeParam: "size", Size: 3},
+		ItemsPath: "data.list",
+	})
+	assert.NoError(t, err)
+
+	// pages are requested lazily
+	assert.True(t, paginator.Next())
+	assert.Equal(t, 0, paginator.Item().Id)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&calls))
+
+	assert.Equal(t, []int{1, 2, 3, 4, 5, 6}, collectIds(t, paginator))
+	assert.Equal(t, int32(3), atomic.LoadInt32(&calls))
+
+	paginator, _ = NewPaginator[batchItem](context.Background(), newDataflow, &PaginatorOptions{
+		Strategy:  &PageNumberStrategy{SizeParam: "size", Size: 3},
+		ItemsPath: "data.list",
+		MaxPages:  2,
+	})
+	assert.Equal(t, []int{0, 1, 2, 3, 4, 5}, collectIds(t, paginator))
+
+	_, err = NewPaginator[batchItem](context.Background(), newDataflow, &PaginatorOptions{})
+	assert.Error(t, err)
+}
+
+func TestPaginator_Offset(t *testing.T) {
+	helper := newTestRequestHelper(t, func(w http.ResponseWriter, r *http.Request) {
+		offset, _ := strconv.Atoi(r.URL.Query().Get("offset"))
+		limit, _ := strconv.Atoi(r.URL.Query().Get("limit"))
+		to := offset + limit
+		if to > 5 {
+			to = 5
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(itemsJson(offset, to)))
+	})
+
+	paginator, err := NewPaginator[batchItem](context.Background(), func() contract.RequestDataflowInterface {
+		return helper.Df().Method(http.MethodGet).Uri("/items")
+	}, &PaginatorOptions{Strategy: &OffsetStrategy{Limit: 2}})
+	assert.NoError(t, err)
+	assert.Equal(t, []int{0, 1, 2, 3, 4}, collectIds(t, paginator))
+}
+
+func TestPaginator_Cursor(t *testing.T) {
+	helper := newTestRequestHelper(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch r.URL.Query().Get("cursor") {
+		case "":
+			_, _ = w.Write([]byte(`{"items":` + itemsJson(0, 2) + `,"meta":{"next":"abc","has_more":true}}`))
+		case "abc":
+			_, _ = w.Write([]byte(`{"items":` + itemsJson(2, 4) + `,"meta":{"next":"def","has_more":false}}`))
+		default:
+			w.WriteHeader(http.StatusBadRequest)
+		}
+	})
+
+	paginator, err := NewPaginator[batchItem](context.Background(), func() contract.RequestDataflowInterface {
+		return helper.Df().Method(http.MethodGet).Uri("/items")
+	}, &PaginatorOptions{
+		Strategy:  &CursorStrategy{Path: "meta.next", HasMorePath: "meta.has_more"},
+		ItemsPath: "items",
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, []int{0, 1, 2, 3}, collectIds(t, paginator))
+
+	// without has_more the invalid cursor is requested
+	paginator, _ = NewPaginator[batchItem](context.Background(), func() contract.RequestDataflowInterface {
+		return helper.Df().Method(http.MethodGet).Uri("/items")
+	}, &PaginatorOptions{
+		Strategy:  &CursorStrategy{Path: "meta.next"},
+		ItemsPath: "items",
+	})
+	items, err := paginator.All()
+	assert.Len(t, items, 4)
+	var httpErr *dataflow.HttpError
+	assert.True(t, errors.As(err, &httpErr))
+	assert.Equal(t, http.StatusBadRequest, httpErr.StatusCode)
+}
+
+func TestPaginator_NumericCursor(t *testing.T) {
+	type bigItem struct {
+		Id int64 `json:"id"`
+	}
+	helper := newTestRequestHelper(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch r.URL.Query().Get("cursor") {
+		case "":
+			_, _ = w.Write([]byte(`{"items":[{"id":9007199254740993}],"next":1000000}`))
+		case "1000000":
+			_, _ = w.Write([]byte(`{"items":[{"id":9223372036854775807}]}`))
+		default:
+			w.WriteHeader(http.StatusBadRequest)
+		}
+	})
+
+	paginator, err := NewPaginator[bigItem](context.Background(), func() contract.RequestDataflowInterface {
+		return helper.Df().Method(http.MethodGet).Uri("/items")
+	}, &PaginatorOptions{
+		Strategy:  &CursorStrategy{Path: "next"},
+		ItemsPath: "items",
+	})
+	assert.NoError(t, err)
+	items, err := paginator.All()
+	assert.NoError(t, err)
+	assert.Equal(t, []bigItem{{Id: 9007199254740993}, {Id: 9223372036854775807}}, items)
+}
+
+func TestPaginator_LinkHeader(t *testing.T) {
+	helper := newTestRequestHelper(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch r.URL.Path {
+		case "/items":
+			w.Header().Set("Link", `</items/2>; rel="next", </items/3>; rel="last"`)
+			_, _ = w.Write([]byte(itemsJson(0, 2)))
+		case "/items/2":
+			w.Header().Add("Link", `</items>; rel="prev first"`)
+			w.Header().Add("Link", `</items/3>; rel="next"`)
+			_, _ = w.Write([]byte(itemsJson(2, 4)))
+		case "/items/3":
+			_, _ = w.Write([]byte(itemsJson(4, 5)))
+		}
+	})
+
+	paginator, err := NewPaginator[batchItem](context.Background(), func() contract.RequestDataflowInterface {
+		return helper.Df().Method(http.MethodGet).Uri("/items")
+	}, &PaginatorOptions{Strategy: &LinkHeaderStrategy{}})
+	assert.NoError(t, err)
+	assert.Equal(t, []int{0, 1, 2, 3, 4}, collectIds(t, paginator))
+
+	links := parseLinkHeader([]string{`<https://api.example.com/items?page=2>; rel="next", <https://api.example.com/items?page=1>; rel=prev`})
+	assert.Equal(t, "https://api.example.com/items?page=2", links["next"])
+	assert.Equal(t, "https://api.example.com/items?page=1", links["prev"])
+}
+
+func TestPaginator_Prefetch(t *testing.T) {
+	var calls int32
+	helper := newTestRequestHelper(t, func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		page, _ := strconv.Atoi(r.URL.Query().Get("page"))
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(itemsJson(page*2, page*2+2)))
+	})
+	newDataflow := func() contract.RequestDataflowInterface {
+		return helper.Df().Method(http.MethodGet).Uri("/items")
+	}
+
+	paginator, err := NewPaginator[batchItem](context.Background(), newDataflow, &PaginatorOptions{
+		Strategy: &PageNumberStrategy{Size: 2, ZeroBased: true},
+		Prefetch: 2,
+		MaxPages: 4,
+	})
+	assert.NoError(t, err)
+	assert.True(t, paginator.Next())
+	assert.Equal(t, 0, paginator.Item().Id)
+
+	// the next pages are fetched in the background while the first is read
+	assert.Eventually(t, func() bool {
+		return atomic.LoadInt32(&calls) == 4
+	}, time.Second, 5*time.Millisecond)
+	assert.Equal(t, []int{1, 2, 3, 4, 5, 6, 7}, collectIds(t, paginator))
+
+	// close stops the endless prefetch
+	paginator, _ = NewPaginator[batchItem](context.Background(), newDataflow, &PaginatorOptions{
+		Strategy: &PageNumberStrategy{Size: 2, ZeroBased: true},
+		Prefetch: 1,
+	})
+	assert.True(t, paginator.Next())
+	paginator.Close()
+	time.Sleep(20 * time.Millisecond)
+	stopped := atomic.LoadInt32(&calls)
+	time.Sleep(20 * time.Millisecond)
+	assert.Equal(t, stopped, atomic.LoadInt32(&calls))
+}
+
+func TestPaginator_Cancel(t *testing.T) {
+	helper := newTestRequestHelper(t, func(w http.ResponseWriter, r *http.Request) {
+		page, _ := strconv.Atoi(r.URL.Query().Get("page"))
+		if page > 1 {
+			<-r.Context().Done()
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(itemsJson(0, 2)))
+	})
+
+	for _, prefetch := range []int{0, 1} {
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		paginator, err := NewPaginator[batchItem](ctx, func() contract.RequestDataflowInterface {
+			return helper.Df().Method(http.MethodGet).Uri("/items")
+		}, &PaginatorOptions{Strategy: &PageNumberStrategy{Size: 2}, Prefetch: prefetch})
+		assert.NoError(t, err)
+
+		items, err := paginator.All()
+		assert.Len(t, items, 2)
+		assert.True(t, errors.Is(err, context.DeadlineExceeded), prefetch)
+		cancel()
+	}
+}
@@ -130,7 +130,41 @@ func (r *RequestHelper) ParseResponseBodyContent(rs *http2.Response, outBody int
 
 }
 
+// hopByHopHeaders 是只对单个连接有效的响应头, HttpResponseSend 不转发, 见 RFC 7230 6.1
+var hopByHopHeaders = []string{
+	"Connection",
+	"Keep-Alive",
+	"Proxy-Authenticate",
+	"Proxy-Authorization",
+	"Proxy-Connection",
+	"Te",
+	"Trailer",
+	"Transfer-Encoding",
+	"Upgrade",
+}
+
+// HttpResponseSend 将响应的状态码, 响应头和 body 写入 writer, 用于转发收到的响应
 func HttpResponseSend(rs *http2.Response, writer http2.ResponseWriter) (err error) {
+	defer rs.Body.Close()
+
+	// copy header, Content-Length is computed again from the body written,
+	// the hop-by-hop headers only apply to the upstream connection
+	skip := map[string]bool{"Content-Length": true}
+	for _, key := range hopByHopHeaders {
+		skip[key] = true
+	}
+	for _, value := range rs.Header.Values("Connection") {
+		for _, key := range strings.Split(value, ",") {
+			skip[http2.CanonicalHeaderKey(strings.TrimSpace(key))] = true
+		}
+	}
+	header := writer.Header()
+	for key, values := range rs.Header {
+		if skip[http2.CanonicalHeaderKey(key)] {
+			continue
+		}
+		header[key] = append([]string(nil), values...)
+	}
 
 	// set header code
 	writer.WriteHeader(rs.StatusCode)
@@ -0,0 +1,188 @@
+package helper
+
+import (
+	"context"
+	"io"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/dadiYazZ/xin-da-libs/http/contract"
+	"github.com/pkg/errors"
+)
+
+const (
+	DEFAULT_RETRY_MAX_ATTEMPTS = 3
+	DEFAULT_RETRY_BASE_DELAY   = 100 * time.Millisecond
+	DEFAULT_RETRY_MAX_DELAY    = 10 * time.Second
+)
+
+// DEFAULT_RETRY_STATUSES 是默认重试的响应状态码
+var DEFAULT_RETRY_STATUSES = []int{
+	http.StatusTooManyRequests,
+	http.StatusBadGateway,
+	http.StatusServiceUnavailable,
+	http.StatusGatewayTimeout,
+}
+
+// errBodyNotReplayable 请求 body 无法通过 GetBody 重新读取, 这类请求不会被重试
+var errBodyNotReplayable = errors.New("request body can not be replayed, GetBody is nil")
+
+type RetryOptions struct {
+	// MaxAttempts 最多请求次数, 包含首次请求
+	MaxAttempts int
+	// BaseDelay 首次重试前的等待时间, 之后每次翻倍
+	BaseDelay time.Duration
+	// MaxDelay 单次等待的上限, 服务端 Retry-After 超过该值时不再重试, 直接返回响应
+	MaxDelay time.Duration
+	// DisableJitter 关闭随机抖动, 默认在 [0, backoff] 之间随机等待
+	DisableJitter bool
+	// RetryStatuses 需要重试的状态码, 默认为 DEFAULT_RETRY_STATUSES
+	RetryStatuses []int
+	// ShouldRetry 自定义是否重试, 设置后 RetryStatuses 不再生效
+	ShouldRetry func(response *http.Response, err error) bool
+}
+
+func (o *RetryOptions) withDefaults() RetryOptions {
+	options := RetryOptions{}
+	if o != nil {
+		options = *o
+	}
+	if options.MaxAttempts <= 0 {
+		options.MaxAttempts = DEFAULT_RETRY_MAX_ATTEMPTS
+	}
+	if options.BaseDelay <= 0 {
+		options.BaseDelay = DEFAULT_RETRY_BASE_DELAY
+	}
+	if options.MaxDelay <= 0 {
+		options.MaxDelay = DEFAULT_RETRY_MAX_DELAY
+	}
+	if options.RetryStatuses == nil {
+		options.RetryStatuses = DEFAULT_RETRY_STATUSES
+	}
+	if options.ShouldRetry == nil {
+		statuses := options.RetryStatuses
+		options.ShouldRetry = func(response *http.Response, err error) bool {
+			if err != nil {
+				// 熔断和取消的请求重试也不会成功
+				return !errors.Is(err, ErrCircuitOpen) && !errors.Is(err, context.Canceled) && !errors.Is(err, context.DeadlineExceeded)
+			}
+			for _, status := range statuses {
+				if response.StatusCode == status {
+					return true
+				}
+			}
+			return false
+		}
+	}
+	return options
+}
+
+// RetryMiddleware 对失败的请求做指数退避重试, 优先使用响应的 Retry-After 作为等待时间.
+// 重试时通过 request.GetBody 重放 body, Dataflow 的 Json, Xml 和 bytes 类型的 Body 都会设置 GetBody.
+// 与 CircuitBreakerMiddleware 组合时, 应该先注册 RetryMiddleware, 使每次重试都经过熔断器
+func RetryMiddleware(options *RetryOptions) contract.RequestMiddleware {
+	opts := options.withDefaults()
+
+	return func(handle contract.RequestHandle) contract.RequestHandle {
+		return func(request *http.Request) (response *http.Response, err error) {
+			ctx := request.Context()
+			attemptRequest := request
+
+			for attempt := 1; ; attempt++ {
+				response, err = handle(attemptRequest)
+				if attempt >= opts.MaxAttempts || !opts.ShouldRetry(response, err) {
+					return response, err
+				}
+
+				delay := opts.backoff(attempt)
+				if err == nil {
+					if after, ok := retryAfter(response, time.Now()); ok {
+						if after > opts.MaxDelay {
+							return response, err
+						}
+						delay = after
+					}
+				}
+
+				next, replayErr := ReplayRequest(request)
+				if replayErr != nil {
+					// body 无法重放时返回最后一次请求的结果
+					return response, err
+				}
+				attemptRequest = next
+				DrainBody(response)
+
+				timer := time.NewTimer(delay)
+				select {
+				case <-ctx.Done():
+					timer.Stop()
+					return nil, ctx.Err()
+				case <-timer.C:
+				}
+			}
+		}
+	}
+}
+
+// backoff 返回第 attempt 次请求失败后的等待时间
+func (o *RetryOptions) backoff(attempt int) time.Duration {
+	delay := o.MaxDelay
+	if shift := attempt - 1; shift < 32 {
+		if exp := o.BaseDelay << shift; exp > 0 && exp < o.MaxDelay {
+			delay = exp
+		}
+	}
+	if o.DisableJitter {
+		return delay
+	}
+	return time.Duration(rand.Int63n(int64(delay) + 1))
+}
+
+// retryAfter 解析 Retry-After 响应头, 支持秒数和 HTTP 日期两种格式
+func retryAfter(response *http.Response, now time.Time) (time.Duration, bool) {
+	value := response.Header.Get("Retry-After")
+	if value == "" {
+		return 0, false
+	}
+	if seconds, err := strconv.Atoi(value); err == nil {
+		if seconds < 0 {
+			return 0, false
+		}
+		return time.Duration(seconds) * time.Second, true
+	}
+	if date, err := http.ParseTime(value); err == nil {
+		if delay := date.Sub(now); delay > 0 {
+			return delay, true
+		}
+		return 0, true
+	}
+	return 0, false
+}
+
+// ReplayRequest 复制请求用于重试, 并通过 GetBody 重新获取 body, 没有 GetBody 时返回错误
+func ReplayRequest(request *http.Request) (*http.Request, error) {
+	replay := request.Clone(request.Context())
+	if request.Body == nil || request.Body == http.NoBody {
+		return replay, nil
+	}
+	if request.GetBody == nil {
+		return nil, errBodyNotReplayable
+	}
+	body, err := request.GetBody()
+	if err != nil {
+		return nil, errors.Wrap(err, "replay request body failed")
+	}
+	replay.Body = body
+	return replay, nil
+}
+
+// DrainBody 读完并关闭丢弃的响应, 使连接可以复用, 最多读取 4KB
+func DrainBody(response *http.Response) {
+	if response == nil || response.Body == nil {
+		return
+	}
+	_, _ = io.Copy(io.Discard, io.LimitReader(response.Body, 4096))
+	_ = response.Body.Close()
+}
@@ -0,0 +1,129 @@
+package helper
+
+import (
+	"github.com/stretchr/testify/assert"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func newTestRequestHelper(t *testing.T, handler http.HandlerFunc) *RequestHelper {
+	server := httptest.NewServer(handler)
+	t.Cleanup(server.Close)
+
+	helper, err := NewRequestHelper(&Config{
+		BaseUrl: server.URL,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return helper
+}
+
+func TestRetryMiddleware_ReplayBody(t *testing.T) {
+	var attempts int32
+	bodies := make(chan string, 3)
+	helper := newTestRequestHelper(t, func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		bodies <- string(body)
+		if atomic.AddInt32(&attempts, 1) < 3 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	})
+	helper.WithMiddleware(RetryMiddleware(&RetryOptions{
+		MaxAttempts: 3,
+		BaseDelay:   time.Millisecond,
+	}))
+
+	response, err := helper.Df().Method(http.MethodPost).Json(map[string]string{"hello": "world"}).Request()
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, response.StatusCode)
+	assert.Equal(t, int32(3), atomic.LoadInt32(&attempts))
+
+	close(bodies)
+	for body := range bodies {
+		assert.JSONEq(t, `{"hello":"world"}`, body)
+	}
+}
+
+func TestRetryMiddleware_GiveUp(t *testing.T) {
+	var attempts int32
+	helper := newTestRequestHelper(t, func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&attempts, 1)
+		w.WriteHeader(http.StatusBadGateway)
+	})
+	helper.WithMiddleware(RetryMiddleware(&RetryOptions{
+		MaxAttempts: 2,
+		BaseDelay:   time.Millisecond,
+	}))
+
+	response, err := helper.Df().Method(http.MethodGet).Request()
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusBadGateway, response.StatusCode)
+	assert.Equal(t, int32(2), atomic.LoadInt32(&attempts))
+}
+
+func TestRetryMiddleware_RetryAfter(t *testing.T) {
+	var attempts int32
+	helper := newTestRequestHelper(t, func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&attempts, 1)
+		w.Header().Set("Retry-After", "120")
+		w.WriteHeader(http.StatusTooManyRequests)
+	})
+	helper.WithMiddleware(RetryMiddleware(&RetryOptions{
+		MaxAttempts: 3,
+		MaxDelay:    time.Second,
+	}))
+
+	// the server asks to wait longer than MaxDelay, the response is returned right away
+	response, err := helper.Df().Method(http.MethodGet).Request()
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusTooManyRequests, response.StatusCode)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&attempts))
+}
+
+func Test_retryAfter(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	response := &http.Response{Header: http.Header{}}
+	_, ok := retryAfter(response, now)
+	assert.False(t, ok)
+
+	response.Header.Set("Retry-After", "3")
+	delay, ok := retryAfter(response, now)
+	assert.True(t, ok)
+	assert.Equal(t, 3*time.Second, delay)
+
+	response.Header.Set("Retry-After", now.Add(time.Minute).Format(http.TimeFormat))
+	delay, ok = retryAfter(response, now)
+	assert.True(t, ok)
+	assert.Equal(t, time.Minute, delay)
+
+	response.Header.Set("Retry-After", "soon")
+	_, ok = retryAfter(response, now)
+	assert.False(t, ok)
+}
+
+func TestRetryOptions_backoff(t *testing.T) {
+	options := (&RetryOptions{
+		BaseDelay:     time.Second,
+		MaxDelay:      5 * time.Second,
+		DisableJitter: true,
+	}).withDefaults()
+
+	assert.Equal(t, time.Second, options.backoff(1))
+	assert.Equal(t, 2*time.Second, options.backoff(2))
+	assert.Equal(t, 4*time.Second, options.backoff(3))
+	assert.Equal(t, 5*time.Second, options.backoff(4))
+	assert.Equal(t, 5*time.Second, options.backoff(100))
+
+	options.DisableJitter = false
+	for i := 0; i < 10; i++ {
+		assert.LessOrEqual(t, options.backoff(2), 2*time.Second)
+	}
+}
@@ -0,0 +1,274 @@
+package helper
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"strconv"
+
+	"github.com/dadiYazZ/xin-da-libs/http/contract"
+	"github.com/dadiYazZ/xin-da-libs/object"
+	"github.com/dadiYazZ/xin-da-libs/security/sign"
+	"github.com/pkg/errors"
+)
+
+// DEFAULT_SIGN_FIELD 是签名参数的默认名称
+const DEFAULT_SIGN_FIELD = "sign"
+
+// DEFAULT_SIGN_MAX_BODY_SIZE 是读取参与签名的 body 的默认长度上限
+const DEFAULT_SIGN_MAX_BODY_SIZE = 1 << 20
+
+// ErrSignatureMissing 待校验的请求没有携带签名
+var ErrSignatureMissing = errors.New("request signature is missing")
+
+type SignOptions struct {
+	// Signer 可以是 security/sign 中的任意签名器, 例如 MD5Signer 和 RSASigner
+	Signer sign.MessageSigner
+	// Header 签名写入的请求头, 为空时签名作为 Field 参数写入 body 或 query
+	Header string
+	// Field 签名参数的名称, 默认为 DEFAULT_SIGN_FIELD, 该参数不参与签名
+	Field string
+	// Exclude 不参与签名的参数
+	Exclude []string
+	// SkipEmpty 跳过值为空的参数
+	SkipEmpty bool
+	// Canonicalize 将参数规范化为待签名的消息, 默认为 sign.KSortStringMap
+	Canonicalize func(params object.StringMap) (string, error)
+	// MaxBodySize 表单和 Json body 的长度上限, 默认为 DEFAULT_SIGN_MAX_BODY_SIZE, 超过时返回 ErrBodyTooLarge
+	MaxBodySize int64
+}
+
+// RequestSigner 对 query 和 body 中的参数签名, 支持表单和 Json 对象的 body, 其它 body 不读取也不参与签名.
+// Middleware 给发出的请求签名, Verify 校验收到的 webhook 请求
+type RequestSigner struct {
+	options SignOptions
+}
+
+func NewRequestSigner(options *SignOptions) (*RequestSigner, error) {
+	if options == nil || options.Signer == nil {
+		return nil, errors.New("signer is required")
+	}
+
+	opts := *options
+	if opts.Field == "" {
+		opts.Field = DEFAULT_SIGN_FIELD
+	}
+	if opts.MaxBodySize <= 0 {
+		opts.MaxBodySize = DEFAULT_SIGN_MAX_BODY_SIZE
+	}
+	if opts.Canonicalize == nil {
+		opts.Canonicalize = func(params object.StringMap) (string, error) {
+			return sign.KSortStringMap(&params), nil
+		}
+	}
+	return &RequestSigner{options: opts}, nil
+}
+
+// SignMiddleware 返回给请求签名的中间件, options 无效时 panic
+func SignMiddleware(options *SignOptions) contract.RequestMiddleware {
+	signer, err := NewRequestSigner(options)
+	if err != nil {
+		panic(err)
+	}
+	return signer.Middleware()
+}
+
+// Middleware 应该注册在修改请求参数的中间件之后, 使签名覆盖最终发送的参数
+func (s *RequestSigner) Middleware() contract.RequestMiddleware {
+	return func(handle contract.RequestHandle) contract.RequestHandle {
+		return func(request *http.Request) (response *http.Response, err error) {
+			err = s.Sign(request)
+			if err != nil {
+				return nil, err
+			}
+			return handle(request)
+		}
+	}
+}
+
+// Sign 计算请求的签名, 写入请求头或签名参数
+func (s *RequestSigner) Sign(request *http.Request) error {
+	params, err := readSignParams(request, s.options.MaxBodySize)
+	if err != nil {
+		return err
+	}
+
+	msg, err := s.message(params.values)
+	if err != nil {
+		return err
+	}
+	signature, err := s.options.Signer.SignMessage(msg)
+	if err != nil {
+		return errors.Wrap(err, "sign request failed")
+	}
+
+	if s.options.Header != "" {
+		request.Header.Set(s.options.Header, signature)
+		return nil
+	}
+	return params.setField(request, s.options.Field, signature)
+}
+
+// Verify 校验请求的签名, 读取后的 body 会被重置, 之后的处理仍然可以读取
+func (s *RequestSigner) Verify(request *http.Request) error {
+	params, err := readSignParams(request, s.options.MaxBodySize)
+	if err != nil {
+		return err
+	}
+
+	signature := params.values[s.options.Field]
+	if s.options.Header != "" {
+		signature = request.Header.Get(s.options.Header)
+	}
+	if signature == "" {
+		return ErrSignatureMissing
+	}
+
+	msg, err := s.message(params.values)
+	if err != nil {
+		return err
+	}
+	return s.options.Signer.VerifyMessage(msg, signature)
+}
+
+// VerifyHandler 拒绝签名无效的请求, 返回 401, body 超过 MaxBodySize 时返回 413
+func (s *RequestSigner) VerifyHandler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		if err := s.Verify(request); err != nil {
+			status := http.StatusUnauthorized
+			if errors.Is(err, ErrBodyTooLarge) {
+				status = http.StatusRequestEntityTooLarge
+			}
+			http.Error(writer, err.Error(), status)
+			return
+		}
+		next.ServeHTTP(writer, request)
+	})
+}
+
+// message 去掉签名参数和排除的参数后规范化
+func (s *RequestSigner) message(values object.StringMap) (string, error) {
+	params := object.StringMap{}
+	for key, value := range values {
+		if key == s.options.Field || (s.options.SkipEmpty && value == "") {
+			continue
+		}
+		params[key] = value
+	}
+	for _, key := range s.options.Exclude {
+		delete(params, key)
+	}
+	return s.options.Canonicalize(params)
+}
+
+// signParams 是请求中参与签名的参数, body 中的参数会覆盖 query 中的同名参数
+type signParams struct {
+	values object.StringMap
+	form   url.Values
+	json   map[string]interface{}
+}
+
+// readSignParams 只读取表单和 Json 的 body, 其它 body 例如流式上传的 multipart 保持不变
+func readSignParams(request *http.Request, maxBodySize int64) (*signParams, error) {
+	params := &signParams{values: object.StringMap{}}
+	query := request.URL.Query()
+	for key := range query {
+		params.values[key] = query.Get(key)
+	}
+
+	mediaType, _, _ := mime.ParseMediaType(request.Header.Get("Content-Type"))
+	if request.Body == nil || request.Body == http.NoBody ||
+		(mediaType != "application/x-www-form-urlencoded" && mediaType != "application/json") {
+		return params, nil
+	}
+	// 多读一个字节用于判断是否超过上限
+	body, err := io.ReadAll(io.LimitReader(request.Body, maxBodySize+1))
+	if err != nil {
+		return nil, errors.Wrap(err, "read request body failed")
+	}
+	if int64(len(body)) > maxBodySize {
+		return nil, errors.Wrapf(ErrBodyTooLarge, "limit %d bytes", maxBodySize)
+	}
+	_ = request.Body.Close()
+	setRequestBody(request, body)
+
+	switch mediaType {
+	case "application/x-www-form-urlencoded":
+		params.form, err = url.ParseQuery(string(body))
+		if err != nil {
+			return nil, errors.Wrap(err, "parse form body failed")
+		}
+		for key := range params.form {
+			params.values[key] = params.form.Get(key)
+		}
+	case "application/json":
+		decoder := json.NewDecoder(bytes.NewReader(body))
+		decoder.UseNumber()
+		// 不是 Json 对象的 body 不参与签名
+		if decoder.Decode(&params.json) != nil {
+			params.json = nil
+			break
+		}
+		for key, value := range params.json {
+			params.values[key], err = signValue(value)
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	return params, nil
+}
+
+// setField 将签名参数写入表单或 Json body, 其它请求写入 query
+func (p *signParams) setField(request *http.Request, field string, signature string) error {
+	switch {
+	case p.form != nil:
+		p.form.Set(field, signature)
+		setRequestBody(request, []byte(p.form.Encode()))
+	case p.json != nil:
+		p.json[field] = signature
+		var buf bytes.Buffer
+		encoder := json.NewEncoder(&buf)
+		encoder.SetEscapeHTML(false)
+		if err := encoder.Encode(p.json); err != nil {
+			return errors.Wrap(err, "json body encode failed")
+		}
+		setRequestBody(request, buf.Bytes())
+	default:
+		query := request.URL.Query()
+		query.Set(field, signature)
+		request.URL.RawQuery = query.Encode()
+	}
+	return nil
+}
+
+// signValue 将 Json 的值转为参与签名的字符串, 对象和数组使用 Json 编码
+func signValue(value interface{}) (string, error) {
+	switch v := value.(type) {
+	case nil:
+		return "", nil
+	case string:
+		return v, nil
+	case json.Number:
+		return v.String(), nil
+	case bool:
+		return strconv.FormatBool(v), nil
+	}
+	b, err := json.Marshal(value)
+	if err != nil {
+		return "", errors.Wrap(err, "encode sign value failed")
+	}
+	return string(b), nil
+}
+
+func setRequestBody(request *http.Request, body []byte) {
+	request.Body = io.NopCloser(bytes.NewReader(body))
+	request.ContentLength = int64(len(body))
+	request.GetBody = func() (io.ReadCloser, error) {
+		return io.NopCloser(bytes.NewReader(body)), nil
+	}
+}
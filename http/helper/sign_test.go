@@ -0,0 +1,134 @@
+package helper
+
+import (
+	"crypto"
+	"errors"
+	"github.com/dadiYazZ/xin-da-libs/object"
+	"github.com/dadiYazZ/xin-da-libs/security/sign"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestRequestSigner_Form(t *testing.T) {
+	md5Signer, _ := sign.NewMD5Signer("secret")
+	signer, err := NewRequestSigner(&SignOptions{Signer: md5Signer})
+	assert.NoError(t, err)
+
+	var verifyErr error
+	var received string
+	helper := newTestRequestHelper(t, func(w http.ResponseWriter, r *http.Request) {
+		verifyErr = signer.Verify(r)
+		b, _ := io.ReadAll(r.Body)
+		received = string(b)
+	})
+	helper.WithMiddleware(signer.Middleware())
+
+	_, err = helper.Df().Method(http.MethodPost).Uri("/notify?app_id=10001").Form(object.StringMap{
+		"b": "2",
+		"a": "1",
+	}).Request()
+	assert.NoError(t, err)
+	assert.NoError(t, verifyErr)
+
+	expected, _ := md5Signer.Sign("a=1&app_id=10001&b=2")
+	assert.Equal(t, "a=1&b=2&sign="+expected, received)
+}
+
+func TestRequestSigner_JsonHeader(t *testing.T) {
+	rsaSigner, _ := sign.NewRSASigner(crypto.SHA256)
+	_, err := rsaSigner.RSAEncryptor.GenerateKey(2048)
+	assert.NoError(t, err)
+
+	signer, err := NewRequestSigner(&SignOptions{
+		Signer:    rsaSigner,
+		Header:    "X-Signature",
+		SkipEmpty: true,
+		Exclude:   []string{"trace"},
+	})
+	assert.NoError(t, err)
+
+	var verifyErr error
+	var signature string
+	helper := newTestRequestHelper(t, func(w http.ResponseWriter, r *http.Request) {
+		signature = r.Header.Get("X-Signature")
+		verifyErr = signer.Verify(r)
+	})
+	helper.WithMiddleware(signer.Middleware())
+
+	_, err = helper.Df().Method(http.MethodPost).Json(map[string]interface{}{
+		"amount": 100,
+		"paid":   true,
+		"memo":   "",
+		"trace":  "abc",
+		"items":  []string{"a", "b"},
+	}).Request()
+	assert.NoError(t, err)
+	assert.NoError(t, verifyErr)
+	assert.NoError(t, rsaSigner.VerifyMessage(`amount=100&items=["a","b"]&paid=true`, signature))
+}
+
+func TestRequestSigner_VerifyHandler(t *testing.T) {
+	md5Signer, _ := sign.NewMD5Signer("secret")
+	signer, _ := NewRequestSigner(&SignOptions{Signer: md5Signer})
+
+	handler := signer.VerifyHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := io.ReadAll(r.Body)
+		_, _ = w.Write(b)
+	}))
+
+	signature, _ := md5Signer.Sign("a=1")
+	request := httptest.NewRequest(http.MethodPost, "/webhook", strings.NewReader("a=1&sign="+signature))
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, request)
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	// the handler can still read the body
+	assert.Equal(t, "a=1&sign="+signature, recorder.Body.String())
+
+	request = httptest.NewRequest(http.MethodPost, "/webhook", strings.NewReader("a=2&sign="+signature))
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	recorder = httptest.NewRecorder()
+	handler.ServeHTTP(recorder, request)
+	assert.Equal(t, http.StatusUnauthorized, recorder.Code)
+
+	request = httptest.NewRequest(http.MethodGet, "/webhook?a=1", nil)
+	assert.True(t, errors.Is(signer.Verify(request), ErrSignatureMissing))
+
+	// the body beyond MaxBodySize is rejected
+	limited, _ := NewRequestSigner(&SignOptions{Signer: md5Signer, MaxBodySize: 8})
+	request = httptest.NewRequest(http.MethodPost, "/webhook", strings.NewReader("a=1&sign="+signature))
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	recorder = httptest.NewRecorder()
+	limited.VerifyHandler(handler).ServeHTTP(recorder, request)
+	assert.Equal(t, http.StatusRequestEntityTooLarge, recorder.Code)
+}
+
+func TestRequestSigner_SkipsOtherBodies(t *testing.T) {
+	md5Signer, _ := sign.NewMD5Signer("secret")
+	signer, _ := NewRequestSigner(&SignOptions{Signer: md5Signer, Header: "X-Sign"})
+
+	// a streamed body is neither read nor signed
+	body := &countingBody{Reader: strings.NewReader("--boundary--")}
+	request := httptest.NewRequest(http.MethodPost, "/upload?a=1", body)
+	request.Header.Set("Content-Type", "multipart/form-data; boundary=boundary")
+	assert.NoError(t, signer.Sign(request))
+	assert.Equal(t, 0, body.read)
+
+	signature, _ := md5Signer.Sign("a=1")
+	assert.Equal(t, signature, request.Header.Get("X-Sign"))
+}
+
+type countingBody struct {
+	io.Reader
+	read int
+}
+
+func (b *countingBody) Read(p []byte) (int, error) {
+	n, err := b.Reader.Read(p)
+	b.read += n
+	return n, err
+}
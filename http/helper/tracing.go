@@ -0,0 +1,73 @@
+package helper
+
+import (
+	"net/http"
+
+	"github.com/dadiYazZ/xin-da-libs/http/contract"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	semconv "go.opentelemetry.io/otel/semconv/v1.7.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+type TracingOptions struct {
+	// Tracer 默认使用全局 TracerProvider 的 Tracer
+	Tracer trace.Tracer
+	// Propagator 将 span 写入请求头, 默认为 W3C traceparent 和 tracestate
+	Propagator propagation.TextMapPropagator
+	// RedactQuery 中的 query 参数在 http.url 属性中替换为 REDACTED
+	RedactQuery []string
+}
+
+func (o *TracingOptions) withDefaults() TracingOptions {
+	options := TracingOptions{}
+	if o != nil {
+		options = *o
+	}
+	if options.Tracer == nil {
+		options.Tracer = otel.Tracer("github.com/dadiYazZ/xin-da-libs/http")
+	}
+	if options.Propagator == nil {
+		options.Propagator = propagation.TraceContext{}
+	}
+	return options
+}
+
+// TracingMiddleware 为每个请求创建一个 client span, 并通过 traceparent 请求头传递给服务端.
+// 请求失败或响应状态码 >= 400 时 span 的状态为 Error
+func TracingMiddleware(options *TracingOptions) contract.RequestMiddleware {
+	opts := options.withDefaults()
+
+	return func(handle contract.RequestHandle) contract.RequestHandle {
+		return func(request *http.Request) (response *http.Response, err error) {
+			ctx, span := opts.Tracer.Start(request.Context(), "HTTP "+request.Method,
+				trace.WithSpanKind(trace.SpanKindClient),
+				trace.WithAttributes(
+					semconv.HTTPMethodKey.String(request.Method),
+					semconv.HTTPURLKey.String(redactUrl(request.URL, opts.RedactQuery)),
+					semconv.NetPeerNameKey.String(request.URL.Hostname()),
+				),
+			)
+			defer span.End()
+
+			// 复制请求, 不修改调用方的请求头
+			request = request.Clone(ctx)
+			opts.Propagator.Inject(ctx, propagation.HeaderCarrier(request.Header))
+
+			response, err = handle(request)
+			if err != nil {
+				span.RecordError(err)
+				span.SetStatus(codes.Error, err.Error())
+				return response, err
+			}
+
+			span.SetAttributes(semconv.HTTPAttributesFromHTTPStatusCode(response.StatusCode)...)
+			if response.ContentLength >= 0 {
+				span.SetAttributes(semconv.HTTPResponseContentLengthKey.Int64(response.ContentLength))
+			}
+			span.SetStatus(semconv.SpanStatusFromHTTPStatusCodeAndSpanKind(response.StatusCode, trace.SpanKindClient))
+			return response, err
+		}
+	}
+}
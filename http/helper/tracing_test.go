@@ -0,0 +1,72 @@
+package helper
+
+import (
+	"context"
+	libsHelper "github.com/dadiYazZ/xin-da-libs/helper"
+	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+	"net/http"
+	"testing"
+)
+
+func spanAttribute(span sdktrace.ReadOnlySpan, key attribute.Key) attribute.Value {
+	for _, kv := range span.Attributes() {
+		if kv.Key == key {
+			return kv.Value
+		}
+	}
+	return attribute.Value{}
+}
+
+func TestTracingMiddleware(t *testing.T) {
+	traceparents := make(chan string, 1)
+	helper := newTestRequestHelper(t, func(w http.ResponseWriter, r *http.Request) {
+		traceparents <- r.Header.Get("traceparent")
+		if r.URL.Path == "/missing" {
+			w.WriteHeader(http.StatusNotFound)
+		}
+	})
+	recorder := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+	tracer := provider.Tracer("test")
+
+	log := &testLogger{}
+	helper.WithMiddleware(
+		TracingMiddleware(&TracingOptions{Tracer: tracer, RedactQuery: []string{"access_token"}}),
+		AccessLogMiddleware(&AccessLogOptions{Logger: log}),
+	)
+
+	ctx, parent := tracer.Start(context.Background(), "parent")
+	_, err := helper.Df().WithContext(ctx).Method(http.MethodGet).Uri("/orders?access_token=secret").Request()
+	assert.NoError(t, err)
+	parent.End()
+
+	spans := recorder.Ended()
+	assert.Len(t, spans, 2)
+	span := spans[0]
+	assert.Equal(t, "HTTP GET", span.Name())
+	assert.Equal(t, trace.SpanKindClient, span.SpanKind())
+	assert.Equal(t, parent.SpanContext().TraceID(), span.SpanContext().TraceID())
+	assert.Equal(t, parent.SpanContext().SpanID(), span.Parent().SpanID())
+	assert.Equal(t, int64(http.StatusOK), spanAttribute(span, "http.status_code").AsInt64())
+	assert.Equal(t, helper.config.BaseUrl+"/orders?access_token=%5BREDACTED%5D", spanAttribute(span, "http.url").AsString())
+	assert.Equal(t, codes.Unset, span.Status().Code)
+
+	// the server receives the span of the request
+	traceparent := <-traceparents
+	assert.Equal(t, "00-"+span.SpanContext().TraceID().String()+"-"+span.SpanContext().SpanID().String()+"-01", traceparent)
+
+	// the access log runs inside the span
+	assert.Equal(t, libsHelper.TraceIDFromContext(ctx), log.entries[0].fields["trace_id"])
+
+	_, err = helper.Df().Method(http.MethodGet).Uri("/missing").Request()
+	assert.NoError(t, err)
+	<-traceparents
+	spans = recorder.Ended()
+	assert.Equal(t, codes.Error, spans[2].Status().Code)
+	assert.False(t, spans[2].Parent().IsValid())
+}
@@ -0,0 +1,61 @@
+package oauth2
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/dadiYazZ/xin-da-libs/http/contract"
+	"github.com/dadiYazZ/xin-da-libs/http/helper"
+)
+
+// InvalidatingTokenSource 可以丢弃被服务端拒绝的 token, CachedTokenSource 实现了该接口
+type InvalidatingTokenSource interface {
+	TokenSource
+	Invalidate(ctx context.Context, token *Token) error
+}
+
+// Middleware 在请求头中设置 token. 服务端返回 401 且 source 实现了 InvalidatingTokenSource 时,
+// 丢弃该 token 并使用新的 token 重试一次, 请求 body 需要可以通过 GetBody 重放
+func Middleware(source TokenSource) contract.RequestMiddleware {
+	return func(handle contract.RequestHandle) contract.RequestHandle {
+		return func(request *http.Request) (*http.Response, error) {
+			ctx := request.Context()
+			token, err := source.Token(ctx)
+			if err != nil {
+				return nil, err
+			}
+
+			response, err := handle(authorize(request, token))
+			if err != nil || response.StatusCode != http.StatusUnauthorized {
+				return response, err
+			}
+			invalidating, ok := source.(InvalidatingTokenSource)
+			if !ok {
+				return response, err
+			}
+			retry, replayErr := helper.ReplayRequest(request)
+			if replayErr != nil {
+				return response, err
+			}
+
+			err = invalidating.Invalidate(ctx, token)
+			if err != nil {
+				return response, nil
+			}
+			token, err = source.Token(ctx)
+			if err != nil {
+				return response, nil
+			}
+			helper.DrainBody(response)
+
+			return handle(authorize(retry, token))
+		}
+	}
+}
+
+// authorize 复制请求并设置 Authorization, 不修改调用方的请求头
+func authorize(request *http.Request, token *Token) *http.Request {
+	authorized := request.Clone(request.Context())
+	authorized.Header.Set("Authorization", token.Authorization())
+	return authorized
+}
@@ -0,0 +1,198 @@
+package oauth2
+
+import (
+	"context"
+	"errors"
+	"github.com/alicebob/miniredis/v2"
+	"github.com/dadiYazZ/xin-da-libs/cache"
+	"github.com/dadiYazZ/xin-da-libs/http/helper"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// newTestTokenServer issues access-<n> tokens, and rotates the refresh token on each refresh
+func newTestTokenServer(t *testing.T, expiresIn string) (*httptest.Server, *int32) {
+	var issued int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		clientId, clientSecret, ok := r.BasicAuth()
+		if !ok {
+			clientId, clientSecret = r.FormValue("client_id"), r.FormValue("client_secret")
+		}
+		if clientId != "client" || clientSecret != "secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			_, _ = w.Write([]byte(`{"error":"invalid_client","error_description":"bad credentials"}`))
+			return
+		}
+
+		n := strconv.Itoa(int(atomic.AddInt32(&issued, 1)))
+		switch r.FormValue("grant_type") {
+		case GRANT_TYPE_CLIENT_CREDENTIALS:
+			_, _ = w.Write([]byte(`{"access_token":"access-` + n + `","token_type":"bearer","expires_in":` + expiresIn + `,"scope":"` + r.FormValue("scope") + `"}`))
+		case GRANT_TYPE_REFRESH_TOKEN:
+			if r.FormValue("refresh_token") == "" {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			_, _ = w.Write([]byte(`{"access_token":"access-` + n + `","expires_in":` + expiresIn + `,"refresh_token":"refresh-` + n + `"}`))
+		}
+	}))
+	t.Cleanup(server.Close)
+	return server, &issued
+}
+
+func newTestMemCache(t *testing.T) cache.CacheInterface {
+	memCache, err := cache.NewMemCacheWithOptions(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return memCache
+}
+
+func TestClientCredentials(t *testing.T) {
+	server, issued := newTestTokenServer(t, `"7200"`)
+	config := &Config{TokenUrl: server.URL, ClientId: "client", ClientSecret: "secret", Scopes: []string{"read", "write"}}
+
+	source, err := NewCachedTokenSource(config.ClientCredentials(), &CachedTokenSourceOptions{
+		Cache: newTestMemCache(t),
+		Key:   "oauth2:token:client",
+	})
+	assert.NoError(t, err)
+
+	token, err := source.Token(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, "access-1", token.AccessToken)
+	assert.Equal(t, "Bearer access-1", token.Authorization())
+	assert.WithinDuration(t, time.Now().Add(2*time.Hour), token.Expiry, time.Minute)
+
+	// the token is reused until it expires
+	token, err = source.Token(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, "access-1", token.AccessToken)
+
+	source.now = func() time.Time { return time.Now().Add(2 * time.Hour) }
+	token, err = source.Token(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, "access-2", token.AccessToken)
+	assert.Equal(t, int32(2), atomic.LoadInt32(issued))
+
+	config.AuthInParams = true
+	token, err = config.ClientCredentials()(context.Background(), nil)
+	assert.NoError(t, err)
+	assert.Equal(t, "access-3", token.AccessToken)
+
+	config.ClientSecret = "wrong"
+	_, err = config.ClientCredentials()(context.Background(), nil)
+	var tokenErr *TokenError
+	assert.True(t, errors.As(err, &tokenErr))
+	assert.Equal(t, "invalid_client", tokenErr.ErrorCode)
+}
+
+func TestRefreshToken(t *testing.T) {
+	server, _ := newTestTokenServer(t, "3600")
+	config := &Config{TokenUrl: server.URL, ClientId: "client", ClientSecret: "secret"}
+	memCache := newTestMemCache(t)
+
+	source, err := NewCachedTokenSource(config.RefreshToken("refresh-0"), &CachedTokenSourceOptions{
+		Cache: memCache,
+		Key:   "oauth2:token:user",
+	})
+	assert.NoError(t, err)
+
+	token, err := source.Token(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, "access-1", token.AccessToken)
+	assert.Equal(t, "refresh-1", token.RefreshToken)
+
+	// the rotated refresh token is read from the cache
+	source.now = func() time.Time { return time.Now().Add(time.Hour) }
+	token, err = source.Token(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, "access-2", token.AccessToken)
+
+	cached, err := cache.Get[Token](memCache, "oauth2:token:user")
+	assert.NoError(t, err)
+	assert.Equal(t, "refresh-2", cached.RefreshToken)
+
+	_, err = config.RefreshToken("")(context.Background(), nil)
+	assert.Equal(t, ErrNoRefreshToken, err)
+}
+
+func TestCachedTokenSource_SharedLock(t *testing.T) {
+	server, issued := newTestTokenServer(t, "7200")
+	config := &Config{TokenUrl: server.URL, ClientId: "client", ClientSecret: "secret"}
+	redisServer := miniredis.RunT(t)
+
+	// instances sharing the redis fetch the token once
+	wg := sync.WaitGroup{}
+	tokens := make(chan string, 10)
+	for i := 0; i < 10; i++ {
+		gr := cache.NewGRedis(&redis.UniversalOptions{Addrs: []string{redisServer.Addr()}})
+		source, err := NewCachedTokenSource(config.ClientCredentials(), &CachedTokenSourceOptions{
+			Cache: gr,
+			Key:   "oauth2:token:client",
+		})
+		assert.NoError(t, err)
+		assert.NotNil(t, source.options.Locker)
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			token, err := source.Token(context.Background())
+			assert.NoError(t, err)
+			tokens <- token.AccessToken
+		}()
+	}
+	wg.Wait()
+	close(tokens)
+
+	for token := range tokens {
+		assert.Equal(t, "access-1", token)
+	}
+	assert.Equal(t, int32(1), atomic.LoadInt32(issued))
+}
+
+func TestMiddleware(t *testing.T) {
+	tokenServer, issued := newTestTokenServer(t, "7200")
+	config := &Config{TokenUrl: tokenServer.URL, ClientId: "client", ClientSecret: "secret"}
+	source, err := NewCachedTokenSource(config.ClientCredentials(), &CachedTokenSourceOptions{
+		Cache: newTestMemCache(t),
+		Key:   "oauth2:token:client",
+	})
+	assert.NoError(t, err)
+
+	// the api revokes access-1
+	var bodies []string
+	api := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") == "Bearer access-1" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		body, _ := io.ReadAll(r.Body)
+		bodies = append(bodies, string(body))
+		_, _ = w.Write([]byte(r.Header.Get("Authorization")))
+	}))
+	defer api.Close()
+
+	requestHelper, err := helper.NewRequestHelper(&helper.Config{BaseUrl: api.URL})
+	assert.NoError(t, err)
+	requestHelper.WithMiddleware(Middleware(source))
+
+	var result string
+	err = requestHelper.Df().Method(http.MethodPost).Uri("/orders").Json(map[string]int{"amount": 1}).Result(&result)
+	assert.NoError(t, err)
+	assert.Equal(t, "Bearer access-2", result)
+	assert.Equal(t, []string{`{"amount":1}` + "\n"}, bodies)
+
+	err = requestHelper.Df().Method(http.MethodGet).Uri("/orders").Result(&result)
+	assert.NoError(t, err)
+	assert.Equal(t, int32(2), atomic.LoadInt32(issued))
+}
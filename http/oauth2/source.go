@@ -0,0 +1,153 @@
+package oauth2
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/dadiYazZ/xin-da-libs/cache"
+	"github.com/pkg/errors"
+)
+
+// DEFAULT_EXPIRY_LEEWAY 是 token 过期前提前刷新的时间
+const DEFAULT_EXPIRY_LEEWAY = time.Minute
+
+// Locker 在多个实例之间串行化 token 的刷新, *cache.GRedis 实现了该接口
+type Locker interface {
+	Lock(ctx context.Context, key string, options *cache.LockOptions) (*cache.Lock, error)
+}
+
+type CachedTokenSourceOptions struct {
+	// Cache 必填, 保存 token, 多个实例使用相同的 Cache 和 Key 共享 token
+	Cache cache.CacheInterface
+	// Key 必填, 保存 token 的缓存 key, 例如 oauth2:token:<client_id>
+	Key string
+	// ExpiryLeeway 默认为 DEFAULT_EXPIRY_LEEWAY
+	ExpiryLeeway time.Duration
+	// Locker 为空且 Cache 实现了 Locker 时使用 Cache 加锁, 否则只在进程内加锁
+	Locker Locker
+	// LockOptions 是刷新 token 时分布式锁的配置
+	LockOptions *cache.LockOptions
+}
+
+// CachedTokenSource 将 token 保存在 Cache 中, token 即将过期时在锁内通过 FetchFunc 刷新.
+// 拿到锁后会再次读取缓存, 其它实例已经刷新过的 token 直接使用, 同一时间只有一个实例请求授权服务器
+type CachedTokenSource struct {
+	fetch   FetchFunc
+	options CachedTokenSourceOptions
+	now     func() time.Time
+
+	// mu 串行化进程内的刷新, token 是进程内缓存的副本
+	mu    sync.Mutex
+	token *Token
+}
+
+// NewCachedTokenSource 在 options.Cache 或 options.Key 为空时返回错误
+func NewCachedTokenSource(fetch FetchFunc, options *CachedTokenSourceOptions) (*CachedTokenSource, error) {
+	if fetch == nil {
+		return nil, errors.New("oauth2: nil fetch func")
+	}
+	opts := CachedTokenSourceOptions{}
+	if options != nil {
+		opts = *options
+	}
+	if opts.Cache == nil || opts.Key == "" {
+		return nil, errors.New("oauth2: cache and key are required")
+	}
+	if opts.ExpiryLeeway <= 0 {
+		opts.ExpiryLeeway = DEFAULT_EXPIRY_LEEWAY
+	}
+	if opts.Locker == nil {
+		if locker, ok := opts.Cache.(Locker); ok {
+			opts.Locker = locker
+		}
+	}
+
+	return &CachedTokenSource{
+		fetch:   fetch,
+		options: opts,
+		now:     time.Now,
+	}, nil
+}
+
+func (s *CachedTokenSource) Token(ctx context.Context) (*Token, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.token.Valid(s.now(), s.options.ExpiryLeeway) {
+		return s.token, nil
+	}
+
+	current := s.cached()
+	if current.Valid(s.now(), s.options.ExpiryLeeway) {
+		s.token = current
+		return current, nil
+	}
+
+	if s.options.Locker != nil {
+		lock, err := s.options.Locker.Lock(ctx, s.options.Key+":lock", s.options.LockOptions)
+		if err != nil {
+			return nil, errors.Wrap(err, "oauth2: lock token failed")
+		}
+		defer lock.Unlock(context.Background())
+
+		// 等锁期间其它实例可能已经刷新了 token
+		current = s.cached()
+		if current.Valid(s.now(), s.options.ExpiryLeeway) {
+			s.token = current
+			return current, nil
+		}
+	}
+	if current == nil {
+		current = s.token
+	}
+
+	token, err := s.fetch(ctx, current)
+	if err != nil {
+		return nil, err
+	}
+	s.token = token
+	s.save(token)
+	return token, nil
+}
+
+// Invalidate 丢弃被服务端拒绝的 token, 只有缓存中仍然是该 token 时才删除, 避免删掉其它实例刚刷新的 token
+func (s *CachedTokenSource) Invalidate(ctx context.Context, token *Token) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.token != nil && s.token.AccessToken == token.AccessToken {
+		s.token = nil
+	}
+	current := s.cached()
+	if current == nil || current.AccessToken != token.AccessToken {
+		return nil
+	}
+	if current.RefreshToken == "" {
+		return s.options.Cache.Delete(s.options.Key)
+	}
+	// 保留 refresh_token, 使 refresh_token 模式可以继续刷新
+	current.AccessToken = ""
+	current.Expiry = time.Time{}
+	return s.options.Cache.Forever(s.options.Key, current)
+}
+
+// cached 读取缓存中的 token, 没有或读取失败时返回 nil
+func (s *CachedTokenSource) cached() *Token {
+	token, err := cache.Get[Token](s.options.Cache, s.options.Key)
+	if err != nil {
+		return nil
+	}
+	return &token
+}
+
+// save 保存 token, 有 refresh_token 的 token 永久保存, 过期后仍然可以用于刷新
+func (s *CachedTokenSource) save(token *Token) {
+	if token.Expiry.IsZero() || token.RefreshToken != "" {
+		_ = s.options.Cache.Forever(s.options.Key, token)
+		return
+	}
+	if ttl := token.Expiry.Sub(s.now()); ttl > 0 {
+		_ = s.options.Cache.Set(s.options.Key, token, ttl)
+	}
+}
@@ -0,0 +1,176 @@
+package oauth2
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/dadiYazZ/xin-da-libs/http/helper"
+	"github.com/pkg/errors"
+)
+
+const (
+	GRANT_TYPE_CLIENT_CREDENTIALS = "client_credentials"
+	GRANT_TYPE_REFRESH_TOKEN      = "refresh_token"
+)
+
+// ErrNoRefreshToken 刷新 token 时没有可用的 refresh_token
+var ErrNoRefreshToken = errors.New("oauth2: no refresh token")
+
+// Token 是授权服务器返回的 access token, 会被整体保存到缓存中
+type Token struct {
+	AccessToken  string `json:"access_token"`
+	TokenType    string `json:"token_type,omitempty"`
+	RefreshToken string `json:"refresh_token,omitempty"`
+	// Expiry 为零值时 token 不会过期
+	Expiry time.Time `json:"expiry,omitempty"`
+}
+
+// Valid 判断 token 在 now 之后的 leeway 时间内是否仍然有效
+func (t *Token) Valid(now time.Time, leeway time.Duration) bool {
+	if t == nil || t.AccessToken == "" {
+		return false
+	}
+	return t.Expiry.IsZero() || now.Add(leeway).Before(t.Expiry)
+}
+
+// Authorization 返回 Authorization 请求头的值, token_type 默认为 Bearer
+func (t *Token) Authorization() string {
+	tokenType := t.TokenType
+	if tokenType == "" || strings.EqualFold(tokenType, "bearer") {
+		tokenType = "Bearer"
+	}
+	return tokenType + " " + t.AccessToken
+}
+
+// TokenSource 返回一个有效的 token
+type TokenSource interface {
+	Token(ctx context.Context) (*Token, error)
+}
+
+// FetchFunc 从授权服务器获取新的 token, current 是缓存中已经过期的 token, 可能为 nil
+type FetchFunc func(ctx context.Context, current *Token) (*Token, error)
+
+// TokenError 是授权服务器按 RFC 6749 返回的错误
+type TokenError struct {
+	ErrorCode        string `json:"error"`
+	ErrorDescription string `json:"error_description,omitempty"`
+	ErrorUri         string `json:"error_uri,omitempty"`
+}
+
+func (e *TokenError) Error() string {
+	message := "oauth2: " + e.ErrorCode
+	if e.ErrorDescription != "" {
+		message += ": " + e.ErrorDescription
+	}
+	return message
+}
+
+type Config struct {
+	TokenUrl     string
+	ClientId     string
+	ClientSecret string
+	Scopes       []string
+	// AuthInParams 将 client_id 和 client_secret 放在表单中, 默认使用 Basic 认证
+	AuthInParams bool
+	// EndpointParams 是附加到 token 请求中的参数, 例如 audience
+	EndpointParams url.Values
+	// RequestHelper 用于发出 token 请求, 可以设置重试和日志等中间件. 为空时使用默认配置创建
+	RequestHelper *helper.RequestHelper
+}
+
+// ClientCredentials 返回 client_credentials 模式获取 token 的 FetchFunc
+func (c *Config) ClientCredentials() FetchFunc {
+	return func(ctx context.Context, current *Token) (*Token, error) {
+		values := url.Values{"grant_type": {GRANT_TYPE_CLIENT_CREDENTIALS}}
+		if len(c.Scopes) > 0 {
+			values.Set("scope", strings.Join(c.Scopes, " "))
+		}
+		return c.retrieve(ctx, values)
+	}
+}
+
+// RefreshToken 返回 refresh_token 模式获取 token 的 FetchFunc, 缓存的 token 中有 refresh_token 时优先使用它,
+// 使授权服务器轮换的 refresh_token 在实例之间共享. 响应中没有 refresh_token 时保留原来的
+func (c *Config) RefreshToken(refreshToken string) FetchFunc {
+	return func(ctx context.Context, current *Token) (*Token, error) {
+		usedToken := refreshToken
+		if current != nil && current.RefreshToken != "" {
+			usedToken = current.RefreshToken
+		}
+		if usedToken == "" {
+			return nil, ErrNoRefreshToken
+		}
+
+		token, err := c.retrieve(ctx, url.Values{
+			"grant_type":    {GRANT_TYPE_REFRESH_TOKEN},
+			"refresh_token": {usedToken},
+		})
+		if err != nil {
+			return nil, err
+		}
+		if token.RefreshToken == "" {
+			token.RefreshToken = usedToken
+		}
+		return token, nil
+	}
+}
+
+// tokenResponse 的 expires_in 兼容数字和字符串
+type tokenResponse struct {
+	AccessToken  string      `json:"access_token"`
+	TokenType    string      `json:"token_type"`
+	RefreshToken string      `json:"refresh_token"`
+	ExpiresIn    json.Number `json:"expires_in"`
+}
+
+func (c *Config) retrieve(ctx context.Context, values url.Values) (*Token, error) {
+	requestHelper := c.RequestHelper
+	if requestHelper == nil {
+		var err error
+		requestHelper, err = helper.NewRequestHelper(&helper.Config{})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	for key, params := range c.EndpointParams {
+		values[key] = params
+	}
+	df := requestHelper.Df().WithContext(ctx).Method(http.MethodPost).Url(c.TokenUrl).
+		Header("Accept", "application/json")
+	if c.AuthInParams {
+		values.Set("client_id", c.ClientId)
+		values.Set("client_secret", c.ClientSecret)
+	} else {
+		df.Header("Authorization", "Basic "+basicAuth(c.ClientId, c.ClientSecret))
+	}
+
+	result := &tokenResponse{}
+	err := df.FormValues(values).ErrorResult(0, &TokenError{}).Result(result)
+	if err != nil {
+		return nil, errors.Wrap(err, "oauth2: retrieve token failed")
+	}
+	if result.AccessToken == "" {
+		return nil, errors.New("oauth2: server response missing access_token")
+	}
+
+	token := &Token{
+		AccessToken:  result.AccessToken,
+		TokenType:    result.TokenType,
+		RefreshToken: result.RefreshToken,
+	}
+	if expiresIn, err := result.ExpiresIn.Int64(); err == nil && expiresIn > 0 {
+		token.Expiry = time.Now().Add(time.Duration(expiresIn) * time.Second)
+	}
+	return token, nil
+}
+
+// basicAuth 按 RFC 6749 对 client_id 和 client_secret 做 url 编码后再 base64 编码
+func basicAuth(clientId string, clientSecret string) string {
+	return base64.StdEncoding.EncodeToString([]byte(url.QueryEscape(clientId) + ":" + url.QueryEscape(clientSecret)))
+}
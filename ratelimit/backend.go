@@ -0,0 +1,125 @@
+package ratelimit
+
+import (
+	"context"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/dadiYazZ/xin-da-libs/cache"
+	"github.com/redis/go-redis/v9"
+)
+
+// algorithm decides on the state of a key for the local backend, the state is stored only if the events are allowed
+type algorithm[S any] func(state *S, found bool, now time.Time, n int, maxDelay time.Duration) outcome
+
+// newBackend runs the Lua script of the algorithm on a GRedis or on the far tier of a LayeredCache,
+// and the algorithm itself on the other drivers
+func newBackend[S any](store cache.CacheInterface, script *redis.Script, params func() []interface{}, run algorithm[S]) backend {
+	// the state of a LayeredCache is shared through its redis tier, the near tier would keep a limit per instance
+	if layered, ok := store.(*cache.LayeredCache); ok {
+		store = layered.Far()
+	}
+	if gr, ok := store.(*cache.GRedis); ok {
+		return &redisBackend{
+			gr:     gr,
+			script: script,
+			params: params,
+		}
+	}
+
+	return &localBackend[S]{
+		store: store,
+		run:   run,
+	}
+}
+
+// localBackend keeps the state in a process local driver such as MemCache,
+// the limiter serializes its decisions so the store must not be shared with another limiter of the same algorithm
+type localBackend[S any] struct {
+	store cache.CacheInterface
+	mu    sync.Mutex
+	run   algorithm[S]
+}
+
+func (b *localBackend[S]) take(ctx context.Context, key string, now time.Time, n int, maxDelay time.Duration) (outcome, error) {
+	if err := ctx.Err(); err != nil {
+		return outcome{}, err
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	state, err := cache.Get[S](b.store, key)
+	if err != nil && err != cache.ErrCacheMiss {
+		return outcome{}, err
+	}
+
+	o := b.run(&state, err == nil, now, n, maxDelay)
+	if o.allowed {
+		// the state is useless once the limiter is back to its full capacity
+		err = b.store.Set(key, state, o.reset+time.Millisecond)
+	}
+	return o, err
+}
+
+func (b *localBackend[S]) reset(ctx context.Context, key string) error {
+	return b.store.Delete(key)
+}
+
+// redisBackend runs the Lua script of an algorithm, so that the decision is atomic across the instances.
+// The scripts take ARGV[1] the current time, ARGV[2] the number of events and ARGV[3] the max delay
+// followed by the params of the algorithm, the times being in micro seconds.
+// They return the allowed flag, the remaining events, the delay and the reset time
+type redisBackend struct {
+	gr     *cache.GRedis
+	script *redis.Script
+	params func() []interface{}
+}
+
+func (b *redisBackend) take(ctx context.Context, key string, now time.Time, n int, maxDelay time.Duration) (outcome, error) {
+	maxDelayMicro := int64(-1)
+	if maxDelay >= 0 {
+		maxDelayMicro = maxDelay.Microseconds()
+	}
+
+	args := append([]interface{}{now.UnixMicro(), n, maxDelayMicro}, b.params()...)
+	values, err := b.gr.RunScript(ctx, b.script, []string{key}, args...).Slice()
+	if err != nil {
+		return outcome{}, err
+	}
+
+	o := outcome{allowed: values[0] == int64(1)}
+	o.remaining, err = parseFloat(values[1])
+	if err != nil {
+		return outcome{}, err
+	}
+	delay, err := parseFloat(values[2])
+	if err != nil {
+		return outcome{}, err
+	}
+	reset, err := parseFloat(values[3])
+	if err != nil {
+		return outcome{}, err
+	}
+	o.delay = microseconds(delay)
+	o.reset = microseconds(reset)
+
+	return o, nil
+}
+
+func (b *redisBackend) reset(ctx context.Context, key string) error {
+	return b.gr.DeleteCtx(ctx, key)
+}
+
+func parseFloat(value interface{}) (float64, error) {
+	str, _ := value.(string)
+	return strconv.ParseFloat(str, 64)
+}
+
+func microseconds(value float64) time.Duration {
+	if value <= 0 {
+		return 0
+	}
+	return time.Duration(value * float64(time.Microsecond))
+}
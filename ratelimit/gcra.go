@@ -0,0 +1,82 @@
+package ratelimit
+
+import (
+	"math"
+	"time"
+
+	"github.com/dadiYazZ/xin-da-libs/cache"
+	"github.com/redis/go-redis/v9"
+)
+
+// SCRIPT_GCRA keeps the theoretical arrival time of KEYS[1], ARGV[4] is the emission interval and ARGV[5] the tolerance
+const SCRIPT_GCRA = `local now = tonumber(ARGV[1])
+local n = tonumber(ARGV[2])
+local max_delay = tonumber(ARGV[3])
+local interval = tonumber(ARGV[4])
+local tolerance = tonumber(ARGV[5])
+
+local tat = tonumber(redis.call('get', KEYS[1]))
+if tat == nil or tat < now then
+	tat = now
+end
+
+local new_tat = tat + interval * n
+local delay = new_tat - tolerance - now
+if delay < 0 then
+	delay = 0
+end
+
+local allowed = 1
+if delay > 0 and max_delay >= 0 and delay > max_delay then
+	allowed = 0
+end
+if allowed == 1 then
+	tat = new_tat
+	redis.call('set', KEYS[1], string.format('%.17g', tat), 'PX', math.ceil((tat - now) / 1000) + 1)
+end
+
+local remaining = (now + tolerance - tat) / interval
+return {allowed, string.format('%.17g', remaining), string.format('%.17g', delay), string.format('%.17g', tat - now)}`
+
+var gcraScript = redis.NewScript(SCRIPT_GCRA)
+
+type gcraState struct {
+	// TAT is the theoretical arrival time of the next event, in unix nano seconds
+	TAT float64 `json:"tat"`
+}
+
+// NewGCRA returns a limiter running the generic cell rate algorithm: the events are spaced by Period/Rate,
+// and up to Burst of them may come at once. It only stores a timestamp per key.
+// The decisions are made by a Lua script on a GRedis or a LayeredCache, and locally on the other drivers.
+// It panics with ErrInvalidLimit unless Rate and Period are positive
+func NewGCRA(store cache.CacheInterface, limit Limit) Limiter {
+	limit.mustValidate()
+	interval := float64(limit.Period) / float64(limit.Rate)
+	tolerance := interval * float64(limit.burst())
+
+	params := func() []interface{} {
+		return []interface{}{interval / float64(time.Microsecond), tolerance / float64(time.Microsecond)}
+	}
+
+	run := func(state *gcraState, found bool, now time.Time, n int, maxDelay time.Duration) outcome {
+		nowNano := float64(now.UnixNano())
+		tat := state.TAT
+		if !found || tat < nowNano {
+			tat = nowNano
+		}
+
+		newTat := tat + interval*float64(n)
+		o := outcome{delay: time.Duration(math.Max(0, math.Ceil(newTat-tolerance-nowNano)))}
+		o.allowed = o.delay == 0 || maxDelay < 0 || o.delay <= maxDelay
+		if o.allowed {
+			tat = newTat
+			state.TAT = tat
+		}
+		o.remaining = (nowNano + tolerance - tat) / interval
+		o.reset = time.Duration(math.Ceil(tat - nowNano))
+
+		return o
+	}
+
+	return newLimiter(limit, "gcra", limit.burst(), newBackend(store, gcraScript, params, run))
+}
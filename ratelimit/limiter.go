@@ -0,0 +1,217 @@
+package ratelimit
+
+import (
+	"context"
+	"errors"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// DEFAULT_KEY_PREFIX prefixes the cache keys holding the state of the limiters
+const DEFAULT_KEY_PREFIX = "ratelimit:"
+
+var (
+	ErrBurstExceeded = errors.New("ratelimit: n exceeds the burst of the limit")
+	ErrWaitTooLong   = errors.New("ratelimit: wait would exceed the context deadline")
+	ErrInvalidN      = errors.New("ratelimit: n must be positive")
+	ErrInvalidLimit  = errors.New("ratelimit: rate and period of the limit must be positive")
+)
+
+// Limit allows Rate events per Period, with at most Burst of them at once
+type Limit struct {
+	Rate   int
+	Period time.Duration
+	// Burst is the capacity of the token bucket and of GCRA, Rate if not set. The sliding window log ignores it
+	Burst int
+}
+
+func PerSecond(rate int) Limit {
+	return Limit{Rate: rate, Period: time.Second}
+}
+
+func PerMinute(rate int) Limit {
+	return Limit{Rate: rate, Period: time.Minute}
+}
+
+func PerHour(rate int) Limit {
+	return Limit{Rate: rate, Period: time.Hour}
+}
+
+// mustValidate panics with ErrInvalidLimit unless Rate and Period are positive, for the constructors
+func (limit Limit) mustValidate() {
+	if limit.Rate <= 0 || limit.Period <= 0 {
+		panic(ErrInvalidLimit)
+	}
+}
+
+func (limit Limit) burst() int {
+	if limit.Burst <= 0 {
+		return limit.Rate
+	}
+	return limit.Burst
+}
+
+// Result describes the decision of a limiter, and the state of the key after it
+type Result struct {
+	Limit   Limit
+	Allowed bool
+	// Remaining is how many events are allowed right now
+	Remaining int
+	// RetryAfter is how long to wait before the denied events are allowed, 0 if they were allowed
+	RetryAfter time.Duration
+	// ResetAfter is how long until the limiter is back to its full capacity
+	ResetAfter time.Duration
+}
+
+// Headers returns the X-RateLimit-* headers of the result, and Retry-After if it was denied
+func (result *Result) Headers() http.Header {
+	header := http.Header{}
+	header.Set("X-RateLimit-Limit", strconv.Itoa(result.Limit.Rate))
+	header.Set("X-RateLimit-Remaining", strconv.Itoa(result.Remaining))
+	header.Set("X-RateLimit-Reset", strconv.FormatInt(ceilSeconds(result.ResetAfter), 10))
+	if !result.Allowed {
+		header.Set("Retry-After", strconv.FormatInt(ceilSeconds(result.RetryAfter), 10))
+	}
+	return header
+}
+
+// Reservation is granted by Reserve, the reserved events may happen once Delay has elapsed
+type Reservation struct {
+	Result
+	Delay time.Duration
+}
+
+type Limiter interface {
+	// Allow is AllowN with n = 1
+	Allow(ctx context.Context, key string) (*Result, error)
+	// AllowN reports whether n events may happen now, they are accounted only if they are allowed
+	AllowN(ctx context.Context, key string, n int) (*Result, error)
+	// Reserve accounts n events right away, they may happen once the Delay of the reservation has elapsed
+	Reserve(ctx context.Context, key string, n int) (*Reservation, error)
+	// Wait blocks until n events may happen, it returns ErrWaitTooLong without accounting them
+	// if they would not be allowed before the deadline of ctx
+	Wait(ctx context.Context, key string, n int) (*Result, error)
+	// Reset forgets the events of key
+	Reset(ctx context.Context, key string) error
+}
+
+// outcome is what an algorithm decides, the durations are in nano seconds
+type outcome struct {
+	allowed   bool
+	remaining float64
+	delay     time.Duration
+	reset     time.Duration
+}
+
+// backend runs an algorithm on the state of key, maxDelay is how long the events may be delayed, -1 for unbounded
+type backend interface {
+	take(ctx context.Context, key string, now time.Time, n int, maxDelay time.Duration) (outcome, error)
+	reset(ctx context.Context, key string) error
+}
+
+type limiter struct {
+	limit Limit
+	// capacity is the most events the algorithm can ever allow at once
+	capacity int
+	prefix   string
+	backend  backend
+	now      func() time.Time
+}
+
+func newLimiter(limit Limit, algorithm string, capacity int, backend backend) *limiter {
+	return &limiter{
+		limit:    limit,
+		capacity: capacity,
+		prefix:   DEFAULT_KEY_PREFIX + algorithm + ":",
+		backend:  backend,
+		now:      time.Now,
+	}
+}
+
+func (l *limiter) Allow(ctx context.Context, key string) (*Result, error) {
+	return l.AllowN(ctx, key, 1)
+}
+
+func (l *limiter) AllowN(ctx context.Context, key string, n int) (*Result, error) {
+	o, err := l.take(ctx, key, n, 0)
+	if err != nil {
+		return nil, err
+	}
+	return l.result(o), nil
+}
+
+func (l *limiter) Reserve(ctx context.Context, key string, n int) (*Reservation, error) {
+	o, err := l.take(ctx, key, n, -1)
+	if err != nil {
+		return nil, err
+	}
+	return &Reservation{
+		Result: *l.result(o),
+		Delay:  o.delay,
+	}, nil
+}
+
+func (l *limiter) Wait(ctx context.Context, key string, n int) (*Result, error) {
+	maxDelay := time.Duration(-1)
+	if deadline, ok := ctx.Deadline(); ok {
+		maxDelay = time.Until(deadline)
+		if maxDelay < 0 {
+			return nil, ctx.Err()
+		}
+	}
+
+	o, err := l.take(ctx, key, n, maxDelay)
+	if err != nil {
+		return nil, err
+	}
+	result := l.result(o)
+	if !o.allowed {
+		return result, ErrWaitTooLong
+	}
+	if o.delay <= 0 {
+		return result, nil
+	}
+
+	// the events are accounted already, they are not given back if ctx is done meanwhile
+	timer := time.NewTimer(o.delay)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case <-timer.C:
+		return result, nil
+	}
+}
+
+func (l *limiter) Reset(ctx context.Context, key string) error {
+	return l.backend.reset(ctx, l.prefix+key)
+}
+
+func (l *limiter) take(ctx context.Context, key string, n int, maxDelay time.Duration) (outcome, error) {
+	if n <= 0 {
+		return outcome{}, ErrInvalidN
+	}
+	if n > l.capacity {
+		return outcome{}, ErrBurstExceeded
+	}
+	return l.backend.take(ctx, l.prefix+key, l.now(), n, maxDelay)
+}
+
+func (l *limiter) result(o outcome) *Result {
+	result := &Result{
+		Limit:      l.limit,
+		Allowed:    o.allowed,
+		Remaining:  int(math.Max(0, math.Floor(o.remaining))),
+		ResetAfter: o.reset,
+	}
+	if !o.allowed {
+		result.RetryAfter = o.delay
+	}
+	return result
+}
+
+func ceilSeconds(d time.Duration) int64 {
+	return int64(math.Ceil(d.Seconds()))
+}
@@ -0,0 +1,196 @@
+package ratelimit
+
+import (
+	"context"
+	"github.com/alicebob/miniredis/v2"
+	"github.com/dadiYazZ/xin-da-libs/cache"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+type testClock struct {
+	now time.Time
+}
+
+func (c *testClock) Now() time.Time {
+	return c.now
+}
+
+func (c *testClock) Advance(d time.Duration) {
+	c.now = c.now.Add(d)
+}
+
+func newTestStores(t *testing.T) map[string]func(t *testing.T) cache.CacheInterface {
+	return map[string]func(t *testing.T) cache.CacheInterface{
+		"MemCache": func(t *testing.T) cache.CacheInterface {
+			memCache, err := cache.NewMemCacheWithOptions(nil)
+			assert.NoError(t, err)
+			t.Cleanup(func() { memCache.Close() })
+			return memCache
+		},
+		"GRedis": func(t *testing.T) cache.CacheInterface {
+			server := miniredis.RunT(t)
+			return cache.NewGRedis(&redis.UniversalOptions{Addrs: []string{server.Addr()}})
+		},
+		"LayeredCache": func(t *testing.T) cache.CacheInterface {
+			server := miniredis.RunT(t)
+			layered, err := cache.NewLayeredCache(cache.NewGRedis(&redis.UniversalOptions{Addrs: []string{server.Addr()}}), nil)
+			assert.NoError(t, err)
+			t.Cleanup(func() { _ = layered.Close() })
+			return layered
+		},
+	}
+}
+
+func newTestLimiter(t *testing.T, newLimiter func(cache.CacheInterface, Limit) Limiter, store cache.CacheInterface, limit Limit) (*limiter, *testClock) {
+	clock := &testClock{now: time.Unix(1700000000, 0)}
+	l := newLimiter(store, limit).(*limiter)
+	l.now = clock.Now
+	return l, clock
+}
+
+func Test_Limiters(t *testing.T) {
+	third := time.Second / 3
+	algorithms := map[string]struct {
+		newLimiter func(cache.CacheInterface, Limit) Limiter
+		retryAfter time.Duration
+	}{
+		"TokenBucket":      {NewTokenBucket, third},
+		"GCRA":             {NewGCRA, third},
+		"SlidingWindowLog": {NewSlidingWindowLog, time.Second},
+	}
+
+	for algorithmName, algorithm := range algorithms {
+		for storeName, newStore := range newTestStores(t) {
+			t.Run(algorithmName+"/"+storeName, func(t *testing.T) {
+				l, clock := newTestLimiter(t, algorithm.newLimiter, newStore(t), PerSecond(3))
+				ctx := context.Background()
+
+				for remaining := 2; remaining >= 0; remaining-- {
+					result, err := l.Allow(ctx, "user")
+					assert.NoError(t, err)
+					assert.True(t, result.Allowed)
+					assert.Equal(t, remaining, result.Remaining)
+				}
+
+				result, err := l.Allow(ctx, "user")
+				assert.NoError(t, err)
+				assert.False(t, result.Allowed)
+				assert.Equal(t, 0, result.Remaining)
+				assert.InDelta(t, algorithm.retryAfter, result.RetryAfter, float64(time.Millisecond))
+				retryAfter := result.RetryAfter
+				assert.Equal(t, "1", result.Headers().Get("Retry-After"))
+				assert.Equal(t, "3", result.Headers().Get("X-RateLimit-Limit"))
+
+				// the other keys are limited separately
+				result, err = l.Allow(ctx, "another user")
+				assert.NoError(t, err)
+				assert.True(t, result.Allowed)
+
+				clock.Advance(retryAfter)
+				result, err = l.Allow(ctx, "user")
+				assert.NoError(t, err)
+				assert.True(t, result.Allowed)
+				if result.Remaining > 0 {
+					result, err = l.AllowN(ctx, "user", result.Remaining)
+					assert.NoError(t, err)
+					assert.True(t, result.Allowed)
+				}
+
+				reservation, err := l.Reserve(ctx, "user", 1)
+				assert.NoError(t, err)
+				assert.True(t, reservation.Allowed)
+				assert.InDelta(t, algorithm.retryAfter, reservation.Delay, float64(time.Millisecond))
+
+				timeout, cancel := context.WithTimeout(ctx, 10*time.Millisecond)
+				defer cancel()
+				_, err = l.Wait(timeout, "user", 1)
+				assert.Equal(t, ErrWaitTooLong, err)
+
+				_, err = l.AllowN(ctx, "user", 4)
+				assert.Equal(t, ErrBurstExceeded, err)
+				_, err = l.AllowN(ctx, "user", 0)
+				assert.Equal(t, ErrInvalidN, err)
+				_, err = l.Reserve(ctx, "user", -1)
+				assert.Equal(t, ErrInvalidN, err)
+
+				assert.NoError(t, l.Reset(ctx, "user"))
+				result, err = l.AllowN(ctx, "user", 3)
+				assert.NoError(t, err)
+				assert.True(t, result.Allowed)
+				assert.Equal(t, 0, result.Remaining)
+				assert.InDelta(t, time.Second, result.ResetAfter, float64(time.Millisecond))
+			})
+		}
+	}
+}
+
+func Test_LayeredCacheSharesLimit(t *testing.T) {
+	server := miniredis.RunT(t)
+	ctx := context.Background()
+
+	// two instances with their own near tier share the limit through redis
+	limiters := []*limiter{}
+	for i := 0; i < 2; i++ {
+		layered, err := cache.NewLayeredCache(cache.NewGRedis(&redis.UniversalOptions{Addrs: []string{server.Addr()}}), nil)
+		assert.NoError(t, err)
+		t.Cleanup(func() { _ = layered.Close() })
+		l, _ := newTestLimiter(t, NewTokenBucket, layered, PerSecond(3))
+		assert.IsType(t, &redisBackend{}, l.backend)
+		limiters = append(limiters, l)
+	}
+
+	for i := 0; i < 3; i++ {
+		result, err := limiters[i%2].Allow(ctx, "user")
+		assert.NoError(t, err)
+		assert.True(t, result.Allowed)
+	}
+	result, err := limiters[1].Allow(ctx, "user")
+	assert.NoError(t, err)
+	assert.False(t, result.Allowed)
+}
+
+func Test_LimiterWait(t *testing.T) {
+	for storeName, newStore := range newTestStores(t) {
+		t.Run(storeName, func(t *testing.T) {
+			l := NewGCRA(newStore(t), Limit{Rate: 1, Period: 50 * time.Millisecond})
+			ctx := context.Background()
+
+			start := time.Now()
+			for i := 0; i < 3; i++ {
+				_, err := l.Wait(ctx, "user", 1)
+				assert.NoError(t, err)
+			}
+			assert.GreaterOrEqual(t, time.Since(start), 90*time.Millisecond)
+		})
+	}
+}
+
+func Test_TokenBucketBurst(t *testing.T) {
+	l, clock := newTestLimiter(t, NewTokenBucket, newTestStores(t)["MemCache"](t), Limit{Rate: 1, Period: time.Second, Burst: 5})
+	ctx := context.Background()
+
+	result, err := l.AllowN(ctx, "user", 5)
+	assert.NoError(t, err)
+	assert.True(t, result.Allowed)
+
+	clock.Advance(2 * time.Second)
+	result, err = l.AllowN(ctx, "user", 3)
+	assert.NoError(t, err)
+	assert.False(t, result.Allowed)
+	assert.Equal(t, 2, result.Remaining)
+	assert.Equal(t, time.Second, result.RetryAfter)
+}
+
+func Test_InvalidLimit(t *testing.T) {
+	memCache, err := cache.NewMemCacheWithOptions(nil)
+	assert.NoError(t, err)
+	defer memCache.Close()
+
+	for _, newLimiter := range []func(cache.CacheInterface, Limit) Limiter{NewTokenBucket, NewSlidingWindowLog, NewGCRA} {
+		assert.PanicsWithValue(t, ErrInvalidLimit, func() { newLimiter(memCache, Limit{Rate: 0, Period: time.Second}) })
+		assert.PanicsWithValue(t, ErrInvalidLimit, func() { newLimiter(memCache, Limit{Rate: 1}) })
+	}
+}
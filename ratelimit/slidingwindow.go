@@ -0,0 +1,106 @@
+package ratelimit
+
+import (
+	"sort"
+	"time"
+
+	"github.com/dadiYazZ/xin-da-libs/cache"
+	"github.com/google/uuid"
+	"github.com/redis/go-redis/v9"
+)
+
+// SCRIPT_SLIDING_WINDOW_LOG logs the events of KEYS[1] in a sorted set scored by their time,
+// ARGV[4] is the window, ARGV[5] the limit and ARGV[6] a unique prefix for the new members
+const SCRIPT_SLIDING_WINDOW_LOG = `local now = tonumber(ARGV[1])
+local n = tonumber(ARGV[2])
+local max_delay = tonumber(ARGV[3])
+local period = tonumber(ARGV[4])
+local limit = tonumber(ARGV[5])
+
+redis.call('zremrangebyscore', KEYS[1], '-inf', now - period)
+local count = redis.call('zcard', KEYS[1])
+
+local at = now
+if count + n > limit then
+	local leaving = redis.call('zrange', KEYS[1], count + n - limit - 1, count + n - limit - 1, 'WITHSCORES')
+	at = tonumber(leaving[2]) + period
+end
+
+local delay = at - now
+local allowed = 1
+if delay > 0 and max_delay >= 0 and delay > max_delay then
+	allowed = 0
+end
+if allowed == 1 then
+	for i = 1, n do
+		redis.call('zadd', KEYS[1], at, ARGV[6] .. ':' .. i)
+	end
+	count = count + n
+end
+
+local reset = 0
+local newest = redis.call('zrange', KEYS[1], -1, -1, 'WITHSCORES')
+if newest[2] then
+	reset = tonumber(newest[2]) + period - now
+end
+if allowed == 1 then
+	redis.call('pexpire', KEYS[1], math.ceil(reset / 1000) + 1)
+end
+
+return {allowed, tostring(limit - count), string.format('%.17g', delay), string.format('%.17g', reset)}`
+
+var slidingWindowLogScript = redis.NewScript(SCRIPT_SLIDING_WINDOW_LOG)
+
+type slidingWindowLogState struct {
+	// Log holds the sorted times of the events in the window, in unix nano seconds
+	Log []int64 `json:"log"`
+}
+
+// NewSlidingWindowLog returns a limiter logging the time of each event, at most Rate of them happen in any Period.
+// It is exact but stores an entry per event, Burst is ignored.
+// The decisions are made by a Lua script on a GRedis or a LayeredCache, and locally on the other drivers.
+// It panics with ErrInvalidLimit unless Rate and Period are positive
+func NewSlidingWindowLog(store cache.CacheInterface, limit Limit) Limiter {
+	limit.mustValidate()
+	period := int64(limit.Period)
+
+	params := func() []interface{} {
+		return []interface{}{limit.Period.Microseconds(), limit.Rate, uuid.New().String()}
+	}
+
+	run := func(state *slidingWindowLogState, found bool, now time.Time, n int, maxDelay time.Duration) outcome {
+		nowNano := now.UnixNano()
+
+		start := 0
+		for start < len(state.Log) && state.Log[start] <= nowNano-period {
+			start++
+		}
+		log := state.Log[start:]
+
+		at := nowNano
+		if len(log)+n > limit.Rate {
+			at = log[len(log)+n-limit.Rate-1] + period
+		}
+
+		o := outcome{allowed: true, delay: time.Duration(at - nowNano)}
+		if o.delay > 0 && maxDelay >= 0 && o.delay > maxDelay {
+			o.allowed = false
+		}
+		if o.allowed {
+			for i := 0; i < n; i++ {
+				log = append(log, at)
+			}
+			sort.Slice(log, func(i, j int) bool { return log[i] < log[j] })
+			state.Log = log
+		}
+
+		o.remaining = float64(limit.Rate - len(log))
+		if len(log) > 0 {
+			o.reset = time.Duration(log[len(log)-1] + period - nowNano)
+		}
+
+		return o
+	}
+
+	return newLimiter(limit, "sliding_window_log", limit.Rate, newBackend(store, slidingWindowLogScript, params, run))
+}
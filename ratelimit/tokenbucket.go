@@ -0,0 +1,95 @@
+package ratelimit
+
+import (
+	"math"
+	"time"
+
+	"github.com/dadiYazZ/xin-da-libs/cache"
+	"github.com/redis/go-redis/v9"
+)
+
+// SCRIPT_TOKEN_BUCKET refills the bucket of KEYS[1] at ARGV[4] tokens per micro second up to ARGV[5] tokens
+const SCRIPT_TOKEN_BUCKET = `local now = tonumber(ARGV[1])
+local n = tonumber(ARGV[2])
+local max_delay = tonumber(ARGV[3])
+local rate = tonumber(ARGV[4])
+local burst = tonumber(ARGV[5])
+
+local state = redis.call('hmget', KEYS[1], 'tokens', 'updated')
+local tokens = tonumber(state[1])
+local updated = tonumber(state[2])
+if tokens == nil or updated == nil then
+	tokens = burst
+	updated = now
+end
+if now > updated then
+	tokens = math.min(burst, tokens + (now - updated) * rate)
+	updated = now
+end
+
+local allowed = 1
+local delay = 0
+if tokens < n then
+	delay = math.ceil((n - tokens) / rate)
+	if max_delay >= 0 and delay > max_delay then
+		allowed = 0
+	end
+end
+if allowed == 1 then
+	tokens = tokens - n
+end
+
+local reset = (burst - tokens) / rate
+if allowed == 1 then
+	redis.call('hset', KEYS[1], 'tokens', string.format('%.17g', tokens), 'updated', string.format('%.17g', updated))
+	redis.call('pexpire', KEYS[1], math.ceil(reset / 1000) + 1)
+end
+
+return {allowed, string.format('%.17g', tokens), string.format('%.17g', delay), string.format('%.17g', reset)}`
+
+var tokenBucketScript = redis.NewScript(SCRIPT_TOKEN_BUCKET)
+
+type tokenBucketState struct {
+	Tokens  float64 `json:"tokens"`
+	Updated int64   `json:"updated"`
+}
+
+// NewTokenBucket returns a limiter refilling a bucket of Burst tokens at Rate tokens per Period, each event taking a token.
+// The decisions are made by a Lua script on a GRedis or a LayeredCache, and locally on the other drivers.
+// It panics with ErrInvalidLimit unless Rate and Period are positive
+func NewTokenBucket(store cache.CacheInterface, limit Limit) Limiter {
+	limit.mustValidate()
+	rate := float64(limit.Rate) / float64(limit.Period) // tokens per nano second
+	burst := float64(limit.burst())
+
+	params := func() []interface{} {
+		return []interface{}{rate * float64(time.Microsecond), burst}
+	}
+
+	run := func(state *tokenBucketState, found bool, now time.Time, n int, maxDelay time.Duration) outcome {
+		nowNano := now.UnixNano()
+		if !found {
+			state.Tokens = burst
+			state.Updated = nowNano
+		}
+		if nowNano > state.Updated {
+			state.Tokens = math.Min(burst, state.Tokens+float64(nowNano-state.Updated)*rate)
+			state.Updated = nowNano
+		}
+
+		o := outcome{allowed: true}
+		if state.Tokens < float64(n) {
+			o.delay = time.Duration(math.Ceil((float64(n) - state.Tokens) / rate))
+			o.allowed = maxDelay < 0 || o.delay <= maxDelay
+		}
+		if o.allowed {
+			state.Tokens -= float64(n)
+		}
+		o.remaining = state.Tokens
+		o.reset = time.Duration(math.Ceil((burst - state.Tokens) / rate))
+
+		return o
+	}
+
+	return newLimiter(limit, "token_bucket", limit.burst(), newBackend(store, tokenBucketScript, params, run))
+}
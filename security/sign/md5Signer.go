@@ -31,6 +31,16 @@ func (signer *MD5Signer) Sign(msg string) (encodedSign string, err error) {
 	return encodedSign, err
 }
 
+// SignMessage 实现 MessageSigner, 与 Sign 相同
+func (signer *MD5Signer) SignMessage(msg string) (signature string, err error) {
+	return signer.Sign(msg)
+}
+
+// VerifyMessage 实现 MessageSigner, 与 Verify 相同
+func (signer *MD5Signer) VerifyMessage(msg string, signature string) error {
+	return signer.Verify(msg, signature)
+}
+
 func (signer *MD5Signer) Verify(msg string, signature string) (err error) {
 
 	signedMsg, err := signer.Sign(msg)
@@ -44,25 +54,7 @@ func (signer *MD5Signer) Verify(msg string, signature string) (err error) {
 }
 
 func (signer *MD5Signer) KSortDataToMessage(data *object.StringMap) (msg string, err error) {
-
-	// k sort
-	var keys []string
-	mapData := *data
-	for k := range mapData {
-		keys = append(keys, k)
-	}
-	sort.Strings(keys)
-
-	// join
-	for _, k := range keys {
-		//xin-da-fmt.Println("key:", k, "Value:", mapData[k])
-		msg = msg + k + "=" + mapData[k] + "&"
-	}
-
-	// omit last &
-	msg = msg[0 : len(msg)-1]
-
-	return msg, err
+	return KSortStringMap(data), err
 }
 
 func (signer *MD5Signer) KSortObjectToMessage(data *object.HashMap) (msg string, err error) {
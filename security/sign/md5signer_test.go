@@ -82,3 +82,29 @@ func Test_MD5_VerifySignedMessage(t *testing.T) {
 		t.Error(err)
 	}
 }
+
+func Test_KSortStringMap(t *testing.T) {
+
+	assert.EqualValues(t, "a=1&b=2&c=3", KSortStringMap(&object.StringMap{
+		"c": "3",
+		"a": "1",
+		"b": "2",
+	}))
+	assert.EqualValues(t, "", KSortStringMap(&object.StringMap{}))
+
+}
+
+func Test_MD5_SignMessage(t *testing.T) {
+
+	var signer MessageSigner
+	signer, err := NewMD5Signer("AppID+AppSecret")
+	if err != nil {
+		t.Error(err)
+	}
+
+	signature, err := signer.SignMessage("a=1&b=2")
+	assert.NoError(t, err)
+	assert.NoError(t, signer.VerifyMessage("a=1&b=2", signature))
+	assert.Error(t, signer.VerifyMessage("a=1&b=3", signature))
+
+}
@@ -4,6 +4,7 @@ import (
 	"crypto"
 	"crypto/rand"
 	"crypto/rsa"
+	"encoding/base64"
 	"github.com/dadiYazZ/xin-da-libs/security/encryption"
 )
 
@@ -83,3 +84,29 @@ func (signer *RSASigner) VerifySignPKCS1v15(digest []byte, signature []byte) (er
 	err = rsa.VerifyPKCS1v15(signer.RSAEncryptor.PublicKey, signer.RSAEncryptor.Hash, digest, signature)
 	return err
 }
+
+// SignMessage 实现 MessageSigner, 返回 base64 编码的 PKCS1v15 签名
+func (signer *RSASigner) SignMessage(msg string) (signature string, err error) {
+	digest, err := signer.Sign([]byte(msg))
+	if err != nil {
+		return "", err
+	}
+	b, err := signer.GenerateSignaturePKCS1v15(digest)
+	if err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(b), nil
+}
+
+// VerifyMessage 实现 MessageSigner, signature 为 base64 编码的 PKCS1v15 签名
+func (signer *RSASigner) VerifyMessage(msg string, signature string) error {
+	b, err := base64.StdEncoding.DecodeString(signature)
+	if err != nil {
+		return err
+	}
+	digest, err := signer.Sign([]byte(msg))
+	if err != nil {
+		return err
+	}
+	return signer.VerifySignPKCS1v15(digest, b)
+}
@@ -36,3 +36,32 @@ func Test_RSA_VerifySignedMessage(t *testing.T) {
 	}
 
 }
+
+func Test_RSA_SignMessage(t *testing.T) {
+
+	signer, err := NewRSASigner(crypto.SHA256)
+	if err != nil {
+		t.Error(err)
+	}
+
+	_, err = signer.RSAEncryptor.GenerateKey(2048)
+	if err != nil {
+		t.Error(err)
+	}
+
+	signature, err := signer.SignMessage("a=1&b=2")
+	if err != nil {
+		t.Error(err)
+	}
+
+	err = signer.VerifyMessage("a=1&b=2", signature)
+	if err != nil {
+		t.Error(err)
+	}
+
+	err = signer.VerifyMessage("a=1&b=3", signature)
+	if err == nil {
+		t.Error("tampered message verified")
+	}
+
+}
@@ -0,0 +1,31 @@
+package sign
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/dadiYazZ/xin-da-libs/object"
+)
+
+// MessageSigner 对规范化后的消息签名, 签名是可以直接放入请求头或参数的字符串.
+// MD5Signer 返回十六进制的摘要, RSASigner 返回 base64 编码的 PKCS1v15 签名
+type MessageSigner interface {
+	SignMessage(msg string) (signature string, err error)
+	VerifyMessage(msg string, signature string) error
+}
+
+// KSortStringMap 将参数按 key 排序后拼接为 "a=1&b=2", 与 MD5Signer.KSortDataToMessage 相同, 空参数返回空字符串
+func KSortStringMap(data *object.StringMap) string {
+	mapData := *data
+	keys := make([]string, 0, len(mapData))
+	for k := range mapData {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	pairs := make([]string, 0, len(keys))
+	for _, k := range keys {
+		pairs = append(pairs, k+"="+mapData[k])
+	}
+	return strings.Join(pairs, "&")
+}